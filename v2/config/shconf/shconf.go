@@ -16,11 +16,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
-
-	"github.com/p3ls/osutil/v2/edi"
+	"syscall"
 )
 
 var (
@@ -192,6 +194,8 @@ func (c *Config) Getfloat(key string) (float64, error) {
 }
 
 // Set writes a new value for key.
+// The value is written as it is, and the file is replaced through a temporary
+// file.
 func (c *Config) Set(key, value string) error {
 	c.Lock()
 	defer c.Unlock()
@@ -199,15 +203,134 @@ func (c *Config) Set(key, value string) error {
 	if _, found := c.data[key]; !found {
 		return ErrKey
 	}
+	return c.set("", []string{key, value})
+}
+
+// SetValues writes the pairs of key and value given in kv; the keys not found
+// are added at the end of the file, using the separator of the file, or else sep
+// whether the file has not entries.
+// The values are written as they are, and the file is replaced atomically
+// through a temporary file, with the same permissions and owner.
+func (c *Config) SetValues(sep string, kv ...string) error {
+	if len(kv)%2 != 0 {
+		return errors.New("shconf: odd number of arguments for the pairs of key and value")
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	return c.set(sep, kv)
+}
+
+// set writes the pairs of key and value into the file.
+func (c *Config) set(sep string, kv []string) error {
+	if len(c.separator) != 0 {
+		sep = string(c.separator)
+	}
+
+	// The separator could be different in every line, i.e. in the number of
+	// spaces, so it is matched by its kind.
+	reSeparator := `[ \t]+`
+	if strings.Contains(sep, "=") {
+		reSeparator = `[ \t]*=[ \t]*`
+	}
+
+	b, err := os.ReadFile(c.filename)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	var add strings.Builder
+
+	for i := 0; i < len(kv); i += 2 {
+		key, value := kv[i], kv[i+1]
+
+		if _, found := c.data[key]; !found {
+			add.WriteString(key + sep + value + "\n")
+			continue
+		}
+		reKey := regexp.MustCompile("^" + regexp.QuoteMeta(key) + reSeparator)
+
+		for j, line := range lines {
+			if !reKey.MatchString(line) {
+				continue
+			}
+			newLine := key + sep + value
+			if strings.HasSuffix(line, "\n") {
+				newLine += "\n"
+			}
+			lines[j] = newLine
+		}
+	}
 
-	separator := string(c.Separator())
-	replAt := []edi.ReplacerAtLine{
-		{Line: key + separator, Search: separator + ".*", Replace: separator + value},
+	data := strings.Join(lines, "")
+	if add.Len() != 0 {
+		if data != "" && !strings.HasSuffix(data, "\n") {
+			data += "\n"
+		}
+		data += add.String()
 	}
 
-	if err := edi.ReplaceAtLine(c.filename, nil, replAt); err != nil {
+	if err = writeFile(c.filename, []byte(data)); err != nil {
 		return err
 	}
-	c.data[key] = value
+	for i := 0; i < len(kv); i += 2 {
+		c.data[kv[i]] = kv[i+1]
+	}
+	if len(c.separator) == 0 && len(kv) != 0 {
+		c.separator = []byte(sep)
+	}
 	return nil
 }
+
+// writeFile replaces the named file through a temporary file, with the same
+// permissions and owner. Both file and directory are committed to disk, so the
+// readers get the old content or the new one.
+func writeFile(name string, b []byte) (err error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+
+	f, err := os.CreateTemp(dir, filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = f.Chmod(info.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err = f.Chown(int(st.Uid), int(st.Gid)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -10,6 +10,8 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -130,3 +132,102 @@ func createTempFile(separator rune) (fname string, err error) {
 
 	return file.Name(), nil
 }
+
+func TestSet(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "login.defs")
+	data := "# comment with KEY\nKEY\t\tvalue\nOTHER   other\n#KEY commented\nLAST last"
+	if err := os.WriteFile(fname, []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ParseFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The references of the regular expressions are not expanded.
+	for _, v := range []string{"$1", "${HOME}/$2", `\1$$`} {
+		if err = cfg.Set("KEY", v); err != nil {
+			t.Fatal(err)
+		}
+		want := "# comment with KEY\nKEY\t\t" + v + "\nOTHER   other\n#KEY commented\nLAST last"
+		b, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("expected %q, got %q", want, b)
+		}
+	}
+
+	// Line without the new line character.
+	if err = cfg.Set("LAST", "$0"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "\nLAST\t\t$0") {
+		t.Errorf("expected to set the last line, got %q", b)
+	}
+
+	info, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected to keep the permissions, got %v", info.Mode().Perm())
+	}
+
+	// The values are got again from the file.
+	if cfg, err = ParseFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cfg.Get("OTHER"); v != "other" {
+		t.Errorf("expected value %q, got %q", "other", v)
+	}
+}
+
+func TestSetValues(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "useradd")
+	if err := os.WriteFile(fname, []byte("HOME=/home\nSHELL=/bin/sh"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cfg.SetValues("\t", "SHELL"); err == nil {
+		t.Error("expected to report the odd number of arguments")
+	}
+	if err = cfg.SetValues("\t", "SHELL", "/bin/bash", "SKEL", "/etc/skel"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "HOME=/home\nSHELL=/bin/bash\nSKEL=/etc/skel\n"; string(b) != want {
+		t.Errorf("expected %q, got %q", want, b)
+	}
+	if v, _ := cfg.Get("SKEL"); v != "/etc/skel" {
+		t.Errorf("expected value %q, got %q", "/etc/skel", v)
+	}
+
+	// File without entries.
+	fname = filepath.Join(t.TempDir(), "login.defs")
+	if err = os.WriteFile(fname, []byte("# comment\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = ParseFile(fname); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.SetValues("\t", "UMASK", "022"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ = os.ReadFile(fname); string(b) != "# comment\nUMASK\t022\n" {
+		t.Errorf("expected to add the key, got %q", b)
+	}
+}
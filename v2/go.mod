@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/smartystreets/goconvey v1.6.4 // indirect
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	gopkg.in/ini.v1 v1.62.0
 )
 
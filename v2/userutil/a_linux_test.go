@@ -55,6 +55,18 @@ func init() {
 	}
 	removeFiles = append(removeFiles, fileShadow)
 
+	for _, f := range []*string{&fileSubUID, &fileSubGID} {
+		if found, _ := exist(*f); found {
+			if *f, err = fileutil.CopytoTemp(*f); err != nil {
+				goto _error
+			}
+			removeFiles = append(removeFiles, *f)
+		}
+	}
+
+	// The databases of the system are not locked.
+	filePwdLock = filepath.Join(os.TempDir(), ".pwd.lock_userutil")
+
 	if useGshadow {
 		if fileGShadow, err = fileutil.CopytoTemp(fileGShadow); err != nil {
 			goto _error
@@ -82,4 +94,6 @@ func removeTempFiles() {
 			}
 		}
 	}
+
+	os.Remove(filePwdLock)
 }
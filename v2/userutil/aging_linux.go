@@ -0,0 +1,182 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"fmt"
+	"time"
+)
+
+// == Setters
+//
+
+// SetLastChange sets the date of the last password change.
+// If t is nil, the features of password aging are disabled.
+func (s *Shadow) SetLastChange(t *time.Time) {
+	if t == nil {
+		s.changed = _DISABLE_AGING
+		return
+	}
+	s.changed = changeType(secToDay(t.Unix()))
+}
+
+// LastChange returns the date of the last password change.
+// The boolean is false whether the password aging is disabled, or whether the
+// user has to change her password the next time he will log in.
+func (s *Shadow) LastChange() (time.Time, bool) {
+	if s.changed <= _CHANGE_PASSWORD {
+		return time.Time{}, false
+	}
+	return dayToTime(int(s.changed)), true
+}
+
+// Expire returns the date of expiration of the account.
+// The boolean is false whether the account will never expire.
+func (s *Shadow) Expire() (time.Time, bool) {
+	if s.expire <= 0 {
+		return time.Time{}, false
+	}
+	return dayToTime(s.expire), true
+}
+
+// Chage changes the aging information of an user, like "chage(1)".
+// The values are got from s, for the fields: S_CHANGED, S_MIN, S_MAX, S_WARN,
+// S_INACTIVE and S_EXPIRE.
+//
+// To remove the value of a numeric field, it is used 0 or -1.
+func Chage(name string, s *Shadow, fields shadowField) error {
+	return defaultRoot.Chage(name, s, fields)
+}
+
+// Chage changes the aging information of an user in the root directory.
+func (r *Root) Chage(name string, s *Shadow, fields shadowField) error {
+	tx := r.beginAction("Chage")
+
+	if err := tx.Chage(name, s, fields); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Chage stages the change of the aging information of an user.
+func (tx *Tx) Chage(name string, s *Shadow, fields shadowField) error {
+	for _, v := range []struct {
+		field shadowField
+		value int
+	}{
+		{S_MIN, s.Min},
+		{S_MAX, s.Max},
+		{S_WARN, s.Warn},
+		{S_INACTIVE, s.Inactive},
+		{S_EXPIRE, s.expire},
+	} {
+		if fields&v.field != 0 && v.value < -1 {
+			return fmt.Errorf("invalid value for field %s: %d", v.field, v.value)
+		}
+	}
+
+	shadow, err := tx.LookupShadow(name)
+	if err != nil {
+		return err
+	}
+
+	if fields&S_CHANGED != 0 {
+		shadow.changed = s.changed
+	}
+	if fields&S_MIN != 0 {
+		shadow.Min = emptyDays(s.Min)
+	}
+	if fields&S_MAX != 0 {
+		shadow.Max = emptyDays(s.Max)
+	}
+	if fields&S_WARN != 0 {
+		shadow.Warn = emptyDays(s.Warn)
+	}
+	if fields&S_INACTIVE != 0 {
+		shadow.Inactive = emptyDays(s.Inactive)
+	}
+	if fields&S_EXPIRE != 0 {
+		shadow.expire = emptyDays(s.expire)
+	}
+
+	return tx.EditShadow(name, shadow)
+}
+
+// emptyDays returns 0, which is written like an empty field, for the value -1.
+func emptyDays(days int) int {
+	if days == -1 {
+		return 0
+	}
+	return days
+}
+
+// == Evaluators
+//
+
+// agingEnabled reports whether the password aging applies, that is when there
+// is a date of last change and a maximum password age.
+func (s *Shadow) agingEnabled() bool {
+	return s.changed > _CHANGE_PASSWORD && s.Max > 0
+}
+
+// PasswordExpired reports whether the password has expired at the given time,
+// because it is older than the maximum password age.
+func (s *Shadow) PasswordExpired(now time.Time) bool {
+	if !s.agingEnabled() {
+		return false
+	}
+	return secToDay(now.Unix()) >= int(s.changed)+s.Max
+}
+
+// PasswordInactive reports whether the password has expired at the given time,
+// and the inactivity period after of its expiration is also elapsed; so no
+// login is possible using the password.
+func (s *Shadow) PasswordInactive(now time.Time) bool {
+	if !s.agingEnabled() || s.Inactive <= 0 {
+		return false
+	}
+	return secToDay(now.Unix()) >= int(s.changed)+s.Max+s.Inactive
+}
+
+// AccountExpired reports whether the account has expired at the given time.
+func (s *Shadow) AccountExpired(now time.Time) bool {
+	if s.expire <= 0 {
+		return false
+	}
+	return secToDay(now.Unix()) >= s.expire
+}
+
+// DaysUntilPasswordExpiry returns the number of days, since the given time,
+// until the password expires; it is negative whether it has already expired.
+// The boolean is false whether the password does not expire.
+func (s *Shadow) DaysUntilPasswordExpiry(now time.Time) (int, bool) {
+	if !s.agingEnabled() {
+		return 0, false
+	}
+	return int(s.changed) + s.Max - secToDay(now.Unix()), true
+}
+
+// MustChangeAtNextLogin reports whether the user has to change her password the
+// next time he will log in: because it has been set so, or because the password
+// has expired at the given time but it is still into the inactivity period.
+func (s *Shadow) MustChangeAtNextLogin(now time.Time) bool {
+	if s.changed == _CHANGE_PASSWORD {
+		return true
+	}
+	return s.PasswordExpired(now) && !s.PasswordInactive(now)
+}
+
+// InWarnPeriod reports whether the user should be warned, at the given time,
+// because her password is going to expire.
+func (s *Shadow) InWarnPeriod(now time.Time) bool {
+	days, ok := s.DaysUntilPasswordExpiry(now)
+	if !ok || s.Warn <= 0 {
+		return false
+	}
+	return days > 0 && days <= s.Warn
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgingEvaluators(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	today := secToDay(now.Unix())
+
+	s := &Shadow{changed: changeType(today - 80), Max: 90, Warn: 14, Inactive: 5}
+	if s.PasswordExpired(now) {
+		t.Error("password not expired yet")
+	}
+	if days, ok := s.DaysUntilPasswordExpiry(now); !ok || days != 10 {
+		t.Errorf("expected 10 days until expiry, got %d", days)
+	}
+	if !s.InWarnPeriod(now) {
+		t.Error("expected to be into the warning period")
+	}
+
+	s.changed = changeType(today - 92)
+	if !s.PasswordExpired(now) || !s.MustChangeAtNextLogin(now) {
+		t.Error("expected the password expired, into the inactivity period")
+	}
+	if s.PasswordInactive(now) {
+		t.Error("inactivity period not elapsed yet")
+	}
+
+	s.changed = changeType(today - 100)
+	if !s.PasswordInactive(now) || s.MustChangeAtNextLogin(now) {
+		t.Error("expected the inactivity period elapsed")
+	}
+
+	s.SetChangePasswd()
+	if !s.MustChangeAtNextLogin(now) || s.PasswordExpired(now) {
+		t.Error("expected to have to change the password")
+	}
+	s.DisableAging()
+	if _, ok := s.DaysUntilPasswordExpiry(now); ok {
+		t.Error("expected the password aging disabled")
+	}
+
+	expire := now.AddDate(0, 0, -1)
+	s.SetExpire(&expire)
+	if !s.AccountExpired(now) {
+		t.Error("expected the account expired")
+	}
+	if got, ok := s.Expire(); !ok || got.Format("2006-01-02") != "2021-05-31" {
+		t.Errorf("unexpected date of expiration: %s", got)
+	}
+	s.SetExpire(nil)
+	if s.AccountExpired(now) {
+		t.Error("expected the account without expiration")
+	}
+}
+
+func TestChage(t *testing.T) {
+	r := newTestRoot(t)
+
+	if _, err := r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	mod := &Shadow{Min: 1, Max: 60, Warn: -1, Inactive: 10}
+	last := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	mod.SetLastChange(&last)
+	mod.SetExpire(&last)
+
+	err := r.Chage(USER, mod, S_CHANGED|S_MIN|S_MAX|S_WARN|S_INACTIVE|S_EXPIRE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Chage(USER, &Shadow{Max: -2}, S_MAX); err == nil {
+		t.Error("expected to report an invalid value")
+	}
+
+	s, err := r.LookupShadow(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Min != 1 || s.Max != 60 || s.Warn != 0 || s.Inactive != 10 {
+		t.Errorf("aging not changed: %s", s)
+	}
+	if got, ok := s.LastChange(); !ok || !got.Equal(last) {
+		t.Errorf("expected last change at %s, got %s", last, got)
+	}
+	if !s.AccountExpired(time.Now()) {
+		t.Error("expected the account expired")
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"encoding/json"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p3ls/osutil/v2/sysutil"
+)
+
+// An AuditOp represents the kind of change of an entry.
+type AuditOp int
+
+const (
+	AUDIT_ADD AuditOp = iota + 1
+	AUDIT_EDIT
+	AUDIT_DEL
+
+	// Side effects out of the databases.
+	AUDIT_CREATE_HOME // Home directory created.
+	AUDIT_REMOVE_HOME // Home directory or mail spool removed.
+	AUDIT_KILL        // Process of the user terminated.
+	AUDIT_CHOWN       // File of the user reassigned to another owner.
+)
+
+func (op AuditOp) String() string {
+	switch op {
+	case AUDIT_ADD:
+		return "add"
+	case AUDIT_EDIT:
+		return "edit"
+	case AUDIT_DEL:
+		return "del"
+	case AUDIT_CREATE_HOME:
+		return "create-home"
+	case AUDIT_REMOVE_HOME:
+		return "remove-home"
+	case AUDIT_KILL:
+		return "kill"
+	case AUDIT_CHOWN:
+		return "chown"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the operation as its name.
+func (op AuditOp) MarshalText() ([]byte, error) { return []byte(op.String()), nil }
+
+// An AuditEvent represents a change done in a database of accounts, or in the
+// configuration, or a side effect on the resources of an user.
+type AuditEvent struct {
+	Time  time.Time
+	Actor string // Login name of the real user; see RealUser.
+
+	Op AuditOp
+
+	// Function which did the change, i.e. "ChPasswd" or "LockUser". It is empty
+	// for the transactions started by the caller (see Begin).
+	Action string
+
+	File   string // Database, configuration file or path changed; empty at killing.
+	Target string // Name of the user or group; empty for the configuration.
+
+	// Fields changed. At adding or removing an entry, there are all its fields.
+	Fields []AuditField
+}
+
+// An AuditField represents the change of a field.
+// The passwords are redacted; only their state is kept (see redactPassword).
+type AuditField struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// An AuditSink receives the events of changes, after they have been written.
+type AuditSink interface {
+	Audit(ev *AuditEvent)
+}
+
+// The AuditFunc type is an adapter to use a function as AuditSink.
+type AuditFunc func(ev *AuditEvent)
+
+// Audit calls f(ev).
+func (f AuditFunc) Audit(ev *AuditEvent) { f(ev) }
+
+// SetAuditSink sets the sink of the events of changes in the running system.
+// See Root.SetAuditSink.
+func SetAuditSink(s AuditSink) { defaultRoot.SetAuditSink(s) }
+
+// SetAuditSink sets the sink of the events of changes in the root directory.
+// With nil, the events are not generated.
+//
+// The events are sent at committing every transaction, at changing the
+// configuration, and at handling the home directory, the processes and the
+// files of an user. It is not safe to call it while the root is used by another
+// goroutine.
+func (r *Root) SetAuditSink(s AuditSink) { r.audit = s }
+
+// emitAudit sends the events to the sink, setting the time and the actor.
+func (r *Root) emitAudit(events []*AuditEvent) {
+	if r.audit == nil {
+		return
+	}
+	now := time.Now()
+	actor := auditActor()
+
+	for _, ev := range events {
+		ev.Time, ev.Actor = now, actor
+		r.audit.Audit(ev)
+	}
+}
+
+// emitEffect sends the event of a side effect on the resources of an user.
+func (r *Root) emitEffect(action string, op AuditOp, file, target string, fields ...AuditField) {
+	r.emitAudit([]*AuditEvent{
+		{Op: op, Action: action, File: file, Target: target, Fields: fields},
+	})
+}
+
+var actor struct {
+	name string
+	sync.Once
+}
+
+// auditActor returns the login name of the real user, or else the one of the
+// current user.
+func auditActor() string {
+	actor.Do(func() {
+		if name, err := RealUser(sysutil.Linux); err == nil && name != "" {
+			actor.name = name
+		} else if u, err := user.Current(); err == nil {
+			actor.name = u.Username
+		}
+	})
+	return actor.name
+}
+
+// == Differences
+//
+
+// auditEvents returns the events of the changes staged in the files.
+func auditEvents(files []*txFile) []*AuditEvent {
+	var events []*AuditEvent
+
+	for _, f := range files {
+		columns, secret := auditColumns(f.row)
+		_, isSubID := f.row.(*SubID)
+
+		// The key of a range of subordinate ids is the full row, since an user
+		// can have several ones.
+		key := func(line string) string {
+			if isSubID {
+				return line
+			}
+			return rowName(line)
+		}
+		index := func(lines []string) ([]string, map[string]string) {
+			keys := make([]string, 0, len(lines))
+			rows := make(map[string]string, len(lines))
+
+			for _, line := range lines {
+				k := key(line)
+				if _, ok := rows[k]; !ok {
+					keys = append(keys, k)
+					rows[k] = line
+				}
+			}
+			return keys, rows
+		}
+
+		oldKeys, oldRows := index(splitLines(f.orig))
+		newKeys, newRows := index(f.lines)
+
+		for _, k := range newKeys {
+			oldLine, found := oldRows[k]
+			newLine := newRows[k]
+
+			switch {
+			case !found:
+				events = append(events, &AuditEvent{
+					Op: AUDIT_ADD, File: f.name, Target: rowName(newLine),
+					Fields: diffColumns(columns, secret, "", newLine),
+				})
+			case oldLine != newLine:
+				events = append(events, &AuditEvent{
+					Op: AUDIT_EDIT, File: f.name, Target: rowName(newLine),
+					Fields: diffColumns(columns, secret, oldLine, newLine),
+				})
+			}
+		}
+		for _, k := range oldKeys {
+			if _, found := newRows[k]; !found {
+				oldLine := oldRows[k]
+				events = append(events, &AuditEvent{
+					Op: AUDIT_DEL, File: f.name, Target: rowName(oldLine),
+					Fields: diffColumns(columns, secret, oldLine, ""),
+				})
+			}
+		}
+	}
+	return events
+}
+
+// auditColumns returns the names of the fields of the rows, in order, and the
+// index of the password, if any (else, -1).
+func auditColumns(_row row) (columns []string, secret int) {
+	switch _row.(type) {
+	case *User:
+		return []string{"Name", "Passwd", "UID", "GID", "GECOS", "Dir", "Shell"}, 1
+	case *Shadow:
+		return []string{"Name", "Passwd", "Changed", "Min", "Max", "Warn", "Inactive",
+			"Expire", "Flag"}, 1
+	case *Group:
+		return []string{"Name", "Passwd", "GID", "Member"}, 1
+	case *GShadow:
+		return []string{"Name", "Passwd", "Admin", "Member"}, 1
+	case *SubID:
+		return []string{"Name", "Start", "Count"}, -1
+	}
+	return nil, -1
+}
+
+// diffColumns returns the fields which differ between both rows; an empty row
+// is the one which does not exist.
+func diffColumns(columns []string, secret int, oldLine, newLine string) []AuditField {
+	var oldValues, newValues []string
+	if oldLine != "" {
+		oldValues = strings.Split(oldLine, ":")
+	}
+	if newLine != "" {
+		newValues = strings.Split(newLine, ":")
+	}
+
+	n := len(oldValues)
+	if len(newValues) > n {
+		n = len(newValues)
+	}
+
+	var fields []AuditField
+	for i := 0; i < n; i++ {
+		var field AuditField
+		if i < len(oldValues) {
+			field.Old = oldValues[i]
+		}
+		if i < len(newValues) {
+			field.New = newValues[i]
+		}
+		if field.Old == field.New && oldLine != "" && newLine != "" {
+			continue
+		}
+
+		if i < len(columns) {
+			field.Name = columns[i]
+		} else {
+			field.Name = "Field" + strconv.Itoa(i)
+		}
+		if i == secret {
+			field.Old, field.New = redactPassword(field.Old), redactPassword(field.New)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// rowName returns the name of the user or group of the row.
+func rowName(line string) string {
+	if i := strings.IndexByte(line, ':'); i != -1 {
+		return line[:i]
+	}
+	return line
+}
+
+// redacted replaces a hashed password.
+const redacted = "<redacted>"
+
+// redactPassword hides the password, but keeps its state: the lock characters
+// '!', and the values which are not hashes, i.e. "x", "*" or empty.
+func redactPassword(s string) string {
+	pw := strings.TrimLeft(s, string(lockChar))
+
+	switch pw {
+	case "", "x", "*":
+		return s
+	}
+	return s[:len(s)-len(pw)] + redacted
+}
+
+// == Sinks
+//
+
+// jsonSink writes every event as a JSON object in a line.
+type jsonSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditSink returns a sink which writes every event to w as a JSON
+// object in a line. The errors at writing are ignored.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+func (s *jsonSink) Audit(ev *AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enc.Encode(ev)
+}
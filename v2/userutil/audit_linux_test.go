@@ -0,0 +1,152 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	r := newTestRoot(t)
+
+	var events []*AuditEvent
+	r.SetAuditSink(AuditFunc(func(ev *AuditEvent) { events = append(events, ev) }))
+
+	// findEvent returns the fields of the event found, checking that there is
+	// only one for the file.
+	findEvent := func(op AuditOp, action, file, target string) map[string]AuditField {
+		t.Helper()
+		var found *AuditEvent
+
+		for _, ev := range events {
+			if ev.File != r.join(file) {
+				continue
+			}
+			if found != nil {
+				t.Fatalf("%s: expected only one event, got another one: %+v", file, ev)
+			}
+			found = ev
+		}
+		if found == nil {
+			t.Fatalf("%s: event not found in %v", file, events)
+		}
+		if found.Op != op || found.Action != action || found.Target != target || found.Time.IsZero() {
+			t.Fatalf("%s: event not expected: %+v", file, found)
+		}
+
+		fields := make(map[string]AuditField)
+		for _, f := range found.Fields {
+			fields[f.Name] = f
+		}
+		events = nil
+		return fields
+	}
+
+	if _, err := r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	fields := findEvent(AUDIT_ADD, "AddUser", fileShadow, USER)
+	if f := fields["Passwd"]; f.Old != "" || f.New != "*" {
+		t.Errorf("field not expected: %+v", f)
+	}
+
+	events = nil
+	if err := r.ChPasswd(USER, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.LookupShadow(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields = findEvent(AUDIT_EDIT, "ChPasswd", fileShadow, USER)
+	if f := fields["Passwd"]; f.Old != "*" || f.New != redacted {
+		t.Errorf("field not expected: %+v", f)
+	}
+	for _, f := range fields {
+		if strings.Contains(f.New, s.password) {
+			t.Errorf("expected to redact the password: %+v", f)
+		}
+	}
+
+	if err = r.LockUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	fields = findEvent(AUDIT_EDIT, "LockUser", fileShadow, USER)
+	if f := fields["Passwd"]; f.Old != redacted || f.New != "!"+redacted || len(fields) != 1 {
+		t.Errorf("fields not expected: %+v", fields)
+	}
+
+	if err = r.AddUsersToGroup("users", USER); err != nil {
+		t.Fatal(err)
+	}
+	if f := findEvent(AUDIT_EDIT, "AddUsersToGroup", fileGroup, "users")["Member"]; f.Old != "" || f.New != USER {
+		t.Errorf("field not expected: %+v", f)
+	}
+
+	events = nil
+	if err = r.DelUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	if f := findEvent(AUDIT_DEL, "DelUser", fileUser, USER)["Name"]; f.Old != USER || f.New != "" {
+		t.Errorf("field not expected: %+v", f)
+	}
+
+	if err = r.SetLoginDefs(&LoginDefs{PassMaxDays: 90}, LD_PASS_MAX_DAYS); err != nil {
+		t.Fatal(err)
+	}
+	if f := findEvent(AUDIT_EDIT, "", fileLogin, "")["PASS_MAX_DAYS"]; f.Old != "99999" || f.New != "90" {
+		t.Errorf("field not expected: %+v", f)
+	}
+
+	// Side effects
+	if _, err = r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	if err = r.CreateHome(USER, ""); err != nil {
+		t.Fatal(err)
+	}
+	u, err := r.LookupUser(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findEvent(AUDIT_CREATE_HOME, "CreateHome", u.Dir, USER)
+
+	if err = r.DelUserWith(USER, DEL_HOME); err != nil {
+		t.Fatal(err)
+	}
+	delEvents := events
+	findEvent(AUDIT_REMOVE_HOME, "DelUserWith", u.Dir, USER)
+	events = delEvents
+	findEvent(AUDIT_DEL, "DelUserWith", fileUser, USER)
+
+	// Rolled back
+	tx := r.Begin()
+	if _, err = tx.AddGroup(NewGroup(GROUP)); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if len(events) != 0 {
+		t.Errorf("expected no events, got %v", events)
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewJSONAuditSink(&buf)
+
+	s.Audit(&AuditEvent{Op: AUDIT_DEL, File: fileUser, Target: USER,
+		Fields: []AuditField{{Name: "Name", Old: USER}}})
+
+	out := buf.String()
+	if !strings.HasSuffix(out, "}\n") || !strings.Contains(out, `"Op":"del"`) ||
+		!strings.Contains(out, `"Target":"`+USER+`"`) {
+		t.Errorf("output not expected: %s", out)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+)
+
+var (
+	ErrAuthFailed     = errors.New("user or passwd not valid")
+	ErrPasswdEmpty    = errors.New("passwd is empty")
+	ErrPasswdLocked   = errors.New("passwd is locked")
+	ErrPasswdDisabled = errors.New("passwd is disabled")
+	ErrAccountExpired = errors.New("account has expired")
+	ErrPasswdExpired  = errors.New("passwd has expired")
+	ErrPasswdInactive = errors.New("passwd has expired and its inactivity period has elapsed")
+)
+
+// An AuthError records an user who could not be authenticated, and the reason.
+//
+// The reason is ErrAuthFailed whether the user does not exist or the passwd is
+// wrong, so both cases can not be distinguished. With ErrAccountExpired,
+// ErrPasswdInactive and ErrPasswdExpired, the passwd was right.
+//
+// With ErrPasswdInactive, the account is locked like for "login(1)"; only with
+// ErrPasswdExpired, the passwd can be changed by the user.
+type AuthError struct {
+	Name string
+	Err  error
+}
+
+func (e *AuthError) Error() string {
+	return "authentication of " + strconv.Quote(e.Name) + ": " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// Authenticate checks the passwd of the given user, supplied in clear-text,
+// against its shadowed passwd. It returns an error of type *AuthError whether
+// the user can not be authenticated.
+func Authenticate(name string, key []byte) error { return defaultRoot.Authenticate(name, key) }
+
+// Authenticate checks the passwd of the given user, supplied in clear-text,
+// against its shadowed passwd in the root directory. It returns an error of
+// type *AuthError whether the user can not be authenticated.
+//
+// A key is always hashed, even when the user does not exist or its passwd can
+// not be used, so the time spent does not report whether the user exists.
+func (r *Root) Authenticate(name string, key []byte) error {
+	shadow, err := r.LookupShadow(name)
+	if err != nil {
+		if _, ok := err.(NoFoundError); !ok {
+			return err
+		}
+		r.hashPasswd(key, 0)
+		return &AuthError{name, ErrAuthFailed}
+	}
+
+	if err = checkPasswd(shadow.password); err != nil {
+		r.hashPasswd(key, 0)
+		return &AuthError{name, err}
+	}
+	if err = verifyHash(shadow.password, key); err != nil {
+		if err == crypt.ErrKeyMismatch {
+			err = ErrAuthFailed
+		}
+		return &AuthError{name, err}
+	}
+
+	now := time.Now()
+	if shadow.AccountExpired(now) {
+		return &AuthError{name, ErrAccountExpired}
+	}
+	if shadow.PasswordInactive(now) {
+		return &AuthError{name, ErrPasswdInactive}
+	}
+	if shadow.changed == _CHANGE_PASSWORD || shadow.PasswordExpired(now) {
+		return &AuthError{name, ErrPasswdExpired}
+	}
+	return nil
+}
+
+// checkPasswd returns an error whether the shadowed passwd can not be used to
+// authenticate, because it is not a hash of a known crypt function.
+func checkPasswd(hashedKey string) error {
+	switch {
+	case hashedKey == "":
+		return ErrPasswdEmpty
+	case hashedKey[0] == lockChar:
+		return ErrPasswdLocked
+	case hashedKey[0] == '*':
+		return ErrPasswdDisabled
+	}
+	if _, ok := crypt.FromHash(hashedKey); !ok {
+		return ErrUnknownHash
+	}
+	return nil
+}
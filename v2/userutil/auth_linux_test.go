@@ -0,0 +1,77 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+)
+
+func TestAuthenticate(t *testing.T) {
+	r := newTestRoot(t)
+	r.SetCrypter(crypt.SHA256)
+
+	if err := r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Authenticate("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name, key string, want error) {
+		t.Helper()
+		err := r.Authenticate(name, []byte(key))
+		if _, ok := err.(*AuthError); !ok || !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+	check("daemon", "wrong", ErrAuthFailed)
+	check("foo", "secret", ErrAuthFailed)
+	check("nobody", "secret", ErrPasswdDisabled)
+
+	if err := r.LockUser("daemon"); err != nil {
+		t.Fatal(err)
+	}
+	check("daemon", "secret", ErrPasswdLocked)
+	if err := r.UnlockUser("daemon"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes in the shadowed passwd.
+	setShadow := func(f func(s *Shadow)) {
+		t.Helper()
+		s, err := r.LookupShadow("daemon")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(s)
+		if err = edit(r, "ChPasswd", "daemon", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setShadow(func(s *Shadow) { s.expire = 1 })
+	check("daemon", "secret", ErrAccountExpired)
+	check("daemon", "wrong", ErrAuthFailed)
+	setShadow(func(s *Shadow) { s.expire = -1 })
+
+	setShadow(func(s *Shadow) { s.SetChangePasswd() })
+	check("daemon", "secret", ErrPasswdExpired)
+
+	setShadow(func(s *Shadow) { s.changed, s.Max, s.Inactive = 1, 10, -1 })
+	check("daemon", "secret", ErrPasswdExpired)
+	setShadow(func(s *Shadow) { s.Inactive = 5 })
+	check("daemon", "secret", ErrPasswdInactive)
+
+	setShadow(func(s *Shadow) { s.password = "" })
+	check("daemon", "", ErrPasswdEmpty)
+
+	setShadow(func(s *Shadow) { s.password = "abJnggxhB/yWI" }) // DES
+	check("daemon", "secret", ErrUnknownHash)
+}
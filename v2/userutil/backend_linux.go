@@ -0,0 +1,472 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Store represents a database of accounts, as the rows in the format of its
+// file: "passwd(5)", "group(5)", "shadow(5)" or "gshadow(5)".
+type Store interface {
+	// Load returns the rows of the database, without the new line character.
+	// The slice can be modified by the caller.
+	Load() ([]string, error)
+
+	// Save replaces the rows of the database.
+	Save(lines []string) error
+}
+
+// A Backend represents the storage of the databases of users and groups.
+//
+// The default one is the file backend, which handles the files of the root
+// directory; see NewRootWith and SetDefaultBackend to use another one.
+type Backend interface {
+	Users() Store
+	Groups() Store
+	Shadow() Store
+
+	// GShadow returns nil whether the shadowed groups are not used.
+	GShadow() Store
+
+	// Lock locks the databases for writing, until the returned function is
+	// called. It is used by the transactions.
+	Lock() (unlock func() error, err error)
+}
+
+// rowLooker is implemented by the backends which look up the entries by
+// themselves, instead of loading all the rows.
+type rowLooker interface {
+	lookUpRows(_row row, _field field, value interface{}, n int) ([]interface{}, error)
+}
+
+// NewRootWith returns a Root whose databases of users and groups are handled by
+// the backend. The configuration files and the home directories are looked for
+// under the directory dir, which could be empty of files: the configuration
+// files which do not exist get the default values, and the passwords are hashed
+// with SHA-512 whether it can not be got from the databases.
+//
+// The subordinate ids and the cache (see UseCache) are only handled with the
+// file backend.
+func NewRootWith(dir string, b Backend) (*Root, error) {
+	r, err := NewRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	r.backend = b
+	return r, nil
+}
+
+// SetDefaultBackend sets the backend used by the functions at package level,
+// i.e. a MemBackend to test the programs which use them. A nil backend sets the
+// database files of the running system.
+//
+// The configuration is still got from the running system. It is not safe for
+// concurrent use, so it has to be called before of using those functions.
+func SetDefaultBackend(b Backend) {
+	defaultRoot.backend = b
+	defaultRoot.cache = nil // Only used with the files.
+}
+
+// Backend returns the backend used by the root.
+func (r *Root) Backend() Backend {
+	if r.backend == nil {
+		return fileBackend{r}
+	}
+	return r.backend
+}
+
+// storeOf returns the store of the backend for the row, or nil whether it is not
+// handled by the backend.
+func storeOf(b Backend, _row row) Store {
+	switch _row.(type) {
+	case *User:
+		return b.Users()
+	case *Group:
+		return b.Groups()
+	case *Shadow:
+		return b.Shadow()
+	case *GShadow:
+		return b.GShadow()
+	}
+	return nil
+}
+
+// loadRows returns the rows of the database for the row in the root.
+func (r *Root) loadRows(_row row) ([]string, error) {
+	if r.backend == nil {
+		return fileStore{r, _row.filename()}.Load()
+	}
+
+	s := storeOf(r.backend, _row)
+	if s == nil {
+		return nil, ErrNoStore
+	}
+	return s.Load()
+}
+
+// lookUpRows looks for the rows of the backend whose field matches with the
+// value. The count determines the number of entries to return, like in lookUp.
+func lookUpRows(b Backend, _row row, _field field, value interface{}, n int) ([]interface{}, error) {
+	if l, ok := b.(rowLooker); ok {
+		return l.lookUpRows(_row, _field, value, n)
+	}
+
+	s := storeOf(b, _row)
+	if s == nil {
+		return nil, ErrNoStore
+	}
+	lines, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []interface{}
+	for _, line := range lines {
+		if entry := _row.lookUp(line, _field, value); entry != nil {
+			if entries = append(entries, entry); len(entries) == n {
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+func joinLines(lines []string) []byte {
+	var buf bytes.Buffer
+
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// == File
+//
+
+// fileBackend handles the database files of a root directory.
+type fileBackend struct {
+	r *Root
+}
+
+// NewFileBackend returns the backend which handles the database files found
+// under the directory dir.
+func NewFileBackend(dir string) (Backend, error) {
+	r, err := NewRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return fileBackend{r}, nil
+}
+
+func (b fileBackend) Users() Store  { return fileStore{b.r, fileUser} }
+func (b fileBackend) Groups() Store { return fileStore{b.r, fileGroup} }
+func (b fileBackend) Shadow() Store { return fileStore{b.r, fileShadow} }
+
+func (b fileBackend) GShadow() Store {
+	if !b.r.hasGshadow() {
+		return nil
+	}
+	return fileStore{b.r, fileGShadow}
+}
+
+// Lock locks all the database files, like it is done by the transactions.
+func (b fileBackend) Lock() (func() error, error) {
+	l, err := b.r.lockPwd()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{fileUser, fileGroup, fileShadow}
+	if b.r.hasGshadow() {
+		names = append(names, fileGShadow)
+	}
+	for _, name := range names {
+		if err = l.lockFile(b.r.join(name)); err != nil {
+			l.unlock()
+			return nil, err
+		}
+	}
+	return l.unlock, nil
+}
+
+// fileStore represents a database file.
+type fileStore struct {
+	r    *Root
+	name string
+}
+
+func (s fileStore) Load() ([]string, error) {
+	b, err := os.ReadFile(s.r.join(s.name))
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(b), nil
+}
+
+// Save backs up the file, and replaces it through a temporary file.
+func (s fileStore) Save(lines []string) error {
+	filename := s.r.join(s.name)
+
+	if err := backup(filename); err != nil {
+		return err
+	}
+	tmp, err := writeTemp(filename, joinLines(lines))
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(filename))
+}
+
+// == Memory
+//
+
+// A MemBackend is a backend which keeps the databases in memory, i.e. to test
+// the programs which handle accounts without privileges.
+// It is safe for concurrent use.
+type MemBackend struct {
+	sem chan struct{}
+
+	users, groups, shadow, gshadow *memStore
+}
+
+// NewMemBackend returns a backend with empty databases in memory.
+// The argument useGshadow indicates whether the shadowed groups are used.
+func NewMemBackend(useGshadow bool) *MemBackend {
+	b := &MemBackend{
+		sem:    make(chan struct{}, 1),
+		users:  &memStore{},
+		groups: &memStore{},
+		shadow: &memStore{},
+	}
+	if useGshadow {
+		b.gshadow = &memStore{}
+	}
+	return b
+}
+
+func (b *MemBackend) Users() Store  { return b.users }
+func (b *MemBackend) Groups() Store { return b.groups }
+func (b *MemBackend) Shadow() Store { return b.shadow }
+
+func (b *MemBackend) GShadow() Store {
+	if b.gshadow == nil {
+		return nil
+	}
+	return b.gshadow
+}
+
+// Lock reports LockTimeoutError whether the lock could not be got in
+// LOCK_TIMEOUT.
+func (b *MemBackend) Lock() (func() error, error) {
+	select {
+	case b.sem <- struct{}{}:
+	case <-time.After(LOCK_TIMEOUT):
+		return nil, LockTimeoutError("memory")
+	}
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() { <-b.sem })
+		return nil
+	}, nil
+}
+
+// memStore represents a database in memory.
+type memStore struct {
+	mu    sync.RWMutex
+	lines []string
+}
+
+func (s *memStore) Load() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.lines...), nil
+}
+
+func (s *memStore) Save(lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lines = append([]string(nil), lines...)
+	return nil
+}
+
+// == NSS
+//
+
+// nssBackend looks up the users and groups through the Name Service Switch of
+// the running system, using the package "os/user".
+type nssBackend struct{}
+
+// NewNSSBackend returns a backend which looks up the users and groups through
+// the Name Service Switch, so it sees the accounts of services like LDAP or
+// SSSD whether the package "os/user" is built with cgo.
+//
+// It is read-only, it can not list the entries, and it does not handle the
+// shadowed databases; so it is meant to be used as fallback of another backend
+// (see NewFallbackBackend).
+//
+// The package "os/user" does not return the shell of the users, so it is got
+// running "getent(1)"; the field Shell is empty whether it is not installed.
+func NewNSSBackend() Backend { return nssBackend{} }
+
+func (nssBackend) Users() Store   { return nssStore{} }
+func (nssBackend) Groups() Store  { return nssStore{} }
+func (nssBackend) Shadow() Store  { return nil }
+func (nssBackend) GShadow() Store { return nil }
+
+func (nssBackend) Lock() (func() error, error) { return nil, ErrReadOnly }
+
+// lookUpRows looks up by name or by id; the rest of fields are not supported.
+func (nssBackend) lookUpRows(_row row, _field field, value interface{}, n int) ([]interface{}, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	switch _row.(type) {
+	case *User:
+		var u *user.User
+		var err error
+
+		switch {
+		case _field == U_NAME:
+			u, err = user.Lookup(value.(string))
+		case _field == U_UID:
+			u, err = user.LookupId(strconv.Itoa(value.(int)))
+		default:
+			return nil, ErrNoList
+		}
+		if err != nil {
+			if _, ok := err.(user.UnknownUserError); ok {
+				return nil, nil
+			}
+			if _, ok := err.(user.UnknownUserIdError); ok {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		entry := &User{Name: u.Username, password: "x", Gecos: u.Name, Dir: u.HomeDir}
+		if entry.UID, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, err
+		}
+		if entry.GID, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, err
+		}
+		if entry.Shell, err = nssShell(u.Username); err != nil {
+			return nil, err
+		}
+		return []interface{}{entry}, nil
+
+	case *Group:
+		var g *user.Group
+		var err error
+
+		switch {
+		case _field == G_NAME:
+			g, err = user.LookupGroup(value.(string))
+		case _field == G_GID:
+			g, err = user.LookupGroupId(strconv.Itoa(value.(int)))
+		default:
+			return nil, ErrNoList
+		}
+		if err != nil {
+			if _, ok := err.(user.UnknownGroupError); ok {
+				return nil, nil
+			}
+			if _, ok := err.(user.UnknownGroupIdError); ok {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		entry := &Group{Name: g.Name, password: "x"}
+		if entry.GID, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, err
+		}
+		return []interface{}{entry}, nil
+	}
+	return nil, ErrNoStore
+}
+
+// nssShell returns the shell of the named user, looked up by "getent(1)".
+// Returns an empty string whether the command is not found.
+func nssShell(name string) (string, error) {
+	out, err := exec.Command("getent", "passwd", name).Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	line := string(bytes.TrimSpace(out))
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+	u, err := parseUser(line)
+	if err != nil {
+		return "", err
+	}
+	return u.Shell, nil
+}
+
+// nssStore represents a database of the Name Service Switch.
+type nssStore struct{}
+
+func (nssStore) Load() ([]string, error)   { return nil, ErrNoList }
+func (nssStore) Save(lines []string) error { return ErrReadOnly }
+
+// == Fallback
+//
+
+// fallbackBackend looks up the entries in a second backend, whether they are
+// not found in the first one.
+type fallbackBackend struct {
+	Backend
+	fallback Backend
+}
+
+// NewFallbackBackend returns a backend which uses b, but the lookups of entries
+// not found in b are done in fallback, i.e. the one got by NewNSSBackend.
+// The changes are only done in b.
+func NewFallbackBackend(b, fallback Backend) Backend {
+	return fallbackBackend{b, fallback}
+}
+
+func (b fallbackBackend) lookUpRows(_row row, _field field, value interface{}, n int) ([]interface{}, error) {
+	entries, err := lookUpRows(b.Backend, _row, _field, value, n)
+	if err != nil || len(entries) != 0 {
+		return entries, err
+	}
+	return lookUpRows(b.fallback, _row, _field, value, n)
+}
+
+// == Errors
+//
+
+var (
+	ErrNoStore  = errors.New("database not handled by the backend")
+	ErrNoList   = errors.New("backend can not list the entries")
+	ErrReadOnly = errors.New("backend is read-only")
+)
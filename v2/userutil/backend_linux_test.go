@@ -0,0 +1,255 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"testing"
+)
+
+// newMemRoot returns a Root using a backend in memory, filled with the
+// databases of the fixture tree.
+func newMemRoot(t *testing.T) (*Root, *MemBackend) {
+	files := newTestRoot(t)
+	b := NewMemBackend(true)
+
+	for _, _row := range []row{&User{}, &Group{}, &Shadow{}, &GShadow{}} {
+		lines, err := files.loadRows(_row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = storeOf(b, _row).Save(lines); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRootWith(files.Dir(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, b
+}
+
+func TestMemBackend(t *testing.T) {
+	r, b := newMemRoot(t)
+	passwd, err := os.ReadFile(r.join(fileUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.AddUser(USER, 100); err != ErrUserExist {
+		t.Errorf("expected to report ErrUserExist, got %v", err)
+	}
+	u, err := r.LookupUser(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.LookupUID(u.UID); err != nil {
+		t.Error(err)
+	}
+
+	if err = r.ChPasswd(USER, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.LookupShadow(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$") {
+		t.Errorf("expected a password hashed, got %q", s.password)
+	}
+
+	if err = r.AddUsersToGroup("users", USER); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := r.LookupGShadow("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gs.UserList) != 1 || gs.UserList[0] != USER {
+		t.Errorf("members not expected: %v", gs.UserList)
+	}
+
+	it, err := r.AllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if err = it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 users, got %d", n)
+	}
+
+	lines, _ := b.Users().Load()
+	if !strings.HasPrefix(lines[len(lines)-1], USER+":") {
+		t.Errorf("expected to store the user, got %v", lines)
+	}
+	if data, _ := os.ReadFile(r.join(fileUser)); string(data) != string(passwd) {
+		t.Error("expected to keep the file unchanged")
+	}
+
+	// A lock is held by the transaction.
+	tx := r.Begin()
+	if _, err = tx.LookupUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	timeout := LOCK_TIMEOUT
+	LOCK_TIMEOUT = 0
+	if _, err = b.Lock(); err == nil {
+		t.Error("expected to report LockTimeoutError")
+	}
+	LOCK_TIMEOUT = timeout
+	if err = tx.DelUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.LookupUser(USER); err == nil {
+		t.Error("expected to remove the user")
+	}
+}
+
+func TestMemBackendNoConfig(t *testing.T) {
+	b := NewMemBackend(true)
+	r, err := NewRootWith(t.TempDir(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.AddGroup(GROUP); err != nil {
+		t.Fatal(err)
+	}
+	uid, err := r.AddUser(USER, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != 1000 {
+		t.Errorf("expected UID 1000, got %d", uid)
+	}
+	if err = r.ChPasswd(USER, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.LookupShadow(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$") {
+		t.Errorf("expected a password hashed with SHA-512, got %q", s.password)
+	}
+}
+
+func TestSetDefaultBackend(t *testing.T) {
+	b := NewMemBackend(true)
+	SetDefaultBackend(b)
+	defer SetDefaultBackend(nil)
+
+	if _, err := AddGroup(GROUP); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupGroup(GROUP); err != nil {
+		t.Error(err)
+	}
+	lines, err := b.Groups().Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], GROUP+":") {
+		t.Errorf("expected to store the group, got %v", lines)
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	files := newTestRoot(t)
+	b, err := NewFileBackend(files.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRootWith(files.Dir(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default backend of the root sees the changes.
+	if _, err = files.LookupUser(USER); err != nil {
+		t.Error(err)
+	}
+	lines, err := files.Backend().Shadow().Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 {
+		t.Errorf("expected 4 shadowed users, got %d", len(lines))
+	}
+}
+
+func TestFallbackBackend(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	files := newTestRoot(t)
+
+	r, err := NewRootWith(files.Dir(), NewFallbackBackend(NewMemBackend(false), NewNSSBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.hasGshadow() {
+		t.Error("expected to not use gshadow")
+	}
+
+	u, err := r.LookupUser(current.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Dir != current.HomeDir {
+		t.Errorf("expected home %q, got %q", current.HomeDir, u.Dir)
+	}
+	if _, err = exec.LookPath("getent"); err == nil && u.Shell == "" {
+		t.Error("expected to get the shell")
+	}
+	if _, err = r.LookupUID(u.UID); err != nil {
+		t.Error(err)
+	}
+	if _, err = r.LookupUser("u_missing"); err == nil {
+		t.Error("expected to report NoFoundError")
+	}
+
+	// The changes are done in the first backend.
+	if _, err = r.AddGroup(GROUP); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.LookupGroup(GROUP); err != nil {
+		t.Error(err)
+	}
+
+	nss, err := NewRootWith(files.Dir(), NewNSSBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = nss.AddGroup(GROUP); err != ErrReadOnly {
+		t.Errorf("expected to report ErrReadOnly, got %v", err)
+	}
+	if _, err = nss.AllUsers(); err != ErrNoList {
+		t.Errorf("expected to report ErrNoList, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// An UserSpec represents the specification of an user to add in batch.
+type UserSpec struct {
+	Name string
+
+	// Numerical user ID. If it is < 0, it is chosen the first id available.
+	UID int
+
+	// Name or numerical ID of the primary group.
+	// If it is empty, it is created a group with the same name than the user.
+	// If it is a number of a group that does not exist, it is created a group
+	// with the same name than the user and that id.
+	Group string
+
+	// Names of supplementary groups, which must exist.
+	Groups []string
+
+	Gecos string
+
+	// Home directory. If it is empty, it is used the base directory set in
+	// the configuration (HOME) plus the user name.
+	Dir string
+
+	// Command interpreter. If it is empty, it is used the one set in the
+	// configuration (SHELL).
+	Shell string
+
+	// Hashed password. If both Password and Key are empty, the password is
+	// disabled.
+	Password string
+
+	// Password in clear text, which is hashed using the method set in the
+	// configuration. It is not used whether Password is set.
+	Key []byte
+
+	// Whether it is a system user.
+	System bool
+
+	// Create the home directory, whether it does not exist.
+	CreateHome bool
+}
+
+// A BatchResult represents the result of adding an user in batch.
+type BatchResult struct {
+	Name string
+	UID  int
+	GID  int
+	Err  error
+}
+
+// ParseNewUsers parses users in the format used by "newusers(8)":
+//
+//	name:password:uid:gid:gecos:dir:shell
+//
+// The password is in clear text, and the homes are created.
+// Unlike "newusers(8)", the lines of users which already exist are not used to
+// update them; they are reported by ErrUserExist in their BatchResult.
+func ParseNewUsers(rd io.Reader) ([]*UserSpec, error) {
+	var specs []*UserSpec
+	scan := bufio.NewScanner(rd)
+
+	for nLine := 1; scan.Scan(); nLine++ {
+		line := scan.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("newusers: line %d: format not valid", nLine)
+		}
+
+		spec := &UserSpec{
+			Name:       fields[0],
+			UID:        -1,
+			Group:      fields[3],
+			Gecos:      fields[4],
+			Dir:        fields[5],
+			Shell:      fields[6],
+			CreateHome: true,
+		}
+		if fields[1] != "" {
+			spec.Key = []byte(fields[1])
+		}
+		if fields[2] != "" {
+			uid, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("newusers: line %d: UID could not be turned to int", nLine)
+			}
+			spec.UID = uid
+		}
+		specs = append(specs, spec)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// NewUsers adds the users got in the format of "newusers(8)".
+// The existing users are not updated. See ParseNewUsers and AddUsers.
+func NewUsers(rd io.Reader) ([]*BatchResult, error) { return defaultRoot.NewUsers(rd) }
+
+// NewUsers adds the users got in the format of "newusers(8)" in the root directory.
+func (r *Root) NewUsers(rd io.Reader) ([]*BatchResult, error) {
+	specs, err := ParseNewUsers(rd)
+	if err != nil {
+		return nil, err
+	}
+	return r.AddUsers(specs)
+}
+
+// AddUsers adds several users, writing every database file once.
+//
+// All entries are validated before of writing; whether some one is not valid,
+// nothing is written and it is reported BatchError. The result of every entry
+// is returned in the same order.
+//
+// The homes are created after of writing the databases, so their errors are
+// only reported into the results.
+func AddUsers(specs []*UserSpec) ([]*BatchResult, error) { return defaultRoot.AddUsers(specs) }
+
+// AddUsers adds several users in the root directory.
+func (r *Root) AddUsers(specs []*UserSpec) ([]*BatchResult, error) {
+	r.loadConfig()
+	results := make([]*BatchResult, len(specs))
+	nErr := 0
+
+	for i, spec := range specs {
+		results[i] = &BatchResult{Name: spec.Name}
+		if results[i].Err = spec.check(); results[i].Err != nil {
+			nErr++
+		}
+	}
+	if nErr != 0 {
+		return results, BatchError(nErr)
+	}
+
+	tx := r.beginAction("AddUsers")
+	for i, spec := range specs {
+		res := results[i]
+
+		sp := tx.savepoint()
+		if res.UID, res.GID, res.Err = tx.addUserSpec(spec); res.Err != nil {
+			if errors.Is(res.Err, ErrTxDone) {
+				return results, res.Err
+			}
+			tx.rollbackTo(sp)
+			nErr++
+		}
+	}
+
+	if nErr != 0 {
+		tx.Rollback()
+		return results, BatchError(nErr)
+	}
+	if err := tx.Commit(); err != nil {
+		return results, err
+	}
+
+	for i, spec := range specs {
+		if !spec.CreateHome {
+			continue
+		}
+		u, err := r.LookupUser(spec.Name)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if found, _ := exist(r.join(u.Dir)); found {
+			continue
+		}
+		results[i].Err = r.createHome("AddUsers", spec.Name, "")
+	}
+	return results, nil
+}
+
+// check reports FieldError whether some text of the specification can not be
+// stored in the databases, using the validation of ModifyUser.
+func (spec *UserSpec) check() error {
+	u := &User{Gecos: spec.Gecos, Dir: spec.Dir, Shell: spec.Shell}
+	if err := u.checkFields(U_GECOS | U_DIR | U_SHELL); err != nil {
+		return err
+	}
+	return checkText("Password", spec.Password)
+}
+
+// addUserSpec stages the user, her shadowed entry, her primary group whether it
+// has to be created, and her membership to the supplementary groups.
+func (tx *Tx) addUserSpec(spec *UserSpec) (uid, gid int, err error) {
+	conf := tx.r.config.useradd
+
+	if err = spec.check(); err != nil {
+		return 0, 0, err
+	}
+	u := &User{
+		Name:  spec.Name,
+		UID:   spec.UID,
+		Gecos: spec.Gecos,
+		Dir:   spec.Dir,
+		Shell: spec.Shell,
+
+		addSystemUser: spec.System,
+	}
+	if u.Dir == "" && u.Name != "" {
+		u.Dir = path.Join(conf.HOME, u.Name)
+	}
+	if u.Shell == "" {
+		u.Shell = conf.SHELL
+	}
+
+	// Primary group.
+	newGroup := &Group{Name: spec.Name, GID: -1, addSystemGroup: spec.System}
+	var gr *Group
+
+	if spec.Group == "" {
+		gr = newGroup
+	} else if id, err := strconv.Atoi(spec.Group); err == nil {
+		entry, err := tx.lookUp(&Group{}, G_GID, id)
+		if err != nil {
+			return 0, 0, err
+		}
+		if entry != nil {
+			u.GID = id
+		} else {
+			newGroup.GID = id
+			gr = newGroup
+		}
+	} else {
+		if gr, err = tx.LookupGroup(spec.Group); err != nil {
+			return 0, 0, err
+		}
+		u.GID, gr = gr.GID, nil
+	}
+
+	if gr != nil {
+		if gr.Name == "" {
+			return 0, 0, RequiredError("Name")
+		}
+		if tx.r.hasGshadow() {
+			if err = tx.AddGShadow(&GShadow{Name: gr.Name}, nil); err != nil {
+				return 0, 0, err
+			}
+		}
+		if u.GID, err = tx.AddGroup(gr); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if uid, err = tx.AddUser(u); err != nil {
+		return 0, 0, err
+	}
+
+	s := tx.r.NewShadow(u.Name)
+	if spec.Password != "" {
+		if err = tx.AddShadow(s, nil); err != nil {
+			return 0, 0, err
+		}
+		s.password = spec.Password
+		if err = tx.EditShadow(u.Name, s); err != nil {
+			return 0, 0, err
+		}
+	} else if err = tx.AddShadow(s, spec.Key); err != nil {
+		return 0, 0, err
+	}
+
+	for _, name := range spec.Groups {
+		if err = tx.AddUsersToGroup(name, u.Name); err != nil {
+			return 0, 0, err
+		}
+	}
+	return uid, u.GID, nil
+}
+
+// A txChange records a row staged into a transaction, to could undo it.
+type txChange struct {
+	f       *txFile
+	i       int    // Index of the row.
+	line    string // Row replaced or removed; empty whether it was added.
+	removed bool
+	changed bool // State of the file before of the change.
+}
+
+// savepoint returns the state of the transaction, to could go back to it.
+// It is the number of changes staged, so it does not copy the files.
+func (tx *Tx) savepoint() int { return len(tx.changes) }
+
+// rollbackTo discards the changes staged since the savepoint, in reverse order.
+// Only the rows staged by add and _edit are undone.
+func (tx *Tx) rollbackTo(sp int) {
+	for i := len(tx.changes) - 1; i >= sp; i-- {
+		c := tx.changes[i]
+		f := c.f
+
+		switch {
+		case c.line == "":
+			f.lines = f.lines[:c.i]
+		case c.removed:
+			f.lines = append(f.lines, "")
+			copy(f.lines[c.i+1:], f.lines[c.i:])
+			f.lines[c.i] = c.line
+		default:
+			f.lines[c.i] = c.line
+		}
+		f.changed = c.changed
+	}
+	tx.changes = tx.changes[:sp]
+}
+
+// splitLines returns the rows of a database file.
+func splitLines(b []byte) []string {
+	var lines []string
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// == Errors
+//
+
+// BatchError reports the number of entries not valid into a batch.
+type BatchError int
+
+func (e BatchError) Error() string {
+	return "batch not applied: " + strconv.Itoa(int(e)) + " entries not valid"
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAddUsers(t *testing.T) {
+	r := newTestRoot(t)
+
+	passwd, err := os.ReadFile(r.join(fileUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Some entry not valid, so nothing is written.
+	results, err := r.AddUsers([]*UserSpec{
+		{Name: "u1", UID: -1, Groups: []string{"users"}},
+		{Name: "u2", UID: -1, Group: "missing"},
+		{Name: "root", UID: -1},
+	})
+	if err != BatchError(2) {
+		t.Fatalf("expected to report BatchError(2), got %v", err)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err == nil {
+		t.Errorf("unexpected results: %v, %v, %v", results[0].Err, results[1].Err, results[2].Err)
+	}
+	if b, _ := os.ReadFile(r.join(fileUser)); string(b) != string(passwd) {
+		t.Fatal("expected to keep the file of users")
+	}
+
+	results, err = r.NewUsers(strings.NewReader(`u1:secret::::/home/u1:/bin/bash
+u2::2000:users:U Two::
+u3::::::
+`))
+	if err != nil {
+		for _, res := range results {
+			t.Log(res.Name, res.Err)
+		}
+		t.Fatal(err)
+	}
+	if results[0].UID != 1000 || results[0].GID != 1000 {
+		t.Errorf("u1: unexpected ids %d:%d", results[0].UID, results[0].GID)
+	}
+	if results[1].UID != 2000 || results[1].GID != 100 {
+		t.Errorf("u2: unexpected ids %d:%d", results[1].UID, results[1].GID)
+	}
+	if results[2].UID != 1001 || results[2].GID != 1001 {
+		t.Errorf("u3: unexpected ids %d:%d", results[2].UID, results[2].GID)
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("%s: %s", res.Name, res.Err)
+		}
+	}
+
+	u, err := r.LookupUser("u3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Dir != "/home/u3" || u.Shell != "/bin/sh" {
+		t.Errorf("expected the default home and shell, got %q, %q", u.Dir, u.Shell)
+	}
+	if _, err = os.Stat(r.join(u.Dir)); err != nil {
+		t.Errorf("expected to create the home: %s", err)
+	}
+	if _, err = r.LookupGShadow("u3"); err != nil {
+		t.Error(err)
+	}
+
+	s, err := r.LookupShadow("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$") {
+		t.Errorf("expected a password hashed with SHA-512, got %q", s.password)
+	}
+	if s, _ = r.LookupShadow("u2"); s.password != "*" {
+		t.Errorf("expected the password disabled, got %q", s.password)
+	}
+
+	// Supplementary groups and hashed password.
+	if _, err = r.AddUsers([]*UserSpec{
+		{Name: "u4", UID: -1, Group: "users", Groups: []string{"u1", "u3"}, Password: "$6$x$y"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.LookupGroup("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.UserList) != 1 || g.UserList[0] != "u4" {
+		t.Errorf("expected to add the member, got %v", g.UserList)
+	}
+	if s, _ = r.LookupShadow("u4"); s.password != "$6$x$y" {
+		t.Errorf("expected the hashed password, got %q", s.password)
+	}
+}
+
+func TestAddUsersFields(t *testing.T) {
+	r := newTestRoot(t)
+
+	passwd, err := os.ReadFile(r.join(fileUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields which would inject rows, like a new user with UID 0.
+	results, err := r.AddUsers([]*UserSpec{
+		{Name: "u1", UID: -1},
+		{Name: "u2", UID: -1, Gecos: "x:0:0::/root:/bin/bash\nevil:x"},
+		{Name: "u3", UID: -1, Shell: "/bin/sh\nevil::0:0::/:/bin/sh"},
+		{Name: "u4", UID: -1, Password: "x:0:0:99999:7:::\nevil:"},
+	})
+	if err != BatchError(3) {
+		t.Fatalf("expected to report BatchError(3), got %v", err)
+	}
+	for _, res := range results[1:] {
+		if _, ok := res.Err.(FieldError); !ok {
+			t.Errorf("%s: expected to report FieldError, got %v", res.Name, res.Err)
+		}
+	}
+	if b, _ := os.ReadFile(r.join(fileUser)); string(b) != string(passwd) {
+		t.Fatal("expected to keep the file of users")
+	}
+
+	// Through the transaction.
+	tx := r.Begin()
+	defer tx.Rollback()
+
+	u := r.NewUser("u5", 100)
+	u.Dir = "/home/u5:/bin/bash"
+	if _, err = tx.AddUser(u); err == nil {
+		t.Fatal("expected to report FieldError")
+	} else if _, ok := err.(FieldError); !ok {
+		t.Fatalf("expected to report FieldError, got %v", err)
+	}
+}
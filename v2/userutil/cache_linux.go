@@ -0,0 +1,263 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// A Cache represents a snapshot of the databases of users and groups in memory,
+// indexed by name and id.
+//
+// Every file is read again only when it has been changed, according to its
+// inode, size and modification time; so it is useful for hosts with a lot of
+// accounts, or for programs which look up accounts at a high rate.
+//
+// It is safe for concurrent use.
+type Cache struct {
+	r *Root
+
+	mu    sync.Mutex
+	files map[string]*cacheFile // key: path of the file
+}
+
+// cacheFile represents the entries of a database file.
+type cacheFile struct {
+	stamp  fileStamp
+	byName map[string]interface{}
+	byID   map[int]interface{}
+}
+
+// fileStamp identifies the version of a file.
+type fileStamp struct {
+	dev, ino uint64
+	size     int64
+	mtime    int64
+}
+
+func getFileStamp(filename string) (fileStamp, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	st := info.Sys().(*syscall.Stat_t)
+
+	return fileStamp{
+		dev:   uint64(st.Dev),
+		ino:   st.Ino,
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+	}, nil
+}
+
+// NewCache returns a cache of the databases in the root directory.
+func NewCache(r *Root) *Cache {
+	return &Cache{r: r, files: make(map[string]*cacheFile, 4)}
+}
+
+// UseCache sets whether the lookup functions at package level use a cache.
+// See Root.UseCache.
+func UseCache(enable bool) { defaultRoot.UseCache(enable) }
+
+// UseCache sets whether the functions LookupUser, LookupUID, LookupShadow,
+// LookupGroup, LookupGID and LookupGShadow use a cache of the root directory.
+//
+// It is not safe to call it while the root is used by another goroutine.
+// It has no effect whether the root does not use the file backend.
+func (r *Root) UseCache(enable bool) {
+	if r.backend != nil {
+		return
+	}
+	if !enable {
+		r.cache = nil
+	} else if r.cache == nil {
+		r.cache = NewCache(r)
+	}
+}
+
+// load returns the entries of the database file for the row, reading it only
+// whether it has been changed since the last time.
+func (c *Cache) load(_row row) (*cacheFile, error) {
+	filename := c.r.join(_row.filename())
+
+	stamp, err := getFileStamp(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.files[filename]; ok && f.stamp == stamp {
+		return f, nil
+	}
+
+	dbf, err := openDBFile(filename, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file could be replaced between the calls to stat and open.
+	info, err := dbf.file.Stat()
+	if err != nil {
+		dbf.close()
+		return nil, err
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	stamp = fileStamp{uint64(st.Dev), st.Ino, info.Size(), info.ModTime().UnixNano()}
+
+	f := &cacheFile{
+		stamp:  stamp,
+		byName: make(map[string]interface{}),
+		byID:   make(map[int]interface{}),
+	}
+	// The iterator closes the file at the end.
+	it := &rowIter{dbf: dbf, parse: cacheParser(_row)}
+
+	for it.next() {
+		var name string
+		id := -1
+
+		switch entry := it.entry.(type) {
+		case *User:
+			name, id = entry.Name, entry.UID
+		case *Group:
+			name, id = entry.Name, entry.GID
+		case *Shadow:
+			name = entry.Name
+		case *GShadow:
+			name = entry.Name
+		}
+
+		// The first entry is the used one, like at looking up linearly.
+		if _, ok := f.byName[name]; !ok {
+			f.byName[name] = it.entry
+		}
+		if _, ok := f.byID[id]; !ok && id != -1 {
+			f.byID[id] = it.entry
+		}
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+
+	c.files[filename] = f
+	return f, nil
+}
+
+func cacheParser(_row row) func(string) (interface{}, error) {
+	switch _row.(type) {
+	case *User:
+		return func(line string) (interface{}, error) { return parseUser(line) }
+	case *Group:
+		return func(line string) (interface{}, error) { return parseGroup(line) }
+	case *Shadow:
+		return func(line string) (interface{}, error) { return parseShadow(line) }
+	default:
+		return func(line string) (interface{}, error) { return parseGShadow(line) }
+	}
+}
+
+// get returns the entry matched by name or by id, according to the type of
+// the value.
+func (c *Cache) get(_row row, _field field, value interface{}) (interface{}, error) {
+	f, err := c.load(_row)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry interface{}
+	var ok bool
+
+	switch v := value.(type) {
+	case string:
+		entry, ok = f.byName[v]
+	case int:
+		entry, ok = f.byID[v]
+	}
+	if !ok {
+		return nil, NoFoundError{c.r.join(_row.filename()), _field.String(), value}
+	}
+	return entry, nil
+}
+
+// == Lookup
+//
+// The entries are copied, so they can be modified by the caller.
+
+// LookupUser looks up an user by name.
+func (c *Cache) LookupUser(name string) (*User, error) {
+	entry, err := c.get(&User{}, U_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	u := *entry.(*User)
+	return &u, nil
+}
+
+// LookupUID looks up an user by user ID.
+func (c *Cache) LookupUID(uid int) (*User, error) {
+	entry, err := c.get(&User{}, U_UID, uid)
+	if err != nil {
+		return nil, err
+	}
+	u := *entry.(*User)
+	return &u, nil
+}
+
+// LookupShadow looks up a shadowed user by name.
+func (c *Cache) LookupShadow(name string) (*Shadow, error) {
+	c.r.checkRoot()
+
+	entry, err := c.get(&Shadow{}, S_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	s := *entry.(*Shadow)
+	return &s, nil
+}
+
+// LookupGroup looks up a group by name.
+func (c *Cache) LookupGroup(name string) (*Group, error) {
+	entry, err := c.get(&Group{}, G_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	g := *entry.(*Group)
+	g.UserList = append([]string(nil), g.UserList...)
+	return &g, nil
+}
+
+// LookupGID looks up a group by group ID.
+func (c *Cache) LookupGID(gid int) (*Group, error) {
+	entry, err := c.get(&Group{}, G_GID, gid)
+	if err != nil {
+		return nil, err
+	}
+	g := *entry.(*Group)
+	g.UserList = append([]string(nil), g.UserList...)
+	return &g, nil
+}
+
+// LookupGShadow looks up a shadowed group by name.
+func (c *Cache) LookupGShadow(name string) (*GShadow, error) {
+	if !c.r.hasGshadow() {
+		return nil, ErrGshadow
+	}
+	c.r.checkRoot()
+
+	entry, err := c.get(&GShadow{}, GS_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	gs := *entry.(*GShadow)
+	gs.AdminList = append([]string(nil), gs.AdminList...)
+	gs.UserList = append([]string(nil), gs.UserList...)
+	return &gs, nil
+}
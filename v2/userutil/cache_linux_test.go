@@ -0,0 +1,68 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	r := newTestRoot(t)
+	r.UseCache(true)
+	defer r.UseCache(false)
+
+	u, err := r.LookupUID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "daemon" {
+		t.Errorf("expected user daemon, got %q", u.Name)
+	}
+	u.Name = "changed"
+	if u, _ = r.LookupUser("daemon"); u == nil || u.UID != 1 {
+		t.Error("expected to keep the entry cached unchanged")
+	}
+
+	f := r.cache.files[r.join(fileUser)]
+	if _, err = r.LookupUser(USER); err == nil {
+		t.Fatal("expected to report that the user does not exist")
+	}
+	if r.cache.files[r.join(fileUser)] != f {
+		t.Error("expected to not read the file again")
+	}
+
+	// The files are replaced at committing.
+	uid, err := r.AddUser(USER, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err = r.LookupUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	if u.UID != uid {
+		t.Errorf("expected UID %d, got %d", uid, u.UID)
+	}
+	if _, err = r.LookupShadow(USER); err != nil {
+		t.Error(err)
+	}
+
+	if err = r.AddUsersToGroup("users", USER); err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.LookupGID(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.UserList) != 1 || g.UserList[0] != USER {
+		t.Errorf("expected to get the new member, got %v", g.UserList)
+	}
+	gs, err := r.LookupGShadow("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gs.UserList) != 1 || gs.UserList[0] != USER {
+		t.Errorf("expected to get the new member, got %v", gs.UserList)
+	}
+}
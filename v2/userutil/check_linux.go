@@ -0,0 +1,552 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CheckKind represents the kind of a problem found into the databases.
+type CheckKind int
+
+// Kinds of problems.
+const (
+	CHECK_MALFORMED        CheckKind = iota // Row with a format not valid.
+	CHECK_DUP_NAME                          // Name used in several rows.
+	CHECK_DUP_ID                            // Id used by several users or groups.
+	CHECK_NO_SHADOW                         // User without shadowed entry.
+	CHECK_NO_USER                           // Shadowed entry without user.
+	CHECK_NO_PRIMARY_GROUP                  // User whose primary group does not exist.
+	CHECK_NO_MEMBER                         // Group member that does not exist.
+	CHECK_NO_GSHADOW                        // Group without shadowed entry.
+	CHECK_NO_GROUP                          // Shadowed entry without group.
+	CHECK_GSHADOW_MISMATCH                  // Members different in group and gshadow.
+	CHECK_HOME                              // Home directory not valid.
+	CHECK_SHELL                             // Shell not valid.
+)
+
+func (k CheckKind) String() string {
+	switch k {
+	case CHECK_MALFORMED:
+		return "malformed row"
+	case CHECK_DUP_NAME:
+		return "duplicate name"
+	case CHECK_DUP_ID:
+		return "duplicate id"
+	case CHECK_NO_SHADOW:
+		return "no shadow entry"
+	case CHECK_NO_USER:
+		return "no user entry"
+	case CHECK_NO_PRIMARY_GROUP:
+		return "no primary group"
+	case CHECK_NO_MEMBER:
+		return "no member"
+	case CHECK_NO_GSHADOW:
+		return "no gshadow entry"
+	case CHECK_NO_GROUP:
+		return "no group entry"
+	case CHECK_GSHADOW_MISMATCH:
+		return "gshadow mismatch"
+	case CHECK_HOME:
+		return "invalid home"
+	case CHECK_SHELL:
+		return "invalid shell"
+	}
+	return "unknown"
+}
+
+// A Finding represents a problem found into the databases of users and groups.
+type Finding struct {
+	Kind CheckKind
+
+	File string // Path of the database.
+	Line int    // Number of line, since 1.
+	Name string // Name of the user or group, if any.
+	Msg  string
+
+	fix func(tx *Tx) error
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", f.File, f.Line, f.Kind, f.Msg)
+}
+
+// CanFix reports whether the problem has an automatic fix.
+func (f *Finding) CanFix() bool { return f.fix != nil }
+
+// Fix stages the automatic fix of the problem, if any.
+func (f *Finding) Fix(tx *Tx) error {
+	if f.fix == nil {
+		return nil
+	}
+	return f.fix(tx)
+}
+
+// Check cross-validates the databases of users and groups, like "pwck(8)" and
+// "grpck(8)", returning the problems found.
+func Check() ([]*Finding, error) { return defaultRoot.Check() }
+
+// Check cross-validates the databases of users and groups in the root directory.
+func (r *Root) Check() ([]*Finding, error) {
+	tx := r.Begin()
+	defer tx.Rollback()
+
+	c := &checker{tx: tx}
+	for _, fn := range []func() error{c.checkUsers, c.checkShadows, c.checkGroups, c.checkGShadows} {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+	return c.findings, nil
+}
+
+// Repair applies the automatic fixes of the findings into a transaction, so all
+// fixes are written or none of them.
+func Repair(findings []*Finding) error { return defaultRoot.Repair(findings) }
+
+// Repair applies the automatic fixes of the findings in the root directory.
+func (r *Root) Repair(findings []*Finding) error {
+	tx := r.beginAction("Repair")
+
+	for _, f := range findings {
+		if err := f.Fix(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checker keeps the state of the databases while they are checked.
+type checker struct {
+	tx       *Tx
+	findings []*Finding
+
+	users     map[string]*User
+	userRows  []int // Index of the rows of users, without duplicates.
+	groups    map[string]*Group
+	groupRows []int // Index of the rows of groups, without duplicates.
+}
+
+func (c *checker) add(kind CheckKind, _row row, line int, name, msg string, fix func(*Tx) error) {
+	c.findings = append(c.findings, &Finding{
+		Kind: kind,
+		File: c.tx.r.join(_row.filename()),
+		Line: line + 1,
+		Name: name,
+		Msg:  msg,
+		fix:  fix,
+	})
+}
+
+// rows parses the rows of the database file, reporting the malformed ones and
+// the duplicated names. The function fn is called for every valid row.
+func (c *checker) rows(_row row, parse func(string) (string, error), fn func(i int, line string)) error {
+	f, err := c.tx.load(_row)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(f.lines))
+
+	for i, line := range f.lines {
+		name, err := parse(line)
+		if err != nil {
+			c.add(CHECK_MALFORMED, _row, i, "", line, delLineFix(_row, line))
+			continue
+		}
+		if names[name] {
+			c.add(CHECK_DUP_NAME, _row, i, name, "name "+strconv.Quote(name)+" is duplicated",
+				delLineFix(_row, line))
+			continue
+		}
+		names[name] = true
+		fn(i, line)
+	}
+	return nil
+}
+
+func (c *checker) checkUsers() error {
+	c.users = make(map[string]*User)
+	ids := make(map[int]bool)
+	u := &User{}
+
+	return c.rows(u,
+		func(line string) (string, error) {
+			entry, err := parseUser(line)
+			if err != nil {
+				return "", err
+			}
+			return entry.Name, nil
+		},
+		func(i int, line string) {
+			entry, _ := parseUser(line)
+			c.users[entry.Name] = entry
+			c.userRows = append(c.userRows, i)
+
+			if ids[entry.UID] {
+				c.add(CHECK_DUP_ID, u, i, entry.Name, "UID "+strconv.Itoa(entry.UID), nil)
+			}
+			ids[entry.UID] = true
+
+			if msg := c.checkHome(entry.Dir); msg != "" {
+				c.add(CHECK_HOME, u, i, entry.Name, msg, nil)
+			}
+			if msg := c.checkShell(entry.Shell); msg != "" {
+				c.add(CHECK_SHELL, u, i, entry.Name, msg, nil)
+			}
+		},
+	)
+}
+
+func (c *checker) checkShadows() error {
+	shadowed := make(map[string]bool)
+	s := &Shadow{}
+
+	err := c.rows(s,
+		func(line string) (string, error) {
+			entry, err := parseShadow(line)
+			if err != nil {
+				return "", err
+			}
+			return entry.Name, nil
+		},
+		func(i int, line string) {
+			name := line[:strings.IndexByte(line, ':')]
+			shadowed[name] = true
+
+			if _, ok := c.users[name]; !ok {
+				c.add(CHECK_NO_USER, s, i, name, "shadowed user without entry in "+
+					c.tx.r.join(fileUser), delLineFix(s, line))
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	f, _ := c.tx.load(&User{})
+	for _, i := range c.userRows {
+		u, _ := parseUser(f.lines[i])
+		if shadowed[u.Name] {
+			continue
+		}
+		c.add(CHECK_NO_SHADOW, u, i, u.Name, "user without entry in "+
+			c.tx.r.join(fileShadow), addShadowFix(u.Name))
+	}
+	return nil
+}
+
+func (c *checker) checkGroups() error {
+	c.groups = make(map[string]*Group)
+	ids := make(map[int]bool)
+	g := &Group{}
+
+	err := c.rows(g,
+		func(line string) (string, error) {
+			entry, err := parseGroup(line)
+			if err != nil {
+				return "", err
+			}
+			return entry.Name, nil
+		},
+		func(i int, line string) {
+			entry, _ := parseGroup(line)
+			c.groups[entry.Name] = entry
+			c.groupRows = append(c.groupRows, i)
+
+			if ids[entry.GID] {
+				c.add(CHECK_DUP_ID, g, i, entry.Name, "GID "+strconv.Itoa(entry.GID), nil)
+			}
+			ids[entry.GID] = true
+
+			for _, member := range entry.UserList {
+				if member == "" {
+					continue
+				}
+				if _, ok := c.users[member]; !ok {
+					c.add(CHECK_NO_MEMBER, g, i, entry.Name, "member "+strconv.Quote(member)+
+						" does not exist", delMemberFix(entry.Name, member))
+				}
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	f, _ := c.tx.load(&User{})
+	for _, i := range c.userRows {
+		u, _ := parseUser(f.lines[i])
+		if !ids[u.GID] {
+			c.add(CHECK_NO_PRIMARY_GROUP, u, i, u.Name, "GID "+strconv.Itoa(u.GID)+
+				" does not exist", nil)
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkGShadows() error {
+	if !c.tx.r.hasGshadow() {
+		return nil
+	}
+	shadowed := make(map[string]bool)
+	gs := &GShadow{}
+
+	err := c.rows(gs,
+		func(line string) (string, error) {
+			entry, err := parseGShadow(line)
+			if err != nil {
+				return "", err
+			}
+			return entry.Name, nil
+		},
+		func(i int, line string) {
+			entry, _ := parseGShadow(line)
+			shadowed[entry.Name] = true
+
+			gr, ok := c.groups[entry.Name]
+			if !ok {
+				c.add(CHECK_NO_GROUP, gs, i, entry.Name, "shadowed group without entry in "+
+					c.tx.r.join(fileGroup), delLineFix(gs, line))
+				return
+			}
+			if !sameMembers(gr.UserList, entry.UserList) {
+				c.add(CHECK_GSHADOW_MISMATCH, gs, i, entry.Name,
+					"members differ from "+c.tx.r.join(fileGroup), syncMembersFix(entry.Name))
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	f, _ := c.tx.load(&Group{})
+	for _, i := range c.groupRows {
+		g, _ := parseGroup(f.lines[i])
+		if shadowed[g.Name] {
+			continue
+		}
+		c.add(CHECK_NO_GSHADOW, g, i, g.Name, "group without entry in "+
+			c.tx.r.join(fileGShadow), addGShadowFix(g.Name))
+	}
+	return nil
+}
+
+// checkHome returns the problem found in the home directory, if any.
+// The directory '/nonexistent' is skipped, like "pwck(8)".
+func (c *checker) checkHome(dir string) string {
+	if dir == "" || !filepath.IsAbs(dir) {
+		return "directory " + strconv.Quote(dir) + " is not an absolute path"
+	}
+	if dir == "/nonexistent" {
+		return ""
+	}
+	info, err := os.Stat(c.tx.r.join(dir))
+	if err != nil {
+		return "directory " + dir + " does not exist"
+	}
+	if !info.IsDir() {
+		return dir + " is not a directory"
+	}
+	return ""
+}
+
+// checkShell returns the problem found in the shell, if any.
+// An empty shell is valid, since "/bin/sh" is used.
+func (c *checker) checkShell(shell string) string {
+	if shell == "" {
+		return ""
+	}
+	if !filepath.IsAbs(shell) {
+		return "shell " + strconv.Quote(shell) + " is not an absolute path"
+	}
+	info, err := os.Stat(c.tx.r.join(shell))
+	if err != nil {
+		return "shell " + shell + " does not exist"
+	}
+	if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return "shell " + shell + " is not executable"
+	}
+	return ""
+}
+
+// sameMembers reports whether both lists have the same members, in any order.
+func sameMembers(a, b []string) bool {
+	set := make(map[string]int)
+	for _, v := range a {
+		if v != "" {
+			set[v]++
+		}
+	}
+	for _, v := range b {
+		if v != "" {
+			set[v]--
+		}
+	}
+	for _, n := range set {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// == Fixes
+//
+
+// delLineFix removes the last row equal to line.
+func delLineFix(_row row, line string) func(*Tx) error {
+	return func(tx *Tx) error {
+		f, err := tx.load(_row)
+		if err != nil {
+			return err
+		}
+		for i := len(f.lines) - 1; i >= 0; i-- {
+			if f.lines[i] == line {
+				f.lines = append(f.lines[:i], f.lines[i+1:]...)
+				f.changed = true
+				break
+			}
+		}
+		return nil
+	}
+}
+
+// The fixes look for the rows through findRow, instead of the lookups of the
+// transaction, since the malformed rows could be not removed yet.
+
+// findRow loads the file of _row, and returns it with the index and the entry
+// of the first row with the given name which is well-formed.
+func findRow(tx *Tx, _row row, _field field, name string) (*txFile, int, row, error) {
+	f, err := tx.load(_row)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	prefix := name + ":"
+
+	for i, line := range f.lines {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		var entry row
+		switch _row.(type) {
+		case *User:
+			entry, err = parseUser(line)
+		case *Group:
+			entry, err = parseGroup(line)
+		case *GShadow:
+			entry, err = parseGShadow(line)
+		default:
+			panic("unimplemented")
+		}
+		if err == nil {
+			return f, i, entry, nil
+		}
+	}
+	return nil, 0, nil, NoFoundError{tx.r.join(_row.filename()), _field.String(), name}
+}
+
+// setRow replaces the row at index i.
+func setRow(f *txFile, i int, _row row) {
+	f.lines[i] = strings.TrimSuffix(_row.String(), "\n")
+	f.changed = true
+}
+
+// addShadowFix adds the shadowed entry for an user, moving her hashed password
+// from the user database, like "pwconv(8)".
+func addShadowFix(name string) func(*Tx) error {
+	return func(tx *Tx) error {
+		f, i, entry, err := findRow(tx, &User{}, U_NAME, name)
+		if err != nil {
+			return err
+		}
+		u := entry.(*User)
+		s := tx.r.NewShadow(name)
+		if err = tx.AddShadow(s, nil); err != nil {
+			return err
+		}
+		if u.password == "x" {
+			return nil
+		}
+
+		s.password = u.password
+		if err = tx.EditShadow(name, s); err != nil {
+			return err
+		}
+		u.password = "x"
+		setRow(f, i, u)
+		return nil
+	}
+}
+
+// addGShadowFix adds the shadowed entry for a group, with the same members.
+func addGShadowFix(name string) func(*Tx) error {
+	return func(tx *Tx) error {
+		f, i, entry, err := findRow(tx, &Group{}, G_NAME, name)
+		if err != nil {
+			return err
+		}
+		g := entry.(*Group)
+		gs := &GShadow{Name: name, UserList: g.UserList}
+		if err = tx.AddGShadow(gs, nil); err != nil {
+			return err
+		}
+		if g.password == "x" || g.password == "" {
+			return nil
+		}
+
+		gs.password = g.password
+		if err = tx.EditGShadow(name, gs); err != nil {
+			return err
+		}
+		g.password = "x"
+		setRow(f, i, g)
+		return nil
+	}
+}
+
+// delMemberFix removes a member from a group.
+func delMemberFix(name, member string) func(*Tx) error {
+	return func(tx *Tx) error {
+		f, i, entry, err := findRow(tx, &Group{}, G_NAME, name)
+		if err != nil {
+			return err
+		}
+		g := entry.(*Group)
+		members := g.UserList[:0]
+		for _, v := range g.UserList {
+			if v != member {
+				members = append(members, v)
+			}
+		}
+		g.UserList = members
+		setRow(f, i, g)
+		return nil
+	}
+}
+
+// syncMembersFix sets the members of the shadowed group to the ones of the group.
+func syncMembersFix(name string) func(*Tx) error {
+	return func(tx *Tx) error {
+		_, _, g, err := findRow(tx, &Group{}, G_NAME, name)
+		if err != nil {
+			return err
+		}
+		f, i, entry, err := findRow(tx, &GShadow{}, GS_NAME, name)
+		if err != nil {
+			return err
+		}
+		gs := entry.(*GShadow)
+		gs.UserList = g.(*Group).UserList
+		setRow(f, i, gs)
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	r := newTestRoot(t)
+
+	for _, dir := range []string{"/root", "/usr/sbin", "/bin"} {
+		if err := os.MkdirAll(r.join(dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, shell := range []string{"/bin/bash", "/usr/sbin/nologin"} {
+		if err := os.WriteFile(r.join(shell), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		fileUser: `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+bad:x:zero:0::/:/bin/bash
+alice:secret:1000:1000::/home/alice:/bin/zsh
+daemon:x:2:1:daemon:/usr/sbin:/usr/sbin/nologin
+`,
+		fileShadow: `root:*:18000:0:99999:7:::
+daemon:*:18000:0:99999:7:::
+nobody:*:18000:0:99999:7:::
+ghost:*:18000:0:99999:7:::
+`,
+		fileGroup: `root:x:0:
+daemon:x:1:root,ghost
+users:x:100:
+nogroup:x:65534:
+`,
+		fileGShadow: `root:*::
+daemon:*::root
+users:*::
+old:*::
+`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(r.join(name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	findings, err := r.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[CheckKind]int{
+		CHECK_MALFORMED:        1,
+		CHECK_DUP_NAME:         1,
+		CHECK_NO_SHADOW:        1,
+		CHECK_NO_USER:          1,
+		CHECK_NO_PRIMARY_GROUP: 1,
+		CHECK_NO_MEMBER:        1,
+		CHECK_NO_GSHADOW:       1,
+		CHECK_NO_GROUP:         1,
+		CHECK_GSHADOW_MISMATCH: 1,
+		CHECK_HOME:             1,
+		CHECK_SHELL:            1,
+	}
+	got := make(map[CheckKind]int)
+	for _, f := range findings {
+		got[f.Kind]++
+	}
+	for kind, n := range want {
+		if got[kind] != n {
+			t.Errorf("%s: expected %d findings, got %d", kind, n, got[kind])
+		}
+	}
+	if t.Failed() {
+		for _, f := range findings {
+			t.Log(f)
+		}
+		t.FailNow()
+	}
+
+	if err = r.Repair(findings); err != nil {
+		t.Fatal(err)
+	}
+	if findings, err = r.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.CanFix() {
+			t.Errorf("expected to be fixed: %s", f)
+		}
+	}
+
+	s, err := r.LookupShadow("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.password != "secret" {
+		t.Errorf("expected to move the password to shadow, got %q", s.password)
+	}
+	gs, err := r.LookupGShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameMembers(gs.UserList, []string{"root"}) {
+		t.Errorf("unexpected members in gshadow: %v", gs.UserList)
+	}
+}
+
+func TestRepairMalformed(t *testing.T) {
+	r := newTestRoot(t)
+
+	files := map[string]string{
+		fileGroup: `root:x:0:
+daemon:x:one:ghost
+daemon:x:1:root,ghost
+`,
+		fileGShadow: `root:*::
+daemon:*::root,ghost
+`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(r.join(name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	findings, err := r.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the fixes of the members, without removing the malformed row.
+	var members []*Finding
+	for _, f := range findings {
+		if f.Kind == CHECK_NO_MEMBER {
+			members = append(members, f)
+		}
+	}
+	if len(members) != 1 {
+		t.Fatalf("expected 1 finding about members, got %d", len(members))
+	}
+	if err = r.Repair(members); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(r.join(fileGroup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "root:x:0:\ndaemon:x:one:ghost\ndaemon:x:1:root\n"
+	if string(data) != want {
+		t.Errorf("unexpected group file:\n%s", data)
+	}
+
+	if findings, err = r.Check(); err != nil {
+		t.Fatal(err)
+	}
+	var mismatch []*Finding
+	for _, f := range findings {
+		if f.Kind == CHECK_GSHADOW_MISMATCH {
+			mismatch = append(mismatch, f)
+		}
+	}
+	if len(mismatch) != 1 {
+		t.Fatalf("expected 1 finding about gshadow, got %d", len(mismatch))
+	}
+	if err = r.Repair(mismatch); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := r.LookupGShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameMembers(gs.UserList, []string{"root"}) {
+		t.Errorf("unexpected members in gshadow: %v", gs.UserList)
+	}
+}
@@ -8,38 +8,36 @@ package userutil
 
 import (
 	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 
 	"github.com/p3ls/osutil/v2/config/shconf"
 	"github.com/p3ls/osutil/v2/userutil/crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/bcrypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/common"
+	"github.com/p3ls/osutil/v2/userutil/crypt/sha256_crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/sha512_crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/yescrypt"
 	"gopkg.in/ini.v1"
 )
 
-// TODO: handle des, bcrypt and rounds in SHA2.
-
-// TODO: Idea: store struct "configData" to run configData.Init() only when
-// the configuration files have been modified.
+// TODO: handle des.
 
 var config configData
 
 // loadConfig loads the user configuration.
 // It has to be loaded before of edit some file.
-func loadConfig() {
-	config.Do(func() {
-		//checkRoot()
-		if err := config.init(false); err != nil {
-			panic(err)
-		}
-	})
-}
+func loadConfig() { defaultRoot.loadConfig() }
 
 // loadConfig loads the user configuration.
 // It also prints the information about the configuration being read.
 func loadConfigWithDebug() {
 	config.Do(func() {
 		//checkRoot()
-		if err := config.init(true); err != nil {
+		if err := config.init(defaultRoot, true); err != nil {
 			panic(err)
 		}
 	})
@@ -50,24 +48,76 @@ type configData struct {
 	login   confLogin
 	useradd confUseradd
 
-	crypter crypt.Crypter
+	// Regular expressions to check the names of users and groups, if any.
+	// The errors of the expressions not valid are reported at validating.
+	nameRegex       *regexp.Regexp
+	sysNameRegex    *regexp.Regexp
+	nameRegexErr    error
+	sysNameRegexErr error
+
+	// The error of a crypt function not supported in '/etc/libuser.conf' is
+	// reported at hashing.
+	cryptFn  crypt.Crypt
+	cryptErr error
+	crypter  crypt.Crypter
+
+	// Versions of the configuration files read; see Root.ReloadConfig.
+	stamps map[string]fileStamp
+
 	sync.Once
 }
 
-// init sets the configuration data.
+// set copies the configuration data from c2.
+func (c *configData) set(c2 *configData) {
+	c.login = c2.login
+	c.useradd = c2.useradd
+	c.nameRegex = c2.nameRegex
+	c.sysNameRegex = c2.sysNameRegex
+	c.nameRegexErr = c2.nameRegexErr
+	c.sysNameRegexErr = c2.sysNameRegexErr
+	c.cryptFn = c2.cryptFn
+	c.cryptErr = c2.cryptErr
+	c.crypter = c2.crypter
+	c.stamps = c2.stamps
+}
+
+// stamp records the version of the named configuration file. A file which does
+// not exist has the zero version.
+func (c *configData) stamp(r *Root, name string) {
+	if c.stamps == nil {
+		c.stamps = make(map[string]fileStamp, 4)
+	}
+	stamp, _ := getFileStamp(r.join(name))
+	c.stamps[name] = stamp
+}
+
+// isChanged reports whether some configuration file has been modified since it
+// was read.
+func (c *configData) isChanged(r *Root) bool {
+	for name, stamp := range c.stamps {
+		if now, _ := getFileStamp(r.join(name)); now != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// init sets the configuration data from the files found in the root directory.
 // The argument 'debug' prints information about the configuration being read.
-func (c *configData) init(debug bool) error {
-	_confLogin := &confLogin{}
+func (c *configData) init(r *Root, debug bool) error {
+	// The subordinate ids are only allocated whether their count is not 0,
+	// so it is got the default before of reading the file.
+	_confLogin := &confLogin{SUB_UID_COUNT: 65536, SUB_GID_COUNT: 65536}
+	for _, name := range []string{fileLogin, fileUseradd, fileAdduser, fileLibuser} {
+		c.stamp(r, name)
+	}
 
-	cfg, err := shconf.ParseFile(fileLogin)
+	err := r.unmarshalConfig(fileLogin, _confLogin)
 	if err != nil {
 		return err
 	}
-	if err = cfg.Unmarshal(_confLogin); err != nil {
-		return err
-	}
 	if debug {
-		fmt.Printf("\n* %s\n", fileLogin)
+		fmt.Printf("\n* %s\n", r.join(fileLogin))
 		printStruct(_confLogin)
 	}
 
@@ -77,17 +127,24 @@ func (c *configData) init(debug bool) error {
 	if _confLogin.PASS_WARN_AGE == 0 {
 		_confLogin.PASS_WARN_AGE = 7
 	}
-
-	cfg, err = shconf.ParseFile(fileUseradd)
-	if err != nil {
-		return err
+	if _confLogin.UMASK == "" {
+		_confLogin.UMASK = "022"
+	}
+	if _confLogin.SUB_UID_MIN == 0 || _confLogin.SUB_UID_MAX == 0 {
+		_confLogin.SUB_UID_MIN = 100000
+		_confLogin.SUB_UID_MAX = 600100000
 	}
+	if _confLogin.SUB_GID_MIN == 0 || _confLogin.SUB_GID_MAX == 0 {
+		_confLogin.SUB_GID_MIN = 100000
+		_confLogin.SUB_GID_MAX = 600100000
+	}
+
 	_confUseradd := &confUseradd{}
-	if err = cfg.Unmarshal(_confUseradd); err != nil {
+	if err = r.unmarshalConfig(fileUseradd, _confUseradd); err != nil {
 		return err
 	}
 	if debug {
-		fmt.Printf("\n* %s\n", fileUseradd)
+		fmt.Printf("\n* %s\n", r.join(fileUseradd))
 		printStruct(_confUseradd)
 	}
 
@@ -97,17 +154,20 @@ func (c *configData) init(debug bool) error {
 	if _confUseradd.SHELL == "" {
 		_confUseradd.SHELL = "/bin/sh"
 	}
-	config.useradd = *_confUseradd
+	if _confUseradd.SKEL == "" {
+		_confUseradd.SKEL = "/etc/skel"
+	}
+	c.useradd = *_confUseradd
 
 	// == Optional files
 
-	found, err := exist(fileAdduser) // Based in Debian.
+	found, err := exist(r.join(fileAdduser)) // Based in Debian.
 	if err != nil {
 		return err
 	}
 
 	if found {
-		cfg, err := shconf.ParseFile(fileAdduser)
+		cfg, err := shconf.ParseFile(r.join(fileAdduser))
 		if err != nil {
 			return err
 		}
@@ -117,10 +177,14 @@ func (c *configData) init(debug bool) error {
 			return err
 		}
 		if debug {
-			fmt.Printf("\n* %s\n", fileAdduser)
+			fmt.Printf("\n* %s\n", r.join(fileAdduser))
 			printStruct(_confAdduser)
 		}
 
+		c.nameRegex, c.nameRegexErr = compileNameRegex("NAME_REGEX", _confAdduser.NAME_REGEX)
+		c.sysNameRegex, c.sysNameRegexErr = compileNameRegex(
+			"NAME_REGEX_SYSTEM", _confAdduser.NAME_REGEX_SYSTEM)
+
 		if _confLogin.SYS_UID_MIN == 0 || _confLogin.SYS_UID_MAX == 0 ||
 			_confLogin.SYS_GID_MIN == 0 || _confLogin.SYS_GID_MAX == 0 ||
 			_confLogin.UID_MIN == 0 || _confLogin.UID_MAX == 0 ||
@@ -137,13 +201,13 @@ func (c *configData) init(debug bool) error {
 			_confLogin.GID_MAX = _confAdduser.LAST_GID
 		}
 	} else {
-		found, err = exist(fileLibuser) // Based in Red Hat.
+		found, err = exist(r.join(fileLibuser)) // Based in Red Hat.
 		if err != nil {
 			return err
 		}
 
 		if found {
-			cfg, err := ini.Load(fileLibuser)
+			cfg, err := ini.Load(r.join(fileLibuser))
 			if err != nil {
 				return err
 			}
@@ -153,14 +217,26 @@ func (c *configData) init(debug bool) error {
 				return err
 			}
 			if debug {
-				fmt.Printf("\n* %s\n", fileLibuser)
+				fmt.Printf("\n* %s\n", r.join(fileLibuser))
 				printStruct(_confLibuser)
 			}
 
-			if _confLibuser.login_defs != fileLogin {
-				_confLogin.ENCRYPT_METHOD = _confLibuser.crypt_style
-				_confLogin.SHA_CRYPT_MIN_ROUNDS = _confLibuser.hash_rounds_min
-				_confLogin.SHA_CRYPT_MAX_ROUNDS = _confLibuser.hash_rounds_max
+			// The values not set keep the ones got from login.defs.
+			if _confLibuser.Import.Login_defs != fileLogin {
+				if style := _confLibuser.Defaults.Crypt_style; style != "" {
+					method, err := libuserCryptMethod(style)
+					if err != nil {
+						c.cryptErr = err
+					} else {
+						_confLogin.ENCRYPT_METHOD = method
+					}
+				}
+				if _confLibuser.Defaults.Hash_rounds_min != 0 {
+					_confLogin.SHA_CRYPT_MIN_ROUNDS = _confLibuser.Defaults.Hash_rounds_min
+				}
+				if _confLibuser.Defaults.Hash_rounds_max != 0 {
+					_confLogin.SHA_CRYPT_MAX_ROUNDS = _confLibuser.Defaults.Hash_rounds_max
+				}
 			}
 		}
 	}
@@ -190,21 +266,19 @@ func (c *configData) init(debug bool) error {
 
 	// * * *
 
-	switch strings.ToUpper(_confLogin.ENCRYPT_METHOD) {
-	case "MD5":
-		c.crypter = crypt.New(crypt.MD5)
-	case "SHA256":
-		c.crypter = crypt.New(crypt.SHA256)
-	case "SHA512":
-		c.crypter = crypt.New(crypt.SHA512)
-	case "":
-		if c.crypter, err = lookupCrypter(); err != nil {
-			return err
+	if _confLogin.ENCRYPT_METHOD == "" {
+		c.cryptFn, err = lookupCrypt(r)
+		// A backend could start without accounts.
+		if err == ErrShadowPasswd && r.backend != nil {
+			c.cryptFn, err = crypt.SHA512, nil
 		}
-	default:
-		return fmt.Errorf("user: requested cryp function is unavailable: %s",
-			c.login.ENCRYPT_METHOD)
+	} else {
+		c.cryptFn, err = cryptFromMethod(_confLogin.ENCRYPT_METHOD)
+	}
+	if err != nil {
+		return err
 	}
+	c.crypter = newCrypter(c.cryptFn, 0)
 
 	if _confLogin.SYS_UID_MIN == 0 || _confLogin.SYS_UID_MAX == 0 ||
 		_confLogin.SYS_GID_MIN == 0 || _confLogin.SYS_GID_MAX == 0 ||
@@ -222,7 +296,136 @@ func (c *configData) init(debug bool) error {
 		_confLogin.GID_MAX = 29999
 	}
 
-	config.login = *_confLogin
+	c.login = *_confLogin
+	return nil
+}
+
+// unmarshalConfig stores the values of the named configuration file into out.
+// With a backend, a file which does not exist is skipped, so they are used the
+// default values.
+func (r *Root) unmarshalConfig(name string, out interface{}) error {
+	cfg, err := shconf.ParseFile(r.join(name))
+	if err != nil {
+		if os.IsNotExist(err) && r.backend != nil {
+			return nil
+		}
+		return err
+	}
+	return cfg.Unmarshal(out)
+}
+
+// cryptFromMethod returns the crypt function for the value of ENCRYPT_METHOD.
+func cryptFromMethod(method string) (crypt.Crypt, error) {
+	switch strings.ToUpper(method) {
+	case "MD5":
+		return crypt.MD5, nil
+	case "SHA256":
+		return crypt.SHA256, nil
+	case "SHA512":
+		return crypt.SHA512, nil
+	case "BCRYPT":
+		return crypt.BCRYPT, nil
+	case "YESCRYPT":
+		return crypt.YESCRYPT, nil
+	}
+	return 0, fmt.Errorf("user: requested cryp function is unavailable: %s", method)
+}
+
+// libuserCryptMethod returns the value of ENCRYPT_METHOD for the value of
+// crypt_style in '/etc/libuser.conf'. DES is not supported.
+func libuserCryptMethod(style string) (string, error) {
+	switch method := strings.ToUpper(style); method {
+	case "BLOWFISH":
+		return "BCRYPT", nil
+	case "MD5", "SHA256", "SHA512", "YESCRYPT":
+		return method, nil
+	}
+	return "", &ConfigError{"crypt_style", "crypt function not supported: " + style}
+}
+
+// cryptSalts has the salts of the crypt functions with a variable cost.
+var cryptSalts = map[crypt.Crypt]func() common.Salt{
+	crypt.SHA256:   sha256_crypt.GetSalt,
+	crypt.SHA512:   sha512_crypt.GetSalt,
+	crypt.BCRYPT:   bcrypt.GetSalt,
+	crypt.YESCRYPT: yescrypt.GetSalt,
+}
+
+// newCrypter returns a new crypter of the crypt function c, which hashes the
+// new passwords with the given cost; 0 is for the default one.
+func newCrypter(c crypt.Crypt, cost int) crypt.Crypter {
+	cr := crypt.New(c)
+	if getSalt, ok := cryptSalts[c]; ok && cost != 0 {
+		salt := getSalt()
+		salt.RoundsDefault = cost
+		cr.SetSalt(salt)
+	}
+	return cr
+}
+
+// cryptCost returns the cost used to hash a new password with the crypt
+// function c, got from its keys in the configuration. It is 0 when they are not
+// set. It is called for every hash, since the rounds could be random.
+func cryptCost(c crypt.Crypt, conf *confLogin) int {
+	switch c {
+	case crypt.SHA256, crypt.SHA512:
+		return cryptRounds(conf.SHA_CRYPT_MIN_ROUNDS, conf.SHA_CRYPT_MAX_ROUNDS)
+	case crypt.BCRYPT:
+		return cryptRounds(conf.BCRYPT_MIN_ROUNDS, conf.BCRYPT_MAX_ROUNDS)
+	case crypt.YESCRYPT:
+		return conf.YESCRYPT_COST_FACTOR
+	}
+	return 0
+}
+
+// cryptRounds returns a random number of rounds between both limits, like the
+// shadow utilities do for every hash. Whether only a limit is set, it is used; with none, it
+// returns 0.
+func cryptRounds(min, max int) int {
+	switch {
+	case min == 0 && max == 0:
+		return 0
+	case min == 0:
+		return max
+	case max <= min:
+		return min
+	}
+	return min + rand.Intn(max-min+1)
+}
+
+// == Reloading
+//
+
+// ReloadConfig reads again the configuration of the running system, whether
+// some file has been modified since it was read.
+// See Root.ReloadConfig.
+func ReloadConfig() (reloaded bool, err error) { return defaultRoot.ReloadConfig() }
+
+// ReloadConfig reads again the configuration files in the root directory,
+// whether some one has been modified, created or removed since they were
+// read. Returns whether the configuration has been reloaded.
+//
+// A crypt function set by SetCrypter is replaced at reloading. If there is any
+// error, the configuration is not changed.
+// It is not safe to call it while the root is used by another goroutine.
+func (r *Root) ReloadConfig() (reloaded bool, err error) {
+	r.loadConfig()
+
+	if !r.config.isChanged(r) {
+		return false, nil
+	}
+	return true, r.reloadConfig()
+}
+
+// reloadConfig reads again the configuration files in the root directory.
+func (r *Root) reloadConfig() error {
+	c := &configData{}
+	if err := c.init(r, false); err != nil {
+		return err
+	}
+
+	r.loadConfig() // To do not load it again.
+	r.config.set(c)
 	return nil
 }
 
@@ -237,6 +440,10 @@ type confLogin struct {
 	PASS_MIN_LEN  int
 	PASS_WARN_AGE int
 
+	UMASK       string // octal
+	HOME_MODE   string // octal
+	CREATE_HOME string // yes/no
+
 	SYS_UID_MIN int
 	SYS_UID_MAX int
 	SYS_GID_MIN int
@@ -247,9 +454,20 @@ type confLogin struct {
 	GID_MIN int
 	GID_MAX int
 
+	// Subordinate ids
+	SUB_UID_MIN   int
+	SUB_UID_MAX   int
+	SUB_UID_COUNT int
+	SUB_GID_MIN   int
+	SUB_GID_MAX   int
+	SUB_GID_COUNT int
+
 	ENCRYPT_METHOD       string // upper
 	SHA_CRYPT_MIN_ROUNDS int
 	SHA_CRYPT_MAX_ROUNDS int
+	BCRYPT_MIN_ROUNDS    int
+	BCRYPT_MAX_ROUNDS    int
+	YESCRYPT_COST_FACTOR int
 	// or
 	CRYPT_PREFIX string // $2a$
 	CRYPT_ROUNDS int    // 8
@@ -260,6 +478,7 @@ const fileUseradd = "/etc/default/useradd"
 type confUseradd struct {
 	HOME  string // Default to '/home'
 	SHELL string // Default to '/bin/sh'
+	SKEL  string // Default to '/etc/skel'
 }
 
 // == Optional files
@@ -278,6 +497,9 @@ type confAdduser struct {
 	LAST_UID  int
 	FIRST_GID int
 	LAST_GID  int
+
+	NAME_REGEX        string
+	NAME_REGEX_SYSTEM string
 }
 
 // Used in Arch, Manjaro, OpenSUSE.
@@ -293,10 +515,15 @@ type confPasswd struct {
 const fileLibuser = "/etc/libuser.conf"
 
 type confLibuser struct {
-	login_defs  string
-	crypt_style string // lower
+	Import struct {
+		Login_defs string `ini:"login_defs"`
+	} `ini:"import"`
+
+	Defaults struct {
+		Crypt_style string `ini:"crypt_style"` // lower
 
-	// For SHA2
-	hash_rounds_min int
-	hash_rounds_max int
+		// For SHA2
+		Hash_rounds_min int `ini:"hash_rounds_min"`
+		Hash_rounds_max int `ini:"hash_rounds_max"`
+	} `ini:"defaults"`
 }
@@ -0,0 +1,212 @@
+// Copyright 2021, Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+// Package bcrypt implements the bcrypt password hashing algorithm, created by
+// Niels Provos and David Mazières for OpenBSD.
+//
+// The specification for this algorithm can be found here:
+// https://www.usenix.org/legacy/event/usenix99/provos/provos.pdf
+//
+// The variants "$2a$", "$2b$" and "$2y$" are handled in the same way, since they
+// only differ in bugs of old implementations, at handling keys longer than 255
+// bytes or with non-ASCII characters.
+package bcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+
+	"golang.org/x/crypto/blowfish"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/common"
+)
+
+func init() {
+	crypt.RegisterCrypt(crypt.BCRYPT, New, MagicPrefix)
+}
+
+const (
+	MagicPrefix   = "$2b$"
+	SaltLenMin    = 22
+	SaltLenMax    = 22
+	RoundsMin     = 4 // The rounds are the base-2 logarithm of the iterations.
+	RoundsMax     = 31
+	RoundsDefault = 10
+)
+
+// Prefixes of the variants.
+var prefixes = []string{"$2a$", MagicPrefix, "$2y$"}
+
+const (
+	hashLen    = 31 // Length of the encoded checksum.
+	rawSaltLen = 16 // Bytes of the decoded salt.
+	magicText  = "OrpheanBeholderScryDoubt"
+)
+
+// bcryptEncoding is the Base64 variant used by bcrypt, with its own alphabet and
+// without padding.
+var bcryptEncoding = base64.NewEncoding(
+	"./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+).WithPadding(base64.NoPadding)
+
+type crypter struct{ Salt common.Salt }
+
+// New returns a new crypt.Crypter computing the bcrypt password hashing.
+func New() crypt.Crypter {
+	return &crypter{GetSalt()}
+}
+
+// Generate performs the hashing algorithm. The salt has the format
+// "$2b$<rounds>$<salt of 22 characters>", and it can be a full hash.
+// The key is truncated to 72 bytes, like in the rest of implementations.
+func (c *crypter) Generate(key, salt []byte) (string, error) {
+	if len(salt) == 0 {
+		salt = c.generateSalt()
+	}
+
+	prefix, rounds, salt, err := parseSalt(salt)
+	if err != nil {
+		return "", err
+	}
+	if len(salt) < SaltLenMin {
+		return "", common.ErrSaltFormat
+	}
+	salt = salt[:SaltLenMax]
+
+	// The last character only has 2 bits of the salt; the rest are ignored.
+	rawSalt, err := bcryptEncoding.DecodeString(string(salt))
+	if err != nil {
+		return "", common.ErrSaltFormat
+	}
+
+	sum := checksum(key, rawSalt, rounds)
+
+	out := make([]byte, 0, len(prefix)+3+SaltLenMax+hashLen)
+	out = append(out, prefix...)
+	if rounds < 10 {
+		out = append(out, '0')
+	}
+	out = strconv.AppendInt(out, int64(rounds), 10)
+	out = append(out, '$')
+	out = append(out, salt...)
+	out = append(out, bcryptEncoding.EncodeToString(sum[:23])...)
+
+	return string(out), nil
+}
+
+func (c *crypter) Verify(hashedKey string, key []byte) error {
+	newHash, err := c.Generate(key, []byte(hashedKey))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(newHash), []byte(hashedKey)) != 1 {
+		return crypt.ErrKeyMismatch
+	}
+	return nil
+}
+
+// Cost returns the rounds of the hashed key, which are the base-2 logarithm of
+// the iterations.
+func (c *crypter) Cost(hashedKey string) (int, error) {
+	_, rounds, _, err := parseSalt([]byte(hashedKey))
+	return rounds, err
+}
+
+// checksum returns the encryption of the magic text, using the Blowfish cipher
+// with the expensive key schedule.
+func checksum(key, salt []byte, rounds int) []byte {
+	// The key includes the null character, like in C, and Blowfish uses up to
+	// 72 bytes.
+	ckey := make([]byte, len(key)+1)
+	copy(ckey, key)
+	if len(ckey) > 72 {
+		ckey = ckey[:72]
+	}
+
+	c, err := blowfish.NewSaltedCipher(ckey, salt)
+	if err != nil {
+		panic(err) // The key is never empty.
+	}
+	for i, n := uint64(0), uint64(1)<<uint(rounds); i < n; i++ {
+		blowfish.ExpandKey(ckey, c)
+		blowfish.ExpandKey(salt, c)
+	}
+
+	sum := []byte(magicText)
+	for i := 0; i < len(sum); i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(sum[i:i+8], sum[i:i+8])
+		}
+	}
+
+	// Clean sensitive data.
+	for i := range ckey {
+		ckey[i] = 0
+	}
+	return sum
+}
+
+// parseSalt returns the parts of a salt or hashed key: the prefix, the rounds
+// and the rest of characters.
+func parseSalt(salt []byte) (prefix []byte, rounds int, rest []byte, err error) {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(salt, []byte(p)) {
+			prefix = salt[:len(p)]
+			break
+		}
+	}
+	if prefix == nil {
+		return nil, 0, nil, common.ErrSaltPrefix
+	}
+
+	salt = salt[len(prefix):]
+	if len(salt) < 3 || salt[2] != '$' {
+		return nil, 0, nil, common.ErrSaltFormat
+	}
+	if rounds, err = strconv.Atoi(string(salt[:2])); err != nil ||
+		rounds < RoundsMin || rounds > RoundsMax {
+		return nil, 0, nil, common.ErrSaltRounds
+	}
+	return prefix, rounds, salt[3:], nil
+}
+
+// generateSalt returns a random salt with the default rounds.
+func (c *crypter) generateSalt() []byte {
+	rounds := c.Salt.RoundsDefault
+	if rounds < RoundsMin {
+		rounds = RoundsMin
+	} else if rounds > RoundsMax {
+		rounds = RoundsMax
+	}
+
+	raw := make([]byte, rawSaltLen)
+	rand.Read(raw)
+
+	salt := append([]byte(nil), c.Salt.MagicPrefix...)
+	if rounds < 10 {
+		salt = append(salt, '0')
+	}
+	salt = strconv.AppendInt(salt, int64(rounds), 10)
+	salt = append(salt, '$')
+	return append(salt, bcryptEncoding.EncodeToString(raw)...)
+}
+
+func (c *crypter) SetSalt(salt common.Salt) { c.Salt = salt }
+
+func GetSalt() common.Salt {
+	return common.Salt{
+		MagicPrefix:   []byte(MagicPrefix),
+		SaltLenMin:    SaltLenMin,
+		SaltLenMax:    SaltLenMax,
+		RoundsDefault: RoundsDefault,
+		RoundsMin:     RoundsMin,
+		RoundsMax:     RoundsMax,
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2021, Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package bcrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/common"
+)
+
+var bcrypt = New()
+
+func TestGenerate(t *testing.T) {
+	// Got from libxcrypt, and the test vectors of Openwall's crypt_blowfish.
+	data := []struct {
+		salt []byte
+		key  []byte
+		out  string
+		cost int
+	}{
+		{
+			[]byte("$2a$05$CCCCCCCCCCCCCCCCCCCCC."),
+			[]byte("U*U"),
+			"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+			5,
+		},
+		{
+			[]byte("$2a$05$CCCCCCCCCCCCCCCCCCCCC."),
+			[]byte("U*U*"),
+			"$2a$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK",
+			5,
+		},
+		{
+			[]byte("$2a$05$XXXXXXXXXXXXXXXXXXXXXO"),
+			[]byte("U*U*U"),
+			"$2a$05$XXXXXXXXXXXXXXXXXXXXXOAcXxm9kjPGEMsLznoKqmqw7tc8WCx4a",
+			5,
+		},
+		{
+			[]byte("$2a$05$CCCCCCCCCCCCCCCCCCCCC."),
+			[]byte(""),
+			"$2a$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy",
+			5,
+		},
+		{
+			[]byte("$2b$04$abcdefghijklmnopqrstuu"),
+			[]byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+				"0123456789chars after 72 are ignored"),
+			"$2b$04$abcdefghijklmnopqrstuuRAip/W0RPQX4QKkqYqXE3GIXWH518Sm",
+			4,
+		},
+		{
+			[]byte("$2y$06$DCq7YPn5Rq63x1Lad4cll."),
+			[]byte("ünïcödé"),
+			"$2y$06$DCq7YPn5Rq63x1Lad4cll.MoEkVwuaTfreO7JNEZ3i2rmntkciY.u",
+			6,
+		},
+		{
+			[]byte("$2b$04$......................LAtw7/ohmmBAhnXqmkuIz83Rl5Qdjhm"),
+			[]byte("password"),
+			"$2b$04$......................LAtw7/ohmmBAhnXqmkuIz83Rl5Qdjhm",
+			4,
+		},
+	}
+
+	for i, d := range data {
+		hash, err := bcrypt.Generate(d.key, d.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != d.out {
+			t.Errorf("Test %d failed\nExpected: %s, got: %s", i, d.out, hash)
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != d.cost {
+			t.Errorf("Test %d failed\nExpected: %d, got: %d", i, d.cost, cost)
+		}
+	}
+
+	for _, salt := range []string{
+		"$2x$05$CCCCCCCCCCCCCCCCCCCCC.",
+		"$2b$5$CCCCCCCCCCCCCCCCCCCCC.",
+		"$2b$03$CCCCCCCCCCCCCCCCCCCCC.",
+		"$2b$05$CCCCCCCCCC",
+		"$2b$05$CCCCCCCCCCCCCCCCCCCC!.",
+	} {
+		if _, err := bcrypt.Generate([]byte("key"), []byte(salt)); err == nil {
+			t.Errorf("%s: expected to report an error", salt)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	c := New()
+	salt := GetSalt()
+	salt.RoundsDefault = RoundsMin
+	c.SetSalt(salt)
+
+	data := [][]byte{
+		[]byte("password"),
+		[]byte("12345"),
+		[]byte("That's amazing! I've got the same combination on my luggage!"),
+		[]byte("And change the combination on my luggage!"),
+		[]byte("         random  spa  c    ing."),
+		[]byte("94ajflkvjzpe8u3&*j1k513KLJ&*()"),
+	}
+	for i, d := range data {
+		hash, err := c.Generate(d, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hash, MagicPrefix+"04$") || len(hash) != 60 {
+			t.Errorf("Test %d failed: hash not expected: %s", i, hash)
+		}
+		if err = c.Verify(hash, d); err != nil {
+			t.Errorf("Test %d failed: %s", i, d)
+		}
+		if err = c.Verify(hash, append(d, 'x')); err != crypt.ErrKeyMismatch {
+			t.Errorf("Test %d failed: expected ErrKeyMismatch, got %v", i, err)
+		}
+	}
+
+	if _, err := c.Cost("$1$deadbeef$"); err != common.ErrSaltPrefix {
+		t.Errorf("expected ErrSaltPrefix, got %v", err)
+	}
+}
+
+func TestNewFromHash(t *testing.T) {
+	for _, hash := range []string{
+		"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW",
+		"$2b$04$abcdefghijklmnopqrstuuRAip/W0RPQX4QKkqYqXE3GIXWH518Sm",
+		"$2y$06$DCq7YPn5Rq63x1Lad4cll.MoEkVwuaTfreO7JNEZ3i2rmntkciY.u",
+	} {
+		if _, ok := crypt.NewFromHash(hash).(*crypter); !ok {
+			t.Errorf("%s: expected to get the bcrypt crypter", hash)
+		}
+	}
+}
@@ -52,10 +52,12 @@ type Crypter interface {
 type Crypt uint
 
 const (
-	APR1   Crypt = iota + 1 // import "github.com/p3ls/osutil/v2/user/crypt/apr1_crypt"
-	MD5                     // import "github.com/p3ls/osutil/v2/user/crypt/md5_crypt"
-	SHA256                  // import "github.com/p3ls/osutil/v2/user/crypt/sha256_crypt"
-	SHA512                  // import "github.com/p3ls/osutil/v2/user/crypt/sha512_crypt"
+	APR1     Crypt = iota + 1 // import "github.com/p3ls/osutil/v2/user/crypt/apr1_crypt"
+	MD5                       // import "github.com/p3ls/osutil/v2/user/crypt/md5_crypt"
+	SHA256                    // import "github.com/p3ls/osutil/v2/user/crypt/sha256_crypt"
+	SHA512                    // import "github.com/p3ls/osutil/v2/user/crypt/sha512_crypt"
+	BCRYPT                    // import "github.com/p3ls/osutil/v2/user/crypt/bcrypt"
+	YESCRYPT                  // import "github.com/p3ls/osutil/v2/user/crypt/yescrypt"
 	maxCrypt
 )
 
@@ -63,6 +65,9 @@ var cryptPrefixes = make([]string, maxCrypt)
 
 var crypts = make([]func() Crypter, maxCrypt)
 
+// Prefixes of the variants of bcrypt, which are handled by the same function.
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
 // RegisterCrypt registers a function that returns a new instance of the given
 // crypt function. This is intended to be called from the init function in
 // packages that implement crypt functions.
@@ -85,24 +90,51 @@ func New(c Crypt) Crypter {
 
 // NewFromHash returns a new Crypter using the prefix in the given hashed key.
 func NewFromHash(hashedKey string) Crypter {
-	var f func() Crypter
-
-	if strings.HasPrefix(hashedKey, cryptPrefixes[SHA512]) {
-		f = crypts[SHA512]
-	} else if strings.HasPrefix(hashedKey, cryptPrefixes[SHA256]) {
-		f = crypts[SHA256]
-	} else if strings.HasPrefix(hashedKey, cryptPrefixes[MD5]) {
-		f = crypts[MD5]
-	} else if strings.HasPrefix(hashedKey, cryptPrefixes[APR1]) {
-		f = crypts[APR1]
-	} else {
-		toks := strings.SplitN(hashedKey, "$", 3)
-		prefix := "$" + toks[1] + "$"
+	c, ok := FromHash(hashedKey)
+	if !ok {
+		prefix := hashedKey
+		if toks := strings.SplitN(hashedKey, "$", 3); len(toks) > 1 {
+			prefix = "$" + toks[1] + "$"
+		}
 		panic("crypt: unknown cryp function from prefix: " + prefix)
 	}
+	return New(c)
+}
 
-	if f != nil {
-		return f()
+// FromHash returns the crypt function using the prefix in the given hashed key,
+// and whether it is a registered one.
+func FromHash(hashedKey string) (Crypt, bool) {
+	var c Crypt
+
+	if hasPrefix(hashedKey, cryptPrefixes[SHA512]) {
+		c = SHA512
+	} else if hasPrefix(hashedKey, cryptPrefixes[SHA256]) {
+		c = SHA256
+	} else if hasPrefix(hashedKey, cryptPrefixes[MD5]) {
+		c = MD5
+	} else if hasPrefix(hashedKey, cryptPrefixes[APR1]) {
+		c = APR1
+	} else if hasPrefix(hashedKey, bcryptPrefixes...) {
+		c = BCRYPT
+	} else if hasPrefix(hashedKey, cryptPrefixes[YESCRYPT]) {
+		c = YESCRYPT
+	}
+
+	// The prefixes of bcrypt are matched even when it is not registered, so it
+	// is checked that the function found has been registered.
+	if c == 0 || crypts[c] == nil {
+		return 0, false
+	}
+	return c, true
+}
+
+// hasPrefix reports whether s begins with any of the prefixes. The empty ones,
+// of crypt functions not registered, are skipped.
+func hasPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if p != "" && strings.HasPrefix(s, p) {
+			return true
+		}
 	}
-	panic("crypt: requested cryp function is unavailable")
+	return false
 }
@@ -0,0 +1,41 @@
+// Copyright 2021, Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+// No crypt function is registered into this package.
+
+func TestFromHash(t *testing.T) {
+	for _, hash := range []string{
+		"$2b$04$abcdefghijklmnopqrstuuRAip/W0RPQX4QKkqYqXE3GIXWH518Sm",
+		"$6$salt$hash",
+		"plain",
+		"",
+	} {
+		if c, ok := FromHash(hash); ok {
+			t.Errorf("%q: expected a crypt function not registered, got %d", hash, c)
+		}
+	}
+}
+
+func TestNewFromHashPanic(t *testing.T) {
+	for _, hash := range []string{"plain", "$2b$04$x"} {
+		func() {
+			defer func() {
+				msg, ok := recover().(string)
+				if !ok || !strings.HasPrefix(msg, "crypt: unknown cryp function") {
+					t.Errorf("%q: panic not expected: %v", hash, msg)
+				}
+			}()
+			NewFromHash(hash)
+		}()
+	}
+}
@@ -7,7 +7,10 @@
 
 package sha256_crypt
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 var sha256Crypt = New()
 
@@ -107,3 +110,29 @@ func TestVerify(t *testing.T) {
 		}
 	}
 }
+
+func TestRounds(t *testing.T) {
+	c := New()
+	salt := GetSalt()
+	salt.RoundsDefault = 65536
+	c.SetSalt(salt)
+
+	hash, err := c.Generate([]byte("password"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, MagicPrefix+"rounds=65536$") {
+		t.Fatalf("expected the rounds in the hash, got %s", hash)
+	}
+	if cost, err := c.Cost(hash); err != nil || cost != 65536 {
+		t.Errorf("expected cost 65536, got %d (%v)", cost, err)
+	}
+	if err = c.Verify(hash, []byte("password")); err != nil {
+		t.Error(err)
+	}
+
+	// The default rounds are not written.
+	if hash, _ = New().Generate([]byte("password"), nil); strings.Contains(hash, "rounds=") {
+		t.Errorf("expected the default rounds, got %s", hash)
+	}
+}
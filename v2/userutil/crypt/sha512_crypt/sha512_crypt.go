@@ -48,7 +48,7 @@ func (c *crypter) Generate(key, salt []byte) (string, error) {
 	var isRoundsDef bool
 
 	if len(salt) == 0 {
-		salt = c.Salt.GenerateWRounds(SaltLenMax, RoundsDefault)
+		salt = c.generateSalt()
 	}
 	if !bytes.HasPrefix(salt, c.Salt.MagicPrefix) {
 		return "", common.ErrSaltPrefix
@@ -252,3 +252,11 @@ func GetSalt() common.Salt {
 		RoundsMax:     RoundsMax,
 	}
 }
+
+// generateSalt returns a random salt with the rounds of c.Salt.RoundsDefault.
+// The "rounds=" part is only added when they are not the default ones.
+func (c *crypter) generateSalt() []byte {
+	salt := c.Salt
+	salt.RoundsDefault = RoundsDefault
+	return salt.GenerateWRounds(SaltLenMax, c.Salt.RoundsDefault)
+}
@@ -0,0 +1,531 @@
+// Copyright 2021, Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package yescrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Flags of the flavor.
+const (
+	flagWORM = 1
+	flagRW   = 2
+
+	// Settings of pwxform used by default, and the only ones supported:
+	// YESCRYPT_ROUNDS_6 | YESCRYPT_GATHER_4 | YESCRYPT_SIMPLE_2 | YESCRYPT_SBOX_12K
+	flagsRWFlavor = 0x04 | 0x10 | 0x20 | 0x80
+
+	flagsDefault = flagRW | flagsRWFlavor
+)
+
+// Settings of pwxform.
+const (
+	pwxSimple = 2
+	pwxGather = 4
+	pwxRounds = 6
+	sWidth    = 8
+
+	pwxWords = pwxGather * pwxSimple * 2         // Words of 32 bits of a block.
+	sWords   = 3 * (1 << sWidth) * pwxSimple * 2 // Words of the 3 S-boxes.
+	sMask    = ((1 << sWidth) - 1) * pwxSimple * 8
+	sBoxSize = (1 << sWidth) * pwxSimple * 2 // Words of a S-box.
+)
+
+// memLimit is the maximum memory used by the KDF, which is 4 times the one used
+// with the maximum cost factor of libxcrypt.
+const memLimit = 1 << 32
+
+var errParams = errors.New("invalid parameters")
+
+// params represents the parameters of the KDF.
+type params struct {
+	flags uint32
+	N     uint64
+	r, p  uint32
+	t     uint32
+}
+
+// check checks whether the parameters are supported, like in yescrypt_kdf_body.
+func (pr *params) check() error {
+	switch pr.flags & (flagWORM | flagRW) {
+	case 0: // Classic scrypt.
+		if pr.flags != 0 || pr.t != 0 {
+			return errParams
+		}
+	case flagWORM:
+		if pr.flags != flagWORM {
+			return errParams
+		}
+	case flagRW:
+		if pr.flags != flagsDefault || pr.N/uint64(pr.p) <= 1 {
+			return errParams
+		}
+	default:
+		return errParams
+	}
+
+	minN := uint64(2)
+	if pr.flags&flagRW != 0 {
+		minN = 4
+	}
+	if pr.r < 1 || pr.p < 1 || uint64(pr.r)*uint64(pr.p) >= 1<<30 ||
+		pr.N < minN || pr.N&(pr.N-1) != 0 ||
+		pr.N > memLimit/128/uint64(pr.r) {
+		return errParams
+	}
+	return nil
+}
+
+// kdf derives a key of 32 bytes from the password and the salt.
+func kdf(passwd, salt []byte, pr *params) []byte {
+	// Pre-hash the password with a smaller memory, to find out quickly the
+	// wrong passwords in systems with several ones.
+	if pr.flags&flagRW != 0 && pr.N/uint64(pr.p) >= 0x100 &&
+		pr.N/uint64(pr.p)*uint64(pr.r) >= 0x20000 {
+		passwd = kdfBody(passwd, salt, pr.flags, true, pr.N>>6, pr.r, pr.p, 0)
+	}
+	return kdfBody(passwd, salt, pr.flags, false, pr.N, pr.r, pr.p, pr.t)
+}
+
+func kdfBody(passwd, salt []byte, flags uint32, prehash bool, N uint64, r, p, t uint32) []byte {
+	if flags != 0 {
+		key := "yescrypt"
+		if prehash {
+			key = "yescrypt-prehash"
+		}
+		passwd = hmacSHA256([]byte(key), passwd)
+	}
+
+	// 1: (B_0 ... B_{p-1}) <-- PBKDF2(P, S, 1, p * MFLen)
+	bBytes := pbkdf2SHA256(passwd, salt, 128*int(r)*int(p))
+	B := make([]uint32, len(bBytes)/4)
+	for i := range B {
+		B[i] = binary.LittleEndian.Uint32(bBytes[i*4:])
+	}
+
+	if flags != 0 {
+		passwd = append([]byte(nil), bBytes[:32]...)
+	}
+
+	s := 32 * int(r)
+	V := make([]uint32, uint64(s)*N)
+	XY := make([]uint32, 2*s)
+
+	if flags&flagRW != 0 {
+		ctxs := make([]pwxformCtx, p)
+		for i := range ctxs {
+			ctxs[i].S = make([]uint32, sWords)
+		}
+		smix(B, int(r), N, p, t, flags, V, XY, ctxs, passwd)
+	} else {
+		for i := 0; i < int(p); i++ {
+			smix(B[i*s:(i+1)*s], int(r), N, 1, t, flags, V, XY, nil, nil)
+		}
+	}
+
+	for i, v := range B {
+		binary.LittleEndian.PutUint32(bBytes[i*4:], v)
+	}
+
+	// 5: DK <-- PBKDF2(P, B, 1, dkLen)
+	dk := pbkdf2SHA256(passwd, bBytes, 32)
+
+	// The last steps match the ones of SCRAM (RFC 5802).
+	if flags != 0 && !prehash {
+		clientKey := hmacSHA256(dk, []byte("Client Key"))
+		storedKey := sha256.Sum256(clientKey)
+		dk = storedKey[:]
+	}
+	return dk
+}
+
+// smix computes B = SMix_r(B, N), using the S-boxes in RW mode.
+func smix(B []uint32, r int, N uint64, p, t, flags uint32, V, XY []uint32, ctxs []pwxformCtx, passwd []byte) {
+	s := 32 * r
+
+	// 1: n <-- N / p
+	nChunk := N / uint64(p)
+
+	// 2: Nloop_all <-- fNloop(n, t, flags)
+	nLoopAll := nChunk
+	if flags&flagRW != 0 {
+		if t <= 1 {
+			if t != 0 {
+				nLoopAll *= 2 // 2/3
+			}
+			nLoopAll = (nLoopAll + 2) / 3 // 1/3, round up
+		} else {
+			nLoopAll *= uint64(t) - 1
+		}
+	} else if t != 0 {
+		if t == 1 {
+			nLoopAll += (nLoopAll + 1) / 2 // 1.5, round up
+		}
+		nLoopAll *= uint64(t)
+	}
+
+	// 6: Nloop_rw <-- 0
+	var nLoopRW uint64
+	if flags&flagRW != 0 {
+		// 4: Nloop_rw <-- Nloop_all / p
+		nLoopRW = nLoopAll / uint64(p)
+	}
+
+	nChunk &^= 1                   // 8: round down to even
+	nLoopAll = (nLoopAll + 1) &^ 1 // 9: round up to even
+	nLoopRW = (nLoopRW + 1) &^ 1   // 10: round up to even
+
+	var vChunk uint64
+	for i := 0; i < int(p); i, vChunk = i+1, vChunk+nChunk {
+		np := nChunk
+		if i == int(p)-1 {
+			np = N - vChunk
+		}
+		bp := B[i*s : (i+1)*s]
+		vp := V[vChunk*uint64(s):]
+
+		var ctx *pwxformCtx
+		if flags&flagRW != 0 {
+			ctx = &ctxs[i]
+			// 18: SMIX1(B_i, Sbytes / 128, S_i, flags excluding YESCRYPT_RW)
+			smix1(bp, 1, sWords*4/128, 0, ctx.S, XY, nil)
+			ctx.s2, ctx.s1, ctx.s0 = 0, sBoxSize, 2*sBoxSize
+			ctx.w = 0
+
+			if i == 0 {
+				// 20: passwd <-- HMAC-SHA256(B_{i,2r-1}, passwd)
+				key := make([]byte, 64)
+				for k, v := range bp[s-16:] {
+					binary.LittleEndian.PutUint32(key[k*4:], v)
+				}
+				copy(passwd, hmacSHA256(key, passwd))
+			}
+		}
+
+		// 22: SMIX1(B_i, n, V_{u..v}, flags)
+		smix1(bp, r, np, flags, vp, XY, ctx)
+		// 23: SMIX2(B_i, p2floor(n), Nloop_rw, V_{u..v}, flags)
+		smix2(bp, r, p2floor(np), nLoopRW, flags, vp, XY, ctx)
+	}
+
+	// 24: for i = 0 to p - 1 do
+	for i := 0; i < int(p); i++ {
+		var ctx *pwxformCtx
+		if flags&flagRW != 0 {
+			ctx = &ctxs[i]
+		}
+		// 25: SMIX2(B_i, N, Nloop_all - Nloop_rw, V, flags excluding YESCRYPT_RW)
+		smix2(B[i*s:(i+1)*s], r, N, nLoopAll-nLoopRW, flags&^flagRW, V, XY, ctx)
+	}
+}
+
+// smix1 computes the first loop of B = SMix_r(B, N).
+//
+// The words of every block of 64 bytes are shuffled while they are in X, like
+// in the implementations using SIMD; it changes the results of pwxform.
+func smix1(B []uint32, r int, N uint64, flags uint32, V, XY []uint32, ctx *pwxformCtx) {
+	s := 32 * r
+	X, Y := XY[:s], XY[s:2*s]
+
+	// 1: X <-- B
+	shuffle(X, B)
+
+	// 2: for i = 0 to N - 1 do
+	for i := uint64(0); i < N; i++ {
+		// 3: V_i <-- X
+		copy(V[i*uint64(s):], X)
+
+		if flags&flagRW != 0 && i > 1 {
+			// j <-- Wrap(Integerify(X), i)
+			j := wrap(integerify(X, r), i)
+			// X <-- X xor V_j
+			blkxor(X, V[j*uint64(s):])
+		}
+
+		// 4: X <-- H(X)
+		if ctx != nil {
+			ctx.blockmix(X, r)
+		} else {
+			blockmixSalsa8(X, Y, r)
+		}
+	}
+
+	// B' <-- X
+	unshuffle(B, X)
+}
+
+// smix2 computes the second loop of B = SMix_r(B, N).
+func smix2(B []uint32, r int, N, nLoop uint64, flags uint32, V, XY []uint32, ctx *pwxformCtx) {
+	if nLoop == 0 {
+		return
+	}
+	s := 32 * r
+	X, Y := XY[:s], XY[s:2*s]
+
+	shuffle(X, B)
+
+	// 6: for i = 0 to N - 1 do
+	for i := uint64(0); i < nLoop; i++ {
+		// 7: j <-- Integerify(X) mod N
+		j := integerify(X, r) & (N - 1)
+		vj := V[j*uint64(s) : (j+1)*uint64(s)]
+
+		// 8.1: X <-- X xor V_j
+		blkxor(X, vj)
+		// V_j <-- X
+		if flags&flagRW != 0 {
+			copy(vj, X)
+		}
+
+		// 8.2: X <-- H(X)
+		if ctx != nil {
+			ctx.blockmix(X, r)
+		} else {
+			blockmixSalsa8(X, Y, r)
+		}
+	}
+
+	// 10: B' <-- X
+	unshuffle(B, X)
+}
+
+// shuffle copies the blocks of src into dst, shuffling their words.
+func shuffle(dst, src []uint32) {
+	for k := 0; k < len(dst); k += 16 {
+		for i := 0; i < 16; i++ {
+			dst[k+i] = src[k+i*5%16]
+		}
+	}
+}
+
+// unshuffle copies the blocks of src into dst, restoring the order of words.
+func unshuffle(dst, src []uint32) {
+	for k := 0; k < len(src); k += 16 {
+		for i := 0; i < 16; i++ {
+			dst[k+i*5%16] = src[k+i]
+		}
+	}
+}
+
+// integerify returns the result of parsing B_{2r-1} as a little-endian integer.
+func integerify(B []uint32, r int) uint64 {
+	X := B[(2*r-1)*16:]
+	return uint64(X[13])<<32 + uint64(X[0]) // Words 0 and 1, shuffled.
+}
+
+// p2floor returns the largest power of 2 not greater than x.
+func p2floor(x uint64) uint64 {
+	return 1 << uint(bits.Len64(x)-1)
+}
+
+// wrap wraps x to the range 0 to i-1.
+func wrap(x, i uint64) uint64 {
+	n := p2floor(i)
+	return (x & (n - 1)) + (i - n)
+}
+
+func blkxor(dst, src []uint32) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// == Block mixing
+//
+
+// blockmixSalsa8 computes B = BlockMix_{salsa20/8, r}(B), using Y as temporary
+// space.
+func blockmixSalsa8(B, Y []uint32, r int) {
+	var X [16]uint32
+
+	// 1: X <-- B_{2r - 1}
+	copy(X[:], B[(2*r-1)*16:])
+
+	// 2: for i = 0 to 2r - 1 do
+	for i := 0; i < 2*r; i++ {
+		// 3: X <-- H(X xor B_i)
+		blkxor(X[:], B[i*16:])
+		salsa20(&X, 8)
+		// 4: Y_i <-- X
+		copy(Y[i*16:], X[:])
+	}
+
+	// 6: B' <-- (Y_0, Y_2 ... Y_{2r-2}, Y_1, Y_3 ... Y_{2r-1})
+	for i := 0; i < r; i++ {
+		copy(B[i*16:(i+1)*16], Y[(i*2)*16:])
+		copy(B[(i+r)*16:(i+r+1)*16], Y[(i*2+1)*16:])
+	}
+}
+
+// pwxformCtx is the state of pwxform: the S-boxes, given as offsets in S, and
+// the index to write in S2.
+type pwxformCtx struct {
+	S          []uint32
+	s0, s1, s2 int
+	w          int
+}
+
+// blockmix computes B = BlockMix_pwxform{salsa20/2, ctx, r}(B).
+func (ctx *pwxformCtx) blockmix(B []uint32, r int) {
+	var X [pwxWords]uint32
+
+	// 1: r_1 <-- 128r / PWXbytes
+	r1 := 2 * r
+
+	// 2: X <-- B'_{r_1 - 1}
+	copy(X[:], B[(r1-1)*pwxWords:])
+
+	// 3: for i = 0 to r_1 - 1 do
+	for i := 0; i < r1; i++ {
+		// 5: X <-- X xor B'_i
+		if r1 > 1 {
+			blkxor(X[:], B[i*pwxWords:])
+		}
+		// 7: X <-- pwxform(X)
+		ctx.pwxform(&X)
+		// 8: B'_i <-- X
+		copy(B[i*pwxWords:], X[:])
+	}
+
+	// 11: B_i <-- H(B_i), with i the last block
+	var last [16]uint32
+	i := (r1 - 1) * 16
+	copy(last[:], B[i:])
+	salsa20(&last, 2)
+	copy(B[i:], last[:])
+}
+
+// pwxform transforms the block using the S-boxes.
+func (ctx *pwxformCtx) pwxform(B *[pwxWords]uint32) {
+	S := ctx.S
+	s0, s1, s2 := ctx.s0, ctx.s1, ctx.s2
+	w := ctx.w
+
+	// 1: for i = 0 to PWXrounds - 1 do
+	for i := 0; i < pwxRounds; i++ {
+		// 2: for j = 0 to PWXgather - 1 do
+		for j := 0; j < pwxGather; j++ {
+			X := B[j*pwxSimple*2 : (j+1)*pwxSimple*2]
+
+			// 3: p0 <-- (lo(B_{j,0}) & Smask) / (PWXsimple * 8)
+			p0 := s0 + int(X[0]&sMask)/4
+			// 4: p1 <-- (hi(B_{j,0}) & Smask) / (PWXsimple * 8)
+			p1 := s1 + int(X[1]&sMask)/4
+
+			// 5: for k = 0 to PWXsimple - 1 do
+			for k := 0; k < pwxSimple; k++ {
+				// 6: B_{j,k} <-- (hi(B_{j,k}) * lo(B_{j,k}) + S0_{p0,k}) xor S1_{p1,k}
+				x := uint64(X[2*k+1]) * uint64(X[2*k])
+				x += uint64(S[p0+2*k+1])<<32 + uint64(S[p0+2*k])
+				x ^= uint64(S[p1+2*k+1])<<32 + uint64(S[p1+2*k])
+
+				X[2*k] = uint32(x)
+				X[2*k+1] = uint32(x >> 32)
+			}
+
+			// 8: if (i != 0) and (i != PWXrounds - 1)
+			if i != 0 && i != pwxRounds-1 {
+				// 10: S2_w <-- B_{j,k}
+				for k := 0; k < pwxSimple; k++ {
+					S[s2+2*w] = X[2*k]
+					S[s2+2*w+1] = X[2*k+1]
+					w++
+				}
+			}
+		}
+	}
+
+	// 15: (S0, S1, S2) <-- (S2, S0, S1)
+	ctx.s0, ctx.s1, ctx.s2 = s2, s0, s1
+	// 16: w <-- w mod 2^Swidth
+	ctx.w = w & ((1<<sWidth)*pwxSimple - 1)
+}
+
+// salsa20 applies the Salsa20 core to the block, whose words are shuffled.
+func salsa20(B *[16]uint32, rounds int) {
+	var x [16]uint32
+	for i := 0; i < 16; i++ {
+		x[i*5%16] = B[i]
+	}
+
+	for i := 0; i < rounds; i += 2 {
+		// Columns
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		// Rows
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+
+	for i := 0; i < 16; i++ {
+		B[i] += x[i*5%16]
+	}
+}
+
+// == SHA-256
+//
+
+func hmacSHA256(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 derives a key with an only iteration of PBKDF2-HMAC-SHA256.
+func pbkdf2SHA256(passwd, salt []byte, keyLen int) []byte {
+	h := hmac.New(sha256.New, passwd)
+	dk := make([]byte, 0, keyLen+sha256.Size)
+	var counter [4]byte
+
+	for i := uint32(1); len(dk) < keyLen; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h.Reset()
+		h.Write(salt)
+		h.Write(counter[:])
+		dk = h.Sum(dk)
+	}
+	return dk[:keyLen]
+}
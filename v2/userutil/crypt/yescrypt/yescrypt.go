@@ -0,0 +1,335 @@
+// Copyright 2021, Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+// Package yescrypt implements the yescrypt password hashing algorithm, created
+// by Alexander Peslyak, which is based on scrypt.
+//
+// The specification and the reference implementation can be found here:
+// https://www.openwall.com/yescrypt/
+//
+// The hashes have the format used by libxcrypt:
+// "$y$<parameters>$<salt>$<hash>". It is supported the flavor used by default,
+// and the ones of classic scrypt and YESCRYPT_WORM; it is not supported the use
+// of a ROM.
+package yescrypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"math/bits"
+	"strings"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/common"
+)
+
+func init() {
+	crypt.RegisterCrypt(crypt.YESCRYPT, New, MagicPrefix)
+}
+
+const (
+	MagicPrefix   = "$y$"
+	SaltLenMin    = 0  // Characters of the encoded salt.
+	SaltLenMax    = 86 // 64 bytes.
+	RoundsMin     = 1  // The rounds are the cost factor, like in YESCRYPT_COST_FACTOR.
+	RoundsMax     = 11
+	RoundsDefault = 5
+)
+
+const (
+	alphabet   = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	rawSaltLen = 16 // Bytes of the salt generated.
+)
+
+type crypter struct{ Salt common.Salt }
+
+// New returns a new crypt.Crypter computing the yescrypt password hashing.
+func New() crypt.Crypter {
+	return &crypter{GetSalt()}
+}
+
+// Generate performs the hashing algorithm. The salt has the format
+// "$y$<parameters>$<salt>", and it can be a full hash.
+func (c *crypter) Generate(key, salt []byte) (string, error) {
+	if len(salt) == 0 {
+		salt = c.generateSalt()
+	}
+
+	pr, setting, saltStr, err := parseSalt(string(salt))
+	if err != nil {
+		return "", err
+	}
+	if err = pr.check(); err != nil {
+		return "", common.ErrSaltRounds
+	}
+	rawSalt, err := decode64(saltStr)
+	if err != nil {
+		return "", err
+	}
+
+	hash := kdf(key, rawSalt, pr)
+
+	return setting + "$" + string(common.Base64_24Bit(hash)), nil
+}
+
+func (c *crypter) Verify(hashedKey string, key []byte) error {
+	newHash, err := c.Generate(key, []byte(hashedKey))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(newHash), []byte(hashedKey)) != 1 {
+		return crypt.ErrKeyMismatch
+	}
+	return nil
+}
+
+// Cost returns the cost factor of the hashed key, like YESCRYPT_COST_FACTOR,
+// which is got from the memory used: 2^(cost+12) * 128 bytes.
+func (c *crypter) Cost(hashedKey string) (int, error) {
+	pr, _, _, err := parseSalt(hashedKey)
+	if err != nil {
+		return 0, err
+	}
+	return bits.Len64(pr.N) - 1 + bits.Len32(pr.r) - 1 - 12, nil
+}
+
+func (c *crypter) SetSalt(salt common.Salt) { c.Salt = salt }
+
+func GetSalt() common.Salt {
+	return common.Salt{
+		MagicPrefix:   []byte(MagicPrefix),
+		SaltLenMin:    SaltLenMin,
+		SaltLenMax:    SaltLenMax,
+		RoundsDefault: RoundsDefault,
+		RoundsMin:     RoundsMin,
+		RoundsMax:     RoundsMax,
+	}
+}
+
+// generateSalt returns a random salt with the default cost factor, using the
+// parameters chosen by libxcrypt.
+func (c *crypter) generateSalt() []byte {
+	cost := c.Salt.RoundsDefault
+	if cost < RoundsMin {
+		cost = RoundsMin
+	} else if cost > RoundsMax {
+		cost = RoundsMax
+	}
+
+	pr := &params{flags: flagsDefault, p: 1}
+	if cost < 3 {
+		pr.r = 8
+		pr.N = 1 << uint(cost+9)
+	} else {
+		pr.r = 32
+		pr.N = 1 << uint(cost+7)
+	}
+
+	raw := make([]byte, rawSaltLen)
+	rand.Read(raw)
+
+	return []byte(encodeParams(pr) + "$" + string(common.Base64_24Bit(raw)))
+}
+
+// == Encoding
+//
+
+// encodeParams returns the prefix with the parameters.
+func encodeParams(pr *params) string {
+	flavor := pr.flags
+	if flavor >= flagRW {
+		flavor = flagRW + (pr.flags >> 2)
+	}
+
+	var b strings.Builder
+	b.WriteString(MagicPrefix)
+	b.WriteString(encode64Uint32(flavor, 0))
+	b.WriteString(encode64Uint32(uint32(bits.Len64(pr.N)-1), 1))
+	b.WriteString(encode64Uint32(pr.r, 1))
+
+	var have uint32
+	if pr.p != 1 {
+		have |= 1
+	}
+	if pr.t != 0 {
+		have |= 2
+	}
+	if have != 0 {
+		b.WriteString(encode64Uint32(have, 1))
+	}
+	if pr.p != 1 {
+		b.WriteString(encode64Uint32(pr.p, 2))
+	}
+	if pr.t != 0 {
+		b.WriteString(encode64Uint32(pr.t, 1))
+	}
+	return b.String()
+}
+
+// parseSalt returns the parameters of a salt or hashed key, the setting (the
+// prefix until the salt) and the encoded salt.
+func parseSalt(salt string) (pr *params, setting, saltStr string, err error) {
+	if !strings.HasPrefix(salt, MagicPrefix) {
+		return nil, "", "", common.ErrSaltPrefix
+	}
+	src := salt[len(MagicPrefix):]
+	pr = &params{p: 1}
+
+	var flavor, nLog2, have uint32
+	if src, err = decode64Uint32(&flavor, src, 0); err != nil {
+		return nil, "", "", err
+	}
+	if flavor < flagRW {
+		pr.flags = flavor
+	} else if flavor <= flagRW+(0x3fc>>2) {
+		pr.flags = flagRW + (flavor-flagRW)<<2
+	} else {
+		return nil, "", "", common.ErrSaltFormat
+	}
+
+	if src, err = decode64Uint32(&nLog2, src, 1); err != nil {
+		return nil, "", "", err
+	}
+	if nLog2 > 63 {
+		return nil, "", "", common.ErrSaltRounds
+	}
+	pr.N = 1 << nLog2
+
+	if src, err = decode64Uint32(&pr.r, src, 1); err != nil {
+		return nil, "", "", err
+	}
+
+	if src != "" && src[0] != '$' {
+		if src, err = decode64Uint32(&have, src, 1); err != nil {
+			return nil, "", "", err
+		}
+		if have&1 != 0 {
+			if src, err = decode64Uint32(&pr.p, src, 2); err != nil {
+				return nil, "", "", err
+			}
+		}
+		if have&2 != 0 {
+			if src, err = decode64Uint32(&pr.t, src, 1); err != nil {
+				return nil, "", "", err
+			}
+		}
+		// The upgrades of hashes and the ROM are not supported.
+		if have&^3 != 0 {
+			return nil, "", "", common.ErrSaltFormat
+		}
+	}
+
+	if src == "" || src[0] != '$' {
+		return nil, "", "", common.ErrSaltFormat
+	}
+	saltStr = src[1:]
+	if i := strings.LastIndexByte(saltStr, '$'); i != -1 {
+		saltStr = saltStr[:i]
+	}
+	setting = salt[:len(salt)-len(src)+1+len(saltStr)]
+	return pr, setting, saltStr, nil
+}
+
+// encode64Uint32 encodes an integer with a variable length, where the first
+// character sets the number of characters.
+func encode64Uint32(src, min uint32) string {
+	var start, end, chars, nbits uint32 = 0, 47, 1, 0
+	src -= min
+
+	for {
+		count := (end + 1 - start) << nbits
+		if src < count {
+			break
+		}
+		start = end + 1
+		end = start + (62-end)/2
+		src -= count
+		chars++
+		nbits += 6
+	}
+
+	b := []byte{alphabet[start+(src>>nbits)]}
+	for chars--; chars > 0; chars-- {
+		nbits -= 6
+		b = append(b, alphabet[(src>>nbits)&0x3f])
+	}
+	return string(b)
+}
+
+// decode64Uint32 decodes an integer encoded by encode64Uint32 at the beginning
+// of src, and returns the rest of characters.
+func decode64Uint32(dst *uint32, src string, min uint32) (string, error) {
+	var start, end, chars, nbits uint32 = 0, 47, 1, 0
+
+	if src == "" {
+		return "", common.ErrSaltFormat
+	}
+	c := strings.IndexByte(alphabet, src[0])
+	if c == -1 {
+		return "", common.ErrSaltFormat
+	}
+	src = src[1:]
+
+	v := min
+	for uint32(c) > end {
+		v += (end + 1 - start) << nbits
+		start = end + 1
+		end = start + (62-end)/2
+		chars++
+		nbits += 6
+	}
+	v += (uint32(c) - start) << nbits
+
+	for chars--; chars > 0; chars-- {
+		if src == "" {
+			return "", common.ErrSaltFormat
+		}
+		if c = strings.IndexByte(alphabet, src[0]); c == -1 {
+			return "", common.ErrSaltFormat
+		}
+		src = src[1:]
+		nbits -= 6
+		v += uint32(c) << nbits
+	}
+
+	*dst = v
+	return src, nil
+}
+
+// decode64 decodes the salt, encoded like in common.Base64_24Bit.
+func decode64(src string) ([]byte, error) {
+	var dst []byte
+
+	for src != "" {
+		var value, nbits uint32
+
+		for src != "" && nbits < 24 {
+			c := strings.IndexByte(alphabet, src[0])
+			if c == -1 {
+				return nil, common.ErrSaltFormat
+			}
+			src = src[1:]
+			value |= uint32(c) << nbits
+			nbits += 6
+		}
+		if nbits < 12 { // It has to be at least a full byte.
+			return nil, common.ErrSaltFormat
+		}
+
+		for ; nbits >= 8; nbits -= 8 {
+			dst = append(dst, byte(value))
+			value >>= 8
+		}
+		if value != 0 { // The remaining bits have to be 0.
+			return nil, common.ErrSaltFormat
+		}
+	}
+
+	if len(dst) > (SaltLenMax*6)/8 {
+		return nil, common.ErrSaltFormat
+	}
+	return dst, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2021, Jonas mg
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package yescrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+	"github.com/p3ls/osutil/v2/userutil/crypt/common"
+)
+
+var yescrypt = New()
+
+func TestGenerate(t *testing.T) {
+	// Got from libxcrypt.
+	data := []struct {
+		salt []byte
+		key  []byte
+		out  string
+		cost int
+	}{
+		{
+			[]byte("$y$j9T$.2U.1EE/4Q.07ck0AoU1D."),
+			[]byte("password"),
+			"$y$j9T$.2U.1EE/4Q.07ck0AoU1D.$oTWzmC2x.N26ebvBUewT97nMFz0Ppuhjrf1cD6RWPLA",
+			5,
+		},
+		{
+			[]byte("$y$j9T$WZaPV7LSUEKMo34."),
+			[]byte(""),
+			"$y$j9T$WZaPV7LSUEKMo34.$yTRNpH5.toq0WjriO0WJbuI0k.fBX5vowuHQ6QUmQS9",
+			5,
+		},
+		{ // Cost factor 1, without pre-hashing.
+			[]byte("$y$j75$WZaPV7LSUEKMo34.$"),
+			[]byte("password"),
+			"$y$j75$WZaPV7LSUEKMo34.$Gpdq/y8cA8PpexB5szy/H6O9DE6K2nX9g//dT8TT4d5",
+			1,
+		},
+		{
+			[]byte("$y$j85$saltsalt0123456789abcdefghijABC$F9lX.VwK4sYWIOQ.2tMUTtu9m647mZYkagmV/WVBWx5"),
+			[]byte(""),
+			"$y$j85$saltsalt0123456789abcdefghijABC$F9lX.VwK4sYWIOQ.2tMUTtu9m647mZYkagmV/WVBWx5",
+			2,
+		},
+		{
+			[]byte("$y$j7T$WZaPV7LSUEKMo34."),
+			[]byte("password"),
+			"$y$j7T$WZaPV7LSUEKMo34.$7AFaxAM5c1FaA44uxt8bYeoK6H8mzqszJwXEJMG9r1A",
+			3,
+		},
+		{ // p = 2
+			[]byte("$y$j9T..$WZaPV7LSUEKMo34."),
+			[]byte("password"),
+			"$y$j9T..$WZaPV7LSUEKMo34.$GFbn3JaaNF0KvvJLtfhUQ9Kciij1138PycNXu52Kbs2",
+			5,
+		},
+		{ // p = 3, t = 1
+			[]byte("$y$j950/.$WZaPV7LSUEKMo34."),
+			[]byte("password"),
+			"$y$j950/.$WZaPV7LSUEKMo34.$gPKLlJmp8buUDA/0/vBxHMo8RM.DUVWmME1lr0J9pW4",
+			3,
+		},
+		{ // YESCRYPT_WORM, p = 2, t = 1
+			[]byte("$y$/750..$WZaPV7LSUEKMo34."),
+			[]byte("password"),
+			"$y$/750..$WZaPV7LSUEKMo34.$XArpZiWaiY5HxQCiQ3r7xATkNDeu410JTUA/ZsPclw7",
+			1,
+		},
+		{ // Classic scrypt
+			[]byte("$y$.75$WZaPV7LSUEKMo34."),
+			[]byte("password"),
+			"$y$.75$WZaPV7LSUEKMo34.$SyqH7pOtvjUGPfn5MBO9wSjquJwIjV72fKV.Mmvbj9C",
+			1,
+		},
+	}
+
+	for i, d := range data {
+		hash, err := yescrypt.Generate(d.key, d.salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != d.out {
+			t.Errorf("Test %d failed\nExpected: %s, got: %s", i, d.out, hash)
+		}
+
+		cost, err := yescrypt.Cost(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost != d.cost {
+			t.Errorf("Test %d failed\nExpected: %d, got: %d", i, d.cost, cost)
+		}
+	}
+
+	for _, salt := range []string{
+		"$6$saltstring",
+		"$y$j9T",
+		"$y$j9TWZaPV7LSUEKMo34.",
+		"$y$j9T$WZaPV7LSUEKMo34!",
+		"$y$j9T$WZaPV7LSUEKMo3z",  // Bits out of the last byte.
+		"$y$jbT$WZaPV7LSUEKMo34.", // Memory too big.
+		"$y$k9T$WZaPV7LSUEKMo34.", // Flavor not supported.
+	} {
+		if _, err := yescrypt.Generate([]byte("key"), []byte(salt)); err == nil {
+			t.Errorf("%s: expected to report an error", salt)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	c := New()
+	salt := GetSalt()
+	salt.RoundsDefault = RoundsMin
+	c.SetSalt(salt)
+
+	data := [][]byte{
+		[]byte("password"),
+		[]byte("12345"),
+		[]byte("That's amazing! I've got the same combination on my luggage!"),
+		[]byte("And change the combination on my luggage!"),
+		[]byte("         random  spa  c    ing."),
+		[]byte("94ajflkvjzpe8u3&*j1k513KLJ&*()"),
+	}
+	for i, d := range data {
+		hash, err := c.Generate(d, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hash, "$y$j75$") || len(hash) != 7+22+1+43 {
+			t.Errorf("Test %d failed: hash not expected: %s", i, hash)
+		}
+		if err = c.Verify(hash, d); err != nil {
+			t.Errorf("Test %d failed: %s", i, d)
+		}
+		if err = c.Verify(hash, append(d, 'x')); err != crypt.ErrKeyMismatch {
+			t.Errorf("Test %d failed: expected ErrKeyMismatch, got %v", i, err)
+		}
+	}
+
+	if _, err := c.Cost("$1$deadbeef$"); err != common.ErrSaltPrefix {
+		t.Errorf("expected ErrSaltPrefix, got %v", err)
+	}
+}
+
+func TestEncodeParams(t *testing.T) {
+	// Settings got from "crypt_gensalt" of libxcrypt, by cost factor.
+	for cost, prefix := range map[int]string{
+		1: "$y$j75", 2: "$y$j85", 3: "$y$j7T", 5: "$y$j9T", 11: "$y$jFT",
+	} {
+		c := New()
+		salt := GetSalt()
+		salt.RoundsDefault = cost
+		c.SetSalt(salt)
+
+		setting := string(c.(*crypter).generateSalt())
+		if !strings.HasPrefix(setting, prefix+"$") {
+			t.Errorf("cost %d: expected prefix %s, got %s", cost, prefix, setting)
+		}
+		pr, _, _, err := parseSalt(setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encodeParams(pr) != prefix {
+			t.Errorf("cost %d: expected %s, got %s", cost, prefix, encodeParams(pr))
+		}
+	}
+
+	for _, v := range []uint32{0, 47, 48, 559, 560, 16943, 16944, 1 << 30} {
+		s := encode64Uint32(v, 0)
+
+		var got uint32
+		if rest, err := decode64Uint32(&got, s, 0); err != nil || rest != "" || got != v {
+			t.Errorf("%d: decoded %d from %q (%v)", v, got, s, err)
+		}
+	}
+}
+
+func TestNewFromHash(t *testing.T) {
+	hash := "$y$j9T$WZaPV7LSUEKMo34.$yTRNpH5.toq0WjriO0WJbuI0k.fBX5vowuHQ6QUmQS9"
+	if _, ok := crypt.NewFromHash(hash).(*crypter); !ok {
+		t.Errorf("%s: expected to get the yescrypt crypter", hash)
+	}
+}
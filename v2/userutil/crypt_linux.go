@@ -17,129 +17,225 @@
 package userutil
 
 import (
-	"bufio"
 	"errors"
-	"io"
 	"log"
-	"os"
 
 	"github.com/p3ls/osutil/v2/userutil/crypt"
+	_ "github.com/p3ls/osutil/v2/userutil/crypt/bcrypt"
 	_ "github.com/p3ls/osutil/v2/userutil/crypt/md5_crypt"
 	_ "github.com/p3ls/osutil/v2/userutil/crypt/sha256_crypt"
 	_ "github.com/p3ls/osutil/v2/userutil/crypt/sha512_crypt"
-	//_ "github.com/p3ls/osutil/v2/userutil/crypt/bcrypt"
+	_ "github.com/p3ls/osutil/v2/userutil/crypt/yescrypt"
 )
 
 const lockChar = '!' // Character added at the beginning of the passwd to lock it.
 
-var ErrShadowPasswd = errors.New("no found user with shadowed passwd")
+var (
+	ErrShadowPasswd = errors.New("no found user with shadowed passwd")
+	ErrCryptCost    = errors.New("cost out of the range of the crypt function")
+)
 
-// lookupCrypter returns the first crypt function found in shadowed passwd file.
-func lookupCrypter() (crypt.Crypter, error) {
-	f, err := os.Open(fileShadow)
+// lookupCrypt returns the first crypt function found in shadowed passwd file
+// of the root directory.
+func lookupCrypt(r *Root) (crypt.Crypt, error) {
+	lines, err := r.loadRows(&Shadow{})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer f.Close()
-
-	buf := bufio.NewReader(f)
-
-	for {
-		line, _, err := buf.ReadLine()
-		if err != nil {
-			if err == io.EOF {
-				return nil, ErrShadowPasswd
-			}
-			log.Print(err)
-			continue
-		}
 
-		shadow, err := parseShadow(string(line))
+	for _, line := range lines {
+		shadow, err := parseShadow(line)
 		if err != nil {
 			log.Print(err)
 			continue
 		}
-		if shadow.password[0] == '$' {
-			return crypt.NewFromHash(shadow.password), nil
+		if shadow.password != "" && shadow.password[0] == '$' {
+			if c, ok := crypt.FromHash(shadow.password); ok {
+				return c, nil
+			}
 		}
 	}
-	//return nil, ErrShadowPasswd
+	return 0, ErrShadowPasswd
 }
 
 // SetCrypter sets the crypt function to can hash the passwords.
 // The type "crypt.Crypt" comes from package "github.com/p3ls/osutil/v2/user/crypt".
-func SetCrypter(c crypt.Crypt) {
-	loadConfig()
-	config.crypter = crypt.New(c)
+func SetCrypter(c crypt.Crypt) { defaultRoot.SetCrypter(c) }
+
+// SetCrypter sets the crypt function to can hash the passwords in the root
+// directory. It uses the cost set for that function in the configuration.
+func (r *Root) SetCrypter(c crypt.Crypt) {
+	r.loadConfig()
+	r.config.cryptFn = c
+	r.config.cryptErr = nil
+	r.config.crypter = newCrypter(c, 0)
+}
+
+// hashPasswd returns the key hashed with the crypt function of the root
+// directory, using the given cost; 0 is for the cost of the configuration.
+// The cost is the number of rounds in SHA-crypt, its base-2 logarithm in bcrypt,
+// and the cost factor in yescrypt.
+func (r *Root) hashPasswd(key []byte, cost int) (string, error) {
+	r.loadConfig()
+	if r.config.cryptErr != nil {
+		return "", r.config.cryptErr
+	}
+	if cost == 0 {
+		// The rounds are got for every hash.
+		if cost = cryptCost(r.config.cryptFn, &r.config.login); cost == 0 {
+			return r.config.crypter.Generate(key, nil)
+		}
+		return newCrypter(r.config.cryptFn, cost).Generate(key, nil)
+	}
+
+	getSalt, ok := cryptSalts[r.config.cryptFn]
+	if !ok {
+		return "", ErrCryptCost
+	}
+	if salt := getSalt(); cost < salt.RoundsMin || cost > salt.RoundsMax {
+		return "", ErrCryptCost
+	}
+	return newCrypter(r.config.cryptFn, cost).Generate(key, nil)
 }
 
 // Passwd sets a hashed passwd for the actual user.
 // The passwd must be supplied in clear-text.
-func (s *Shadow) Passwd(key []byte) {
-	loadConfig()
-	s.password, _ = config.crypter.Generate(key, nil)
+func (s *Shadow) Passwd(key []byte) { s.passwd(defaultRoot, key, 0) }
+
+// PasswdWithCost sets a hashed passwd for the actual user, using the given cost
+// in the crypt function.
+// The passwd must be supplied in clear-text.
+func (s *Shadow) PasswdWithCost(key []byte, cost int) error {
+	return s.passwd(defaultRoot, key, cost)
+}
+
+func (s *Shadow) passwd(r *Root, key []byte, cost int) error {
+	password, err := r.hashPasswd(key, cost)
+	if err != nil {
+		return err
+	}
+	s.password = password
 	s.setChange()
+	return nil
 }
 
 // Passwd sets a hashed passwd for the actual group.
 // The passwd must be supplied in clear-text.
-func (gs *GShadow) Passwd(key []byte) {
-	loadConfig()
-	gs.password, _ = config.crypter.Generate(key, nil)
+func (gs *GShadow) Passwd(key []byte) { gs.passwd(defaultRoot, key, 0) }
+
+// PasswdWithCost sets a hashed passwd for the actual group, using the given
+// cost in the crypt function.
+// The passwd must be supplied in clear-text.
+func (gs *GShadow) PasswdWithCost(key []byte, cost int) error {
+	return gs.passwd(defaultRoot, key, cost)
+}
+
+func (gs *GShadow) passwd(r *Root, key []byte, cost int) error {
+	password, err := r.hashPasswd(key, cost)
+	if err != nil {
+		return err
+	}
+	gs.password = password
+	return nil
 }
 
 // == Change passwd
 
 // ChPasswd updates passwd.
 // The passwd must be supplied in clear-text.
-func ChPasswd(user string, key []byte) error {
-	shadow, err := LookupShadow(user)
+func ChPasswd(user string, key []byte) error { return defaultRoot.ChPasswd(user, key) }
+
+// ChPasswd updates passwd in the root directory.
+// The passwd must be supplied in clear-text.
+func (r *Root) ChPasswd(user string, key []byte) error {
+	return r.ChPasswdWithCost(user, key, 0)
+}
+
+// ChPasswdWithCost updates passwd, using the given cost in the crypt function.
+// The passwd must be supplied in clear-text.
+func ChPasswdWithCost(user string, key []byte, cost int) error {
+	return defaultRoot.ChPasswdWithCost(user, key, cost)
+}
+
+// ChPasswdWithCost updates passwd in the root directory, using the given cost
+// in the crypt function.
+// The passwd must be supplied in clear-text.
+func (r *Root) ChPasswdWithCost(user string, key []byte, cost int) error {
+	shadow, err := r.LookupShadow(user)
 	if err != nil {
 		return err
 	}
-	shadow.Passwd(key)
+	if err = shadow.passwd(r, key, cost); err != nil {
+		return err
+	}
 
-	return edit(user, shadow)
+	return edit(r, "ChPasswd", user, shadow)
 }
 
 // ChGPasswd updates group passwd.
 // The passwd must be supplied in clear-text.
-func ChGPasswd(group string, key []byte) error {
-	gshadow, err := LookupGShadow(group)
+func ChGPasswd(group string, key []byte) error { return defaultRoot.ChGPasswd(group, key) }
+
+// ChGPasswd updates group passwd in the root directory.
+// The passwd must be supplied in clear-text.
+func (r *Root) ChGPasswd(group string, key []byte) error {
+	return r.ChGPasswdWithCost(group, key, 0)
+}
+
+// ChGPasswdWithCost updates group passwd, using the given cost in the crypt
+// function.
+// The passwd must be supplied in clear-text.
+func ChGPasswdWithCost(group string, key []byte, cost int) error {
+	return defaultRoot.ChGPasswdWithCost(group, key, cost)
+}
+
+// ChGPasswdWithCost updates group passwd in the root directory, using the given
+// cost in the crypt function.
+// The passwd must be supplied in clear-text.
+func (r *Root) ChGPasswdWithCost(group string, key []byte, cost int) error {
+	gshadow, err := r.LookupGShadow(group)
 	if err != nil {
 		return err
 	}
-	gshadow.Passwd(key)
+	if err = gshadow.passwd(r, key, cost); err != nil {
+		return err
+	}
 
-	return edit(group, gshadow)
+	return edit(r, "ChGPasswd", group, gshadow)
 }
 
 // == Locking
 
 // LockUser locks the passwd of the given user.
-func LockUser(name string) error {
-	shadow, err := LookupShadow(name)
+func LockUser(name string) error { return defaultRoot.LockUser(name) }
+
+// LockUser locks the passwd of the given user in the root directory.
+func (r *Root) LockUser(name string) error {
+	shadow, err := r.LookupShadow(name)
 	if err != nil {
 		return err
 	}
 
 	if shadow.password[0] != lockChar {
 		shadow.password = string(lockChar) + shadow.password
-		return edit(name, shadow)
+		return edit(r, "LockUser", name, shadow)
 	}
 	return nil
 }
 
 // UnlockUser unlocks the passwd of the given user.
-func UnlockUser(name string) error {
-	shadow, err := LookupShadow(name)
+func UnlockUser(name string) error { return defaultRoot.UnlockUser(name) }
+
+// UnlockUser unlocks the passwd of the given user in the root directory.
+func (r *Root) UnlockUser(name string) error {
+	shadow, err := r.LookupShadow(name)
 	if err != nil {
 		return err
 	}
 
 	if shadow.password[0] == lockChar {
 		shadow.password = shadow.password[1:]
-		return edit(name, shadow)
+		return edit(r, "UnlockUser", name, shadow)
 	}
 	return nil
 }
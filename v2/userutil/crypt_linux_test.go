@@ -6,11 +6,218 @@
 
 package userutil
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+)
 
 func TestLookupCrypter(t *testing.T) {
-	_, err := lookupCrypter()
+	_, err := lookupCrypt(defaultRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	r := newTestRoot(t)
+
+	err := r.SetLoginDefs(&LoginDefs{EncryptMethod: "bcrypt", BcryptMinRounds: 5,
+		BcryptMaxRounds: 6}, LD_ENCRYPT_METHOD|LD_BCRYPT_ROUNDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := r.LookupShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$2b$05$") && !strings.HasPrefix(s.password, "$2b$06$") {
+		t.Fatalf("expected a bcrypt hash with rounds 5-6, got %s", s.password)
+	}
+	if err = r.config.crypter.Verify(s.password, []byte("secret")); err != nil {
+		t.Error(err)
+	}
+
+	// The crypt function is got from the hashes, without ENCRYPT_METHOD.
+	if c, err := lookupCrypt(r); err != nil {
+		t.Fatal(err)
+	} else if err = crypt.New(c).Verify(s.password, []byte("secret")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestYescrypt(t *testing.T) {
+	r := newTestRoot(t)
+
+	err := r.SetLoginDefs(&LoginDefs{EncryptMethod: "yescrypt", YescryptCostFactor: 1},
+		LD_ENCRYPT_METHOD|LD_YESCRYPT_COST_FACTOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := r.LookupShadow("daemon")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !strings.HasPrefix(s.password, "$y$j75$") {
+		t.Fatalf("expected a yescrypt hash with cost factor 1, got %s", s.password)
+	}
+	if err = r.config.crypter.Verify(s.password, []byte("secret")); err != nil {
+		t.Error(err)
+	}
+
+	if c, err := lookupCrypt(r); err != nil {
+		t.Fatal(err)
+	} else if err = crypt.New(c).Verify(s.password, []byte("secret")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShaCryptRounds(t *testing.T) {
+	r := newTestRoot(t)
+
+	err := r.SetLoginDefs(&LoginDefs{EncryptMethod: "sha512", ShaCryptMinRounds: 65536,
+		ShaCryptMaxRounds: 65536}, LD_ENCRYPT_METHOD|LD_SHA_CRYPT_ROUNDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := r.LookupShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$rounds=65536$") {
+		t.Fatalf("expected a SHA512 hash with 65536 rounds, got %s", s.password)
+	}
+	if err = r.config.crypter.Verify(s.password, []byte("secret")); err != nil {
+		t.Error(err)
+	}
+
+	// An explicit cost.
+	if err = r.ChGPasswdWithCost("users", []byte("secret"), 100000); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := r.LookupGShadow("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(gs.password, "$6$rounds=100000$") {
+		t.Fatalf("expected a SHA512 hash with 100000 rounds, got %s", gs.password)
+	}
+
+	if err = r.ChPasswdWithCost("daemon", []byte("secret"), 999); err != ErrCryptCost {
+		t.Errorf("expected ErrCryptCost, got %v", err)
+	}
+	r.SetCrypter(crypt.MD5)
+	if err = r.ChPasswdWithCost("daemon", []byte("secret"), 5000); err != ErrCryptCost {
+		t.Errorf("expected ErrCryptCost with a fixed cost, got %v", err)
+	}
+}
+
+func TestLibuserRounds(t *testing.T) {
+	r := newLibuserRoot(t, `[import]
+login_defs = /etc/libuser.defs
+
+[defaults]
+crypt_style = sha256
+hash_rounds_min = 20000
+hash_rounds_max = 20000
+`)
+
+	if err := r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.LookupShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$5$rounds=20000$") {
+		t.Fatalf("expected a SHA256 hash with 20000 rounds, got %s", s.password)
+	}
+}
+
+func TestLibuserCryptStyle(t *testing.T) {
+	// The values not set keep the ones of login.defs.
+	r := newLibuserRoot(t, `[import]
+login_defs = /etc/libuser.defs
+
+[defaults]
+crypt_style =
+`)
+	if err := r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.LookupShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$") {
+		t.Errorf("expected a SHA512 hash from login.defs, got %s", s.password)
+	}
+
+	r = newLibuserRoot(t, `[import]
+login_defs = /etc/libuser.defs
+
+[defaults]
+crypt_style = blowfish
+`)
+	if err = r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = r.LookupShadow("daemon"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$2") {
+		t.Errorf("expected a bcrypt hash, got %s", s.password)
+	}
+
+	r = newLibuserRoot(t, `[import]
+login_defs = /etc/libuser.defs
+
+[defaults]
+crypt_style = des
+`)
+	err = r.ChPasswd("daemon", []byte("secret"))
+	if e, ok := err.(*ConfigError); !ok || e.Key != "crypt_style" {
+		t.Fatalf("expected to report ConfigError, got %v", err)
+	}
+	if _, err = r.AddUsers([]*UserSpec{{Name: USER, UID: -1, Key: []byte("secret")}}); err == nil {
+		t.Error("expected to report ConfigError at adding an user with password")
+	}
+	if _, err = r.AddUser(USER, 100); err != nil {
+		t.Errorf("expected to add an user without password: %s", err)
+	}
+}
+
+func TestCryptRoundsPerHash(t *testing.T) {
+	r := newTestRoot(t)
+
+	err := r.SetLoginDefs(&LoginDefs{EncryptMethod: "sha512", ShaCryptMinRounds: 5000,
+		ShaCryptMaxRounds: 5100}, LD_ENCRYPT_METHOD|LD_SHA_CRYPT_ROUNDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rounds := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		hash, err := r.hashPasswd([]byte("secret"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rounds[strings.SplitN(hash, "$", 4)[2]] = true
+	}
+	if len(rounds) < 2 {
+		t.Errorf("expected a random number of rounds for every hash, got %v", rounds)
+	}
 }
@@ -13,4 +13,7 @@ var (
 	fileGroup   = "/etc/group"
 	fileShadow  = "/etc/shadow"
 	fileGShadow = "/etc/gshadow"
+
+	fileSubUID = "/etc/subuid"
+	fileSubGID = "/etc/subgid"
 )
@@ -0,0 +1,431 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/p3ls/osutil/v2/config/shconf"
+)
+
+// Limits of the rounds of the crypt functions, like in "login.defs(5)".
+const (
+	SHA_ROUNDS_MIN = 1000
+	SHA_ROUNDS_MAX = 999999999
+
+	BCRYPT_ROUNDS_MIN = 4 // Base-2 logarithm of the iterations.
+	BCRYPT_ROUNDS_MAX = 31
+
+	YESCRYPT_COST_MIN = 1
+	YESCRYPT_COST_MAX = 11
+)
+
+// == login.defs
+//
+
+type loginDefsField int
+
+// Fields of LoginDefs, named as the keys of '/etc/login.defs'. The ones of
+// ranges handle both minimum and maximum values.
+const (
+	LD_PASS_MIN_DAYS loginDefsField = 1 << iota
+	LD_PASS_MAX_DAYS
+	LD_PASS_MIN_LEN
+	LD_PASS_WARN_AGE
+	LD_UMASK
+	LD_HOME_MODE
+	LD_SYS_UID
+	LD_SYS_GID
+	LD_UID
+	LD_GID
+	LD_SUB_UID
+	LD_SUB_GID
+	LD_ENCRYPT_METHOD
+	LD_SHA_CRYPT_ROUNDS
+	LD_BCRYPT_ROUNDS
+	LD_YESCRYPT_COST_FACTOR
+)
+
+// A LoginDefs represents the configuration of the shadow utilities, set in the
+// file '/etc/login.defs'.
+type LoginDefs struct {
+	PassMinDays int
+	PassMaxDays int
+	PassMinLen  int
+	PassWarnAge int
+
+	Umask    os.FileMode
+	HomeMode os.FileMode // 0 whether it is not set.
+
+	SysUIDMin, SysUIDMax int
+	SysGIDMin, SysGIDMax int
+	UIDMin, UIDMax       int
+	GIDMin, GIDMax       int
+
+	// The counts are 0 whether the subordinate ids are not allocated to new users.
+	SubUIDMin, SubUIDMax, SubUIDCount int
+	SubGIDMin, SubGIDMax, SubGIDCount int
+
+	EncryptMethod string // Upper case.
+
+	ShaCryptMinRounds, ShaCryptMaxRounds int // 0 whether they are not set.
+	BcryptMinRounds, BcryptMaxRounds     int // 0 whether they are not set.
+	YescryptCostFactor                   int // 0 whether it is not set.
+}
+
+// GetLoginDefs returns the configuration of the shadow utilities used by the
+// running system.
+// See Root.GetLoginDefs.
+func GetLoginDefs() *LoginDefs { return defaultRoot.GetLoginDefs() }
+
+// GetLoginDefs returns the configuration of the shadow utilities used by the
+// root directory.
+//
+// The values are the ones used by this package, so they include the defaults
+// and the values got from '/etc/adduser.conf' or '/etc/libuser.conf', if any.
+func (r *Root) GetLoginDefs() *LoginDefs {
+	r.loadConfig()
+	c := r.config.login
+
+	d := &LoginDefs{
+		PassMinDays: c.PASS_MIN_DAYS,
+		PassMaxDays: c.PASS_MAX_DAYS,
+		PassMinLen:  c.PASS_MIN_LEN,
+		PassWarnAge: c.PASS_WARN_AGE,
+
+		SysUIDMin: c.SYS_UID_MIN,
+		SysUIDMax: c.SYS_UID_MAX,
+		SysGIDMin: c.SYS_GID_MIN,
+		SysGIDMax: c.SYS_GID_MAX,
+		UIDMin:    c.UID_MIN,
+		UIDMax:    c.UID_MAX,
+		GIDMin:    c.GID_MIN,
+		GIDMax:    c.GID_MAX,
+
+		SubUIDMin:   c.SUB_UID_MIN,
+		SubUIDMax:   c.SUB_UID_MAX,
+		SubUIDCount: c.SUB_UID_COUNT,
+		SubGIDMin:   c.SUB_GID_MIN,
+		SubGIDMax:   c.SUB_GID_MAX,
+		SubGIDCount: c.SUB_GID_COUNT,
+
+		EncryptMethod: strings.ToUpper(c.ENCRYPT_METHOD),
+
+		ShaCryptMinRounds: c.SHA_CRYPT_MIN_ROUNDS,
+		ShaCryptMaxRounds: c.SHA_CRYPT_MAX_ROUNDS,
+		BcryptMinRounds:   c.BCRYPT_MIN_ROUNDS,
+		BcryptMaxRounds:   c.BCRYPT_MAX_ROUNDS,
+
+		YescryptCostFactor: c.YESCRYPT_COST_FACTOR,
+	}
+	d.Umask, _ = parseFileMode(c.UMASK)
+	d.HomeMode, _ = parseFileMode(c.HOME_MODE)
+	return d
+}
+
+// SetLoginDefs sets the given fields of the configuration of the shadow
+// utilities in the running system.
+// See Root.SetLoginDefs.
+func SetLoginDefs(d *LoginDefs, fields loginDefsField) error {
+	return defaultRoot.SetLoginDefs(d, fields)
+}
+
+// SetLoginDefs sets the given fields of the configuration of the shadow
+// utilities in the root directory. The values are got from d, they are checked,
+// and then they are written to the file '/etc/login.defs', adding the keys not
+// found. At the end, the configuration is reloaded.
+func (r *Root) SetLoginDefs(d *LoginDefs, fields loginDefsField) error {
+	// The values not changed are used to check the new ones.
+	cur := r.GetLoginDefs()
+	var kv []string // key, value
+
+	if fields&LD_PASS_MIN_DAYS != 0 {
+		if d.PassMinDays < 0 {
+			return &ConfigError{"PASS_MIN_DAYS", "is negative"}
+		}
+		cur.PassMinDays = d.PassMinDays
+		kv = append(kv, "PASS_MIN_DAYS", strconv.Itoa(d.PassMinDays))
+	}
+	if fields&LD_PASS_MAX_DAYS != 0 {
+		if d.PassMaxDays < 1 {
+			return &ConfigError{"PASS_MAX_DAYS", "is lower than 1"}
+		}
+		cur.PassMaxDays = d.PassMaxDays
+		kv = append(kv, "PASS_MAX_DAYS", strconv.Itoa(d.PassMaxDays))
+	}
+	if fields&(LD_PASS_MIN_DAYS|LD_PASS_MAX_DAYS) != 0 && cur.PassMinDays > cur.PassMaxDays {
+		return &ConfigError{"PASS_MIN_DAYS", "is greater than PASS_MAX_DAYS"}
+	}
+	if fields&LD_PASS_MIN_LEN != 0 {
+		if d.PassMinLen < 0 {
+			return &ConfigError{"PASS_MIN_LEN", "is negative"}
+		}
+		kv = append(kv, "PASS_MIN_LEN", strconv.Itoa(d.PassMinLen))
+	}
+	if fields&LD_PASS_WARN_AGE != 0 {
+		if d.PassWarnAge < 0 {
+			return &ConfigError{"PASS_WARN_AGE", "is negative"}
+		}
+		kv = append(kv, "PASS_WARN_AGE", strconv.Itoa(d.PassWarnAge))
+	}
+
+	if fields&LD_UMASK != 0 {
+		if d.Umask&^os.ModePerm != 0 {
+			return &ConfigError{"UMASK", "has bits out of the permissions"}
+		}
+		kv = append(kv, "UMASK", formatFileMode(d.Umask))
+	}
+	if fields&LD_HOME_MODE != 0 {
+		if d.HomeMode&^os.ModePerm != 0 {
+			return &ConfigError{"HOME_MODE", "has bits out of the permissions"}
+		}
+		kv = append(kv, "HOME_MODE", formatFileMode(d.HomeMode))
+	}
+
+	for _, v := range []struct {
+		field          loginDefsField
+		key            string
+		min, max       int
+		curMin, curMax *int
+	}{
+		{LD_SYS_UID, "SYS_UID", d.SysUIDMin, d.SysUIDMax, &cur.SysUIDMin, &cur.SysUIDMax},
+		{LD_SYS_GID, "SYS_GID", d.SysGIDMin, d.SysGIDMax, &cur.SysGIDMin, &cur.SysGIDMax},
+		{LD_UID, "UID", d.UIDMin, d.UIDMax, &cur.UIDMin, &cur.UIDMax},
+		{LD_GID, "GID", d.GIDMin, d.GIDMax, &cur.GIDMin, &cur.GIDMax},
+		{LD_SUB_UID, "SUB_UID", d.SubUIDMin, d.SubUIDMax, &cur.SubUIDMin, &cur.SubUIDMax},
+		{LD_SUB_GID, "SUB_GID", d.SubGIDMin, d.SubGIDMax, &cur.SubGIDMin, &cur.SubGIDMax},
+	} {
+		if fields&v.field == 0 {
+			continue
+		}
+		if v.min < 1 {
+			return &ConfigError{v.key + "_MIN", "is lower than 1"}
+		}
+		if v.max < v.min {
+			return &ConfigError{v.key + "_MAX", "is lower than " + v.key + "_MIN"}
+		}
+		*v.curMin, *v.curMax = v.min, v.max
+		kv = append(kv, v.key+"_MIN", strconv.Itoa(v.min), v.key+"_MAX", strconv.Itoa(v.max))
+	}
+	// The relations are only checked whether some of their fields is set, since
+	// the current values could already break them.
+	if fields&(LD_SYS_UID|LD_UID) != 0 &&
+		cur.SysUIDMax >= cur.UIDMin && cur.UIDMax >= cur.SysUIDMin {
+		return &ConfigError{"SYS_UID_MIN", "the range overlaps with UID_MIN-UID_MAX"}
+	}
+	if fields&(LD_SYS_GID|LD_GID) != 0 &&
+		cur.SysGIDMax >= cur.GIDMin && cur.GIDMax >= cur.SysGIDMin {
+		return &ConfigError{"SYS_GID_MIN", "the range overlaps with GID_MIN-GID_MAX"}
+	}
+
+	for _, v := range []struct {
+		field    loginDefsField
+		key      string
+		count    int
+		min, max int
+	}{
+		{LD_SUB_UID, "SUB_UID_COUNT", d.SubUIDCount, cur.SubUIDMin, cur.SubUIDMax},
+		{LD_SUB_GID, "SUB_GID_COUNT", d.SubGIDCount, cur.SubGIDMin, cur.SubGIDMax},
+	} {
+		if fields&v.field == 0 {
+			continue
+		}
+		if v.count < 0 || v.count > v.max-v.min+1 {
+			return &ConfigError{v.key, "is out of the range"}
+		}
+		kv = append(kv, v.key, strconv.Itoa(v.count))
+	}
+
+	if fields&LD_ENCRYPT_METHOD != 0 {
+		method := strings.ToUpper(d.EncryptMethod)
+		if _, err := cryptFromMethod(method); err != nil {
+			return &ConfigError{"ENCRYPT_METHOD", "method not supported: " + strconv.Quote(d.EncryptMethod)}
+		}
+		kv = append(kv, "ENCRYPT_METHOD", method)
+	}
+	if fields&LD_SHA_CRYPT_ROUNDS != 0 {
+		if d.ShaCryptMinRounds < SHA_ROUNDS_MIN || d.ShaCryptMaxRounds > SHA_ROUNDS_MAX {
+			return &ConfigError{"SHA_CRYPT_MIN_ROUNDS", "rounds are out of the range " +
+				strconv.Itoa(SHA_ROUNDS_MIN) + "-" + strconv.Itoa(SHA_ROUNDS_MAX)}
+		}
+		if d.ShaCryptMaxRounds < d.ShaCryptMinRounds {
+			return &ConfigError{"SHA_CRYPT_MAX_ROUNDS", "is lower than SHA_CRYPT_MIN_ROUNDS"}
+		}
+		kv = append(kv,
+			"SHA_CRYPT_MIN_ROUNDS", strconv.Itoa(d.ShaCryptMinRounds),
+			"SHA_CRYPT_MAX_ROUNDS", strconv.Itoa(d.ShaCryptMaxRounds),
+		)
+	}
+	if fields&LD_BCRYPT_ROUNDS != 0 {
+		if d.BcryptMinRounds < BCRYPT_ROUNDS_MIN || d.BcryptMaxRounds > BCRYPT_ROUNDS_MAX {
+			return &ConfigError{"BCRYPT_MIN_ROUNDS", "rounds are out of the range " +
+				strconv.Itoa(BCRYPT_ROUNDS_MIN) + "-" + strconv.Itoa(BCRYPT_ROUNDS_MAX)}
+		}
+		if d.BcryptMaxRounds < d.BcryptMinRounds {
+			return &ConfigError{"BCRYPT_MAX_ROUNDS", "is lower than BCRYPT_MIN_ROUNDS"}
+		}
+		kv = append(kv,
+			"BCRYPT_MIN_ROUNDS", strconv.Itoa(d.BcryptMinRounds),
+			"BCRYPT_MAX_ROUNDS", strconv.Itoa(d.BcryptMaxRounds),
+		)
+	}
+	if fields&LD_YESCRYPT_COST_FACTOR != 0 {
+		if d.YescryptCostFactor < YESCRYPT_COST_MIN || d.YescryptCostFactor > YESCRYPT_COST_MAX {
+			return &ConfigError{"YESCRYPT_COST_FACTOR", "is out of the range " +
+				strconv.Itoa(YESCRYPT_COST_MIN) + "-" + strconv.Itoa(YESCRYPT_COST_MAX)}
+		}
+		kv = append(kv, "YESCRYPT_COST_FACTOR", strconv.Itoa(d.YescryptCostFactor))
+	}
+
+	return r.setConfValues(fileLogin, "\t", kv)
+}
+
+// == useradd
+//
+
+type useraddField int
+
+// Fields of UseraddDefaults, named as the keys of '/etc/default/useradd'.
+const (
+	UA_HOME useraddField = 1 << iota
+	UA_SHELL
+	UA_SKEL
+)
+
+// An UseraddDefaults represents the default values to add users, set in the
+// file '/etc/default/useradd'.
+type UseraddDefaults struct {
+	Home  string // Base directory for the home directories.
+	Shell string
+	Skel  string // Directory with the files copied into a new home.
+}
+
+// GetUseraddDefaults returns the default values to add users in the running
+// system.
+func GetUseraddDefaults() *UseraddDefaults { return defaultRoot.GetUseraddDefaults() }
+
+// GetUseraddDefaults returns the default values to add users in the root
+// directory.
+func (r *Root) GetUseraddDefaults() *UseraddDefaults {
+	r.loadConfig()
+	c := r.config.useradd
+
+	return &UseraddDefaults{Home: c.HOME, Shell: c.SHELL, Skel: c.SKEL}
+}
+
+// SetUseraddDefaults sets the given fields of the default values to add users
+// in the running system.
+// See Root.SetUseraddDefaults.
+func SetUseraddDefaults(d *UseraddDefaults, fields useraddField) error {
+	return defaultRoot.SetUseraddDefaults(d, fields)
+}
+
+// SetUseraddDefaults sets the given fields of the default values to add users
+// in the root directory. The values are got from d, they have to be absolute
+// paths, and they are written to the file '/etc/default/useradd'. At the end,
+// the configuration is reloaded.
+func (r *Root) SetUseraddDefaults(d *UseraddDefaults, fields useraddField) error {
+	var kv []string // key, value
+
+	for _, v := range []struct {
+		field useraddField
+		key   string
+		value string
+	}{
+		{UA_HOME, "HOME", d.Home},
+		{UA_SHELL, "SHELL", d.Shell},
+		{UA_SKEL, "SKEL", d.Skel},
+	} {
+		if fields&v.field == 0 {
+			continue
+		}
+		if !filepath.IsAbs(v.value) {
+			return &ConfigError{v.key, "is not an absolute path: " + strconv.Quote(v.value)}
+		}
+		kv = append(kv, v.key, filepath.Clean(v.value))
+	}
+
+	return r.setConfValues(fileUseradd, "=", kv)
+}
+
+// == Utility
+//
+
+// setConfValues writes the pairs of key and value into the named configuration
+// file in the root directory, sends the change to the audit sink, and reloads
+// the configuration.
+// The keys not found are added at the end of the file, using the separator sep
+// whether the file has not entries.
+//
+// The file is locked like the databases, and it is replaced atomically through
+// a temporary file (see shconf.Config.SetValues).
+func (r *Root) setConfValues(name, sep string, kv []string) (err error) {
+	if len(kv) == 0 {
+		return nil
+	}
+	filename := r.join(name)
+
+	l, err := r.lockPwd()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := l.unlock(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	if err = l.lockFile(filename); err != nil {
+		return err
+	}
+
+	cfg, err := shconf.ParseFile(filename)
+	if err != nil {
+		return err
+	}
+
+	ev := &AuditEvent{Op: AUDIT_EDIT, File: filename}
+	for i := 0; i < len(kv); i += 2 {
+		old, _ := cfg.Get(kv[i])
+		ev.Fields = append(ev.Fields, AuditField{Name: kv[i], Old: old, New: kv[i+1]})
+	}
+	if err = cfg.SetValues(sep, kv...); err != nil {
+		return err
+	}
+
+	r.emitAudit([]*AuditEvent{ev})
+	return r.reloadConfig()
+}
+
+// parseFileMode parses a mode in octal.
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	return os.FileMode(mode), err
+}
+
+// formatFileMode formats a mode in octal, with a leading zero.
+func formatFileMode(mode os.FileMode) string {
+	return "0" + strconv.FormatUint(uint64(mode), 8)
+}
+
+// == Errors
+//
+
+// A ConfigError reports a value of the configuration not valid.
+type ConfigError struct {
+	Key    string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration for " + e.Key + ": " + e.Reason
+}
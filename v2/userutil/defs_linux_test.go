@@ -0,0 +1,168 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoginDefs(t *testing.T) {
+	r := newTestRoot(t)
+
+	d := r.GetLoginDefs()
+	if d.UIDMin != 1000 || d.UIDMax != 60000 || d.SysUIDMin != 100 || d.PassMaxDays != 99999 {
+		t.Errorf("values not expected: %+v", d)
+	}
+	if d.EncryptMethod != "SHA512" || d.Umask != 022 {
+		t.Errorf("values not expected: %+v", d)
+	}
+
+	for _, v := range []struct {
+		d      LoginDefs
+		fields loginDefsField
+	}{
+		{LoginDefs{UIDMin: 500, UIDMax: 60000}, LD_UID},
+		{LoginDefs{GIDMin: 2000, GIDMax: 1000}, LD_GID},
+		{LoginDefs{PassMinDays: 100, PassMaxDays: 50}, LD_PASS_MIN_DAYS | LD_PASS_MAX_DAYS},
+		{LoginDefs{EncryptMethod: "rot13"}, LD_ENCRYPT_METHOD},
+		{LoginDefs{Umask: 01022}, LD_UMASK},
+		{LoginDefs{ShaCryptMinRounds: 10, ShaCryptMaxRounds: 5000}, LD_SHA_CRYPT_ROUNDS},
+		{LoginDefs{BcryptMinRounds: 10, BcryptMaxRounds: 32}, LD_BCRYPT_ROUNDS},
+		{LoginDefs{YescryptCostFactor: 12}, LD_YESCRYPT_COST_FACTOR},
+	} {
+		err := r.SetLoginDefs(&v.d, v.fields)
+		if _, ok := err.(*ConfigError); !ok {
+			t.Errorf("%+v: expected to report ConfigError, got %v", v.d, err)
+		}
+	}
+
+	err := r.SetLoginDefs(&LoginDefs{
+		UIDMin:            2000,
+		UIDMax:            50000,
+		PassMaxDays:       90,
+		EncryptMethod:     "sha256",
+		Umask:             077,
+		ShaCryptMinRounds: 5000,
+		ShaCryptMaxRounds: 10000,
+	}, LD_UID|LD_PASS_MAX_DAYS|LD_ENCRYPT_METHOD|LD_UMASK|LD_SHA_CRYPT_ROUNDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = r.GetLoginDefs()
+	if d.UIDMin != 2000 || d.UIDMax != 50000 || d.PassMaxDays != 90 || d.Umask != 077 ||
+		d.EncryptMethod != "SHA256" || d.ShaCryptMinRounds != 5000 {
+		t.Errorf("values not expected: %+v", d)
+	}
+	if d.GIDMin != 1000 || d.SysUIDMin != 100 {
+		t.Errorf("expected to keep the rest of values: %+v", d)
+	}
+
+	data, err := os.ReadFile(r.join(fileLogin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"UID_MIN\t2000\n", "ENCRYPT_METHOD\tSHA256\n", "UMASK\t077\n",
+		"SHA_CRYPT_MAX_ROUNDS\t10000\n"} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("expected to write %q, got:\n%s", s, data)
+		}
+	}
+
+	// The ranges which already overlap are only checked whether some of them is
+	// set.
+	data = append(data, "SYS_UID_MAX\t2500\n"...)
+	if err = os.WriteFile(r.join(fileLogin), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	err = r.SetLoginDefs(&LoginDefs{SubUIDMin: 200000, SubUIDMax: 300000, SubUIDCount: 65536},
+		LD_SUB_UID)
+	if err != nil {
+		t.Fatalf("expected to set a field not related, got %v", err)
+	}
+	if err = r.SetLoginDefs(&LoginDefs{UIDMin: 2000, UIDMax: 40000}, LD_UID); err == nil {
+		t.Error("expected to report the ranges overlapped")
+	}
+}
+
+func TestUseraddDefaults(t *testing.T) {
+	r := newTestRoot(t)
+
+	d := r.GetUseraddDefaults()
+	if d.Home != "/home" || d.Shell != "/bin/sh" || d.Skel != "/etc/skel" {
+		t.Errorf("values not expected: %+v", d)
+	}
+
+	if err := r.SetUseraddDefaults(&UseraddDefaults{Shell: "bash"}, UA_SHELL); err == nil {
+		t.Error("expected to report ConfigError")
+	}
+	err := r.SetUseraddDefaults(&UseraddDefaults{Home: "/srv/home/", Shell: "/bin/bash",
+		Skel: "/etc/skel2"}, UA_HOME|UA_SHELL|UA_SKEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = r.GetUseraddDefaults()
+	if d.Home != "/srv/home" || d.Shell != "/bin/bash" || d.Skel != "/etc/skel2" {
+		t.Errorf("values not expected: %+v", d)
+	}
+	data, err := os.ReadFile(r.join(fileUseradd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "HOME=/srv/home\nSHELL=/bin/bash\nSKEL=/etc/skel2\n" {
+		t.Errorf("content not expected:\n%s", data)
+	}
+
+	// The values are written as they are, replacing the file.
+	if err = r.SetUseraddDefaults(&UseraddDefaults{Skel: "/etc/skel$1"}, UA_SKEL); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(r.join(fileUseradd)); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "HOME=/srv/home\nSHELL=/bin/bash\nSKEL=/etc/skel$1\n" {
+		t.Errorf("content not expected:\n%s", data)
+	}
+	files, err := filepath.Glob(r.join(fileUseradd) + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected to remove the temporary and lock files, got %v", files)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	r := newTestRoot(t)
+
+	if reloaded, err := r.ReloadConfig(); err != nil || reloaded {
+		t.Fatalf("expected to not reload, got %v (%v)", reloaded, err)
+	}
+
+	data, err := os.ReadFile(r.join(fileLogin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, "PASS_MIN_LEN 12\n"...)
+	if err = os.WriteFile(r.join(fileLogin), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, err := r.ReloadConfig(); err != nil || !reloaded {
+		t.Fatalf("expected to reload, got %v (%v)", reloaded, err)
+	}
+	if n := r.GetLoginDefs().PassMinLen; n != 12 {
+		t.Errorf("expected PASS_MIN_LEN 12, got %d", n)
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Directories where are looked for the resources of an user.
+const (
+	dirProc = "/proc"
+)
+
+var (
+	// Crontabs of users, in systems based in Debian and in Red Hat.
+	dirsCrontab = []string{"/var/spool/cron/crontabs", "/var/spool/cron"}
+
+	// Jobs of "at(1)", in systems based in Debian and in Red Hat.
+	dirsAtJobs = []string{"/var/spool/cron/atjobs", "/var/spool/at"}
+)
+
+// A DelReport represents the resources used by an user, which could be lost or
+// left orphaned at removing her account.
+type DelReport struct {
+	Name string
+	UID  int
+
+	Procs     []int    // Processes owned by the user.
+	Crontab   string   // Crontab file, if any.
+	AtJobs    []string // Jobs files of "at(1)".
+	MailSpool string   // Mail spool, if any.
+	Files     []string // Files owned by the user under the paths given.
+}
+
+// IsBusy reports whether the user has processes, scheduled jobs or files.
+// The mail spool is not considered.
+func (rep *DelReport) IsBusy() bool {
+	return len(rep.Procs) != 0 || rep.Crontab != "" || len(rep.AtJobs) != 0 ||
+		len(rep.Files) != 0
+}
+
+// UserReport returns the resources used by an user; the files owned by the user
+// are only looked for under the given paths.
+func UserReport(name string, paths ...string) (*DelReport, error) {
+	return defaultRoot.UserReport(name, paths...)
+}
+
+// UserReport returns the resources used by an user in the root directory.
+// The processes are looked for in the directory '/proc' under the root.
+func (r *Root) UserReport(name string, paths ...string) (*DelReport, error) {
+	u, err := r.LookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.userReport(u, paths)
+}
+
+func (r *Root) userReport(u *User, paths []string) (rep *DelReport, err error) {
+	rep = &DelReport{Name: u.Name, UID: u.UID}
+
+	if rep.Procs, err = r.userProcs(u.UID); err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirsCrontab {
+		file := r.join(filepath.Join(dir, u.Name))
+
+		if info, err := os.Lstat(file); err == nil && info.Mode().IsRegular() {
+			rep.Crontab = file
+			break
+		}
+	}
+
+	for _, dir := range dirsAtJobs {
+		files, err := ownedFiles(r.join(dir), u.UID, false)
+		if err != nil {
+			return nil, err
+		}
+		rep.AtJobs = append(rep.AtJobs, files...)
+	}
+
+	spool := r.join(filepath.Join(dirMail, u.Name))
+	if found, err := exist(spool); err != nil {
+		return nil, err
+	} else if found {
+		rep.MailSpool = spool
+	}
+
+	for _, p := range paths {
+		files, err := ownedFiles(r.join(p), u.UID, true)
+		if err != nil {
+			return nil, err
+		}
+		rep.Files = append(rep.Files, files...)
+	}
+	return rep, nil
+}
+
+// userProcs returns the processes whose real or effective user id is uid.
+func (r *Root) userProcs(uid int) ([]int, error) {
+	dir, err := os.Open(r.join(dirProc))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []int
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		ruid, euid, err := procUIDs(filepath.Join(r.join(dirProc), name, "status"))
+		if err != nil {
+			// The process could have finished.
+			continue
+		}
+		if ruid == uid || euid == uid {
+			procs = append(procs, pid)
+		}
+	}
+	return procs, nil
+}
+
+// procUIDs returns the real and effective user ids from the status file of a
+// process.
+func procUIDs(status string) (ruid, euid int, err error) {
+	f, err := os.Open(status)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+
+		fields := strings.Fields(line[len("Uid:"):])
+		if len(fields) < 2 {
+			break
+		}
+		if ruid, err = strconv.Atoi(fields[0]); err != nil {
+			return 0, 0, err
+		}
+		if euid, err = strconv.Atoi(fields[1]); err != nil {
+			return 0, 0, err
+		}
+		return ruid, euid, nil
+	}
+	if err = scan.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, rowError{status, "Uid"}
+}
+
+// ownedFiles returns the files under the directory dir owned by uid.
+// If recursive is false, it is only read the first level.
+func ownedFiles(dir string, uid int, recursive bool) ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path != dir && !recursive {
+			return filepath.SkipDir
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid {
+			if recursive || path != dir {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// == Removing
+//
+
+// A DelPolicy sets how to handle the resources of an user at removing it, with
+// the options DEL_REFUSE, DEL_KILL and DEL_CHOWN.
+type DelPolicy struct {
+	// Paths where to look for files owned by the user.
+	Paths []string
+
+	// New owner for the files, with DEL_CHOWN. A GID < 0 keeps the group.
+	UID, GID int
+
+	// Time to wait for the processes to finish after of SIGTERM, before of
+	// sending SIGKILL. By default, it is 5 seconds.
+	KillTimeout time.Duration
+}
+
+// DelUserSafe removes an user from the system, handling her resources according
+// to the options and the policy, which could be nil.
+// See Root.DelUserSafe.
+func DelUserSafe(name string, opt DelOption, p *DelPolicy) (*DelReport, error) {
+	return defaultRoot.DelUserSafe(name, opt, p)
+}
+
+// DelUserSafe removes an user from the root directory, handling her resources
+// according to the options and the policy, which could be nil.
+// Whether some option of DEL_REFUSE, DEL_KILL or DEL_CHOWN is used, it returns
+// the report of the resources used by the user, before of removing it.
+//
+// With DEL_REFUSE, nothing is done whether the user is busy, and it is reported
+// UserBusyError. With DEL_KILL, her processes are terminated. With DEL_CHOWN,
+// the files found into the paths of the policy are reassigned to the new owner.
+// With DEL_HOME, the home directory is checked like in DelUserWith.
+//
+// The report is built and the processes are terminated before of locking the
+// databases; the files are reassigned and the home is removed after of
+// committing. Every process terminated, file reassigned and directory removed
+// is sent as an event to the audit sink.
+func (r *Root) DelUserSafe(name string, opt DelOption, p *DelPolicy) (*DelReport, error) {
+	return r.delUserSafe("DelUserSafe", name, opt, p)
+}
+
+// delUserSafe removes the user, sending the events of the action which removed
+// it, and of every resource handled.
+func (r *Root) delUserSafe(action, name string, opt DelOption, p *DelPolicy) (rep *DelReport, err error) {
+	if p == nil {
+		p = &DelPolicy{GID: -1}
+	}
+
+	// The resources are handled before of locking the databases, since it
+	// could take long.
+	u, err := r.LookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt&(DEL_REFUSE|DEL_KILL|DEL_CHOWN) != 0 {
+		if rep, err = r.userReport(u, p.Paths); err != nil {
+			return nil, err
+		}
+		if opt&DEL_REFUSE != 0 && rep.IsBusy() {
+			return rep, UserBusyError{rep}
+		}
+	}
+	if opt&DEL_KILL != 0 && len(rep.Procs) != 0 {
+		if err = r.killProcs(action, u.Name, rep.Procs, p.KillTimeout); err != nil {
+			return rep, err
+		}
+	}
+
+	tx := r.beginAction(action)
+	if u, err = tx.LookupUser(name); err != nil {
+		tx.Rollback()
+		return rep, err
+	}
+	if opt&DEL_HOME != 0 {
+		if err = tx.checkSharedHome(u); err == nil {
+			err = r.checkHomeOwner(u)
+		}
+		if err != nil {
+			tx.Rollback()
+			return rep, err
+		}
+	}
+	if err = tx.DelUser(name); err != nil {
+		tx.Rollback()
+		return rep, err
+	}
+	if err = tx.Commit(); err != nil {
+		return rep, err
+	}
+
+	// The files are only modified once the user is removed.
+	if opt&DEL_CHOWN != 0 {
+		for _, file := range rep.Files {
+			info, err := os.Lstat(file)
+			if err == nil {
+				err = os.Lchown(file, p.UID, p.GID)
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return rep, err
+			}
+
+			fields := []AuditField{{"UID", strconv.Itoa(u.UID), strconv.Itoa(p.UID)}}
+			if st, ok := info.Sys().(*syscall.Stat_t); ok && p.GID >= 0 {
+				fields = append(fields,
+					AuditField{"GID", strconv.Itoa(int(st.Gid)), strconv.Itoa(p.GID)})
+			}
+			r.emitEffect(action, AUDIT_CHOWN, file, u.Name, fields...)
+		}
+	}
+	if opt&DEL_HOME != 0 {
+		home := r.join(u.Dir)
+		if err = os.RemoveAll(home); err != nil {
+			return rep, err
+		}
+		r.emitEffect(action, AUDIT_REMOVE_HOME, home, u.Name)
+
+		spool := r.join(filepath.Join(dirMail, u.Name))
+		if err = os.Remove(spool); err == nil {
+			r.emitEffect(action, AUDIT_REMOVE_HOME, spool, u.Name)
+		} else if !os.IsNotExist(err) {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// killProcs sends SIGTERM to the processes of the user, and SIGKILL to the ones
+// that have not finished after of the timeout. It is sent an event by every
+// process signaled.
+func (r *Root) killProcs(action, name string, procs []int, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	procs = append([]int(nil), procs...)
+
+	for _, pid := range procs {
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			if err == syscall.ESRCH {
+				continue
+			}
+			return err
+		}
+		r.emitEffect(action, AUDIT_KILL, "", name, AuditField{"PID", strconv.Itoa(pid), ""})
+	}
+
+	for deadline := time.Now().Add(timeout); ; {
+		alive := procs[:0]
+		for _, pid := range procs {
+			if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+				alive = append(alive, pid)
+			}
+		}
+		if procs = alive; len(procs) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(lockRetry)
+	}
+
+	for _, pid := range procs {
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// == Errors
+//
+
+// An UserBusyError reports that an user can not be removed since there are
+// resources in use.
+type UserBusyError struct {
+	Report *DelReport
+}
+
+func (e UserBusyError) Error() string {
+	rep := e.Report
+	return "user " + strconv.Quote(rep.Name) + " is busy: " +
+		strconv.Itoa(len(rep.Procs)) + " processes, " +
+		strconv.Itoa(len(rep.AtJobs)) + " at jobs, " +
+		strconv.Itoa(len(rep.Files)) + " files, crontab: " +
+		strconv.FormatBool(rep.Crontab != "")
+}
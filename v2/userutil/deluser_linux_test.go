@@ -0,0 +1,153 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDelUserSafe(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("it requires to be root")
+	}
+	r := newTestRoot(t)
+
+	uid, err := r.AddUser(USER, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Process of the user, published into the fake '/proc'.
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: 100},
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	defer cmd.Process.Kill()
+
+	procDir := r.join(filepath.Join(dirProc, strconv.Itoa(cmd.Process.Pid)))
+	if err = os.MkdirAll(procDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	status := fmt.Sprintf("Name:\tsleep\nUid:\t%d\t%d\t%d\t%d\n", uid, uid, uid, uid)
+	if err = os.WriteFile(filepath.Join(procDir, "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	crontab := r.join(filepath.Join(dirsCrontab[0], USER))
+	if err = os.MkdirAll(filepath.Dir(crontab), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(crontab, []byte("* * * * * true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := r.join("/srv/data")
+	if err = os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(data, "file")
+	if err = os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chown(file, uid, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := r.UserReport(USER, "/srv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Procs) != 1 || rep.Procs[0] != cmd.Process.Pid {
+		t.Errorf("expected to find the process, got %v", rep.Procs)
+	}
+	if rep.Crontab != crontab {
+		t.Errorf("expected to find the crontab, got %q", rep.Crontab)
+	}
+	if len(rep.Files) != 1 || rep.Files[0] != file {
+		t.Errorf("expected to find the file owned, got %v", rep.Files)
+	}
+
+	policy := &DelPolicy{Paths: []string{"/srv"}, UID: 0, GID: -1, KillTimeout: time.Second}
+
+	_, err = r.DelUserSafe(USER, DEL_REFUSE, policy)
+	if _, ok := err.(UserBusyError); !ok {
+		t.Fatalf("expected to report UserBusyError, got %v", err)
+	}
+	if _, err = r.LookupUser(USER); err != nil {
+		t.Fatal("expected to keep the user")
+	}
+
+	// The files are not reassigned whether the user is not removed.
+	if _, err = r.AddUser(USER2, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.ModifyUser(USER2, &User{Dir: "/home/" + USER}, U_DIR, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.DelUserSafe(USER, DEL_CHOWN|DEL_HOME, policy)
+	if _, ok := err.(SharedHomeError); !ok {
+		t.Fatalf("expected to report SharedHomeError, got %v", err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); int(st.Uid) != uid {
+		t.Errorf("expected to keep the owner of the file, got %d", st.Uid)
+	}
+	if err = r.DelUser(USER2); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*AuditEvent
+	r.SetAuditSink(AuditFunc(func(ev *AuditEvent) { events = append(events, ev) }))
+
+	if _, err = r.DelUserSafe(USER, DEL_KILL|DEL_CHOWN, policy); err != nil {
+		t.Fatal(err)
+	}
+	ops := make(map[AuditOp]*AuditEvent)
+	for _, ev := range events {
+		if ev.Action != "DelUserSafe" {
+			t.Errorf("action not expected: %+v", ev)
+		}
+		ops[ev.Op] = ev
+	}
+	if ev := ops[AUDIT_KILL]; ev == nil || ev.Fields[0].Old != strconv.Itoa(cmd.Process.Pid) {
+		t.Errorf("expected the event of the process killed, got %+v", ev)
+	}
+	if ev := ops[AUDIT_CHOWN]; ev == nil || ev.File != file || ev.Fields[0].New != "0" {
+		t.Errorf("expected the event of the file reassigned, got %+v", ev)
+	}
+	if _, err = r.LookupUser(USER); err == nil {
+		t.Error("expected to remove the user")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("expected to terminate the process")
+	}
+
+	if info, err = os.Stat(file); err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); st.Uid != 0 || st.Gid != 100 {
+		t.Errorf("expected to reassign the file to 0:100, got %d:%d", st.Uid, st.Gid)
+	}
+}
@@ -8,7 +8,17 @@
 
 You must have enough privileges to access to databases in shadowed files
 '/etc/shadow' and '/etc/gshadow'. This usually means have to be root.
-Note: those files are backed-up before of be modified.
+Note: those files are backed-up before of be modified, and they are locked
+like it is done by the shadow utilities (see "lckpwdf(3)").
+
+The functions at package level handle the databases of the running system.
+To handle the ones of a system mounted in another directory, i.e. an image,
+there is to use the methods of type Root, got from NewRoot.
+
+The databases can also be handled by another Backend, through NewRootWith:
+i.e. one in memory (NewMemBackend) to test programs without privileges, or
+one which falls back to the Name Service Switch (NewNSSBackend) to see the
+accounts of LDAP or SSSD.
 
 In testing, to print the configuration read from the system, there is to use
 "-v" flag.
@@ -43,6 +43,16 @@ func (e NoFoundError) Error() string {
 		e.value, e.file, e.field)
 }
 
+// A FieldError reports a value which can not be stored in a field.
+type FieldError struct {
+	Field string
+	Value interface{}
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("value not valid in field %s: %q", e.Field, fmt.Sprint(e.Value))
+}
+
 // A RequiredError reports the name of a required field.
 type RequiredError string
 
@@ -8,7 +8,6 @@ package userutil
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"io"
 	"os"
@@ -35,18 +34,31 @@ type field interface {
 
 var errSearch = errors.New("no search")
 
-// lookUp is a generic parser to looking for a value.
+// lookUp is a generic parser to looking for a value in the file of the row,
+// into the root directory.
 //
 // The count determines the number of fields to return:
 //   n > 0: at most n fields
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
-func lookUp(_row row, _field field, value interface{}, n int) (interface{}, error) {
+func lookUp(r *Root, _row row, _field field, value interface{}, n int) (interface{}, error) {
 	if n == 0 {
 		return nil, errSearch
 	}
+	filename := r.join(_row.filename())
 
-	dbf, err := openDBFile(_row.filename(), os.O_RDONLY)
+	if r.backend != nil {
+		entries, err := lookUpRows(r.backend, _row, _field, value, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 0 {
+			return entries, nil
+		}
+		return nil, NoFoundError{filename, _field.String(), value}
+	}
+
+	dbf, err := openDBFile(filename, os.O_RDONLY)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +88,7 @@ func lookUp(_row row, _field field, value interface{}, n int) (interface{}, erro
 	if len(entries) != 0 {
 		return entries, nil
 	}
-	return nil, NoFoundError{_row.filename(), _field.String(), value}
+	return nil, NoFoundError{filename, _field.String(), value}
 }
 
 // == Editing
@@ -126,69 +138,25 @@ func backup(filename string) error {
 	return nil
 }
 
-func edit(name string, r row) error { return _edit(name, r, false) }
+func edit(r *Root, action, name string, _row row) error {
+	return _edit(r, action, name, _row, false)
+}
 
-func del(name string, r row) error { return _edit(name, r, true) }
+func del(r *Root, action, name string, _row row) error {
+	return _edit(r, action, name, _row, true)
+}
 
-// _edit is a generic editor for the given user/group name.
+// _edit is a generic editor for the given user/group name, into the file of the
+// row in the root directory.
 // If remove is true, it removes the structure of the user/group name.
 //
-// TODO: get better performance if start to store since when the file is edited.
-// So there is to store the size of all lines read until that point to seek from
-// there.
-func _edit(name string, _row row, remove bool) (err error) {
-	filename := _row.filename()
+// The file is replaced through a transaction (see Tx) of the named function.
+func _edit(r *Root, action, name string, _row row, remove bool) error {
+	tx := r.beginAction(action)
 
-	dbf, err := openDBFile(filename, os.O_RDWR)
-	if err != nil {
+	if err := tx._edit(name, _row, remove); err != nil {
+		tx.Rollback()
 		return err
 	}
-	defer func() {
-		e := dbf.close()
-		if e != nil && err == nil {
-			err = e
-		}
-	}()
-
-	var buf bytes.Buffer
-	name_b := []byte(name)
-	isFound := false
-
-	for {
-		line, err2 := dbf.rd.ReadBytes('\n')
-		if err2 == io.EOF {
-			break
-		}
-
-		if !isFound && bytes.HasPrefix(line, name_b) {
-			isFound = true
-			if remove { // skip user
-				continue
-			}
-
-			line = []byte(_row.String())
-		}
-		if _, err = buf.Write(line); err != nil {
-			return err
-		}
-	}
-
-	if isFound {
-		if err = backup(filename); err != nil {
-			return
-		}
-
-		if _, err = dbf.file.Seek(0, io.SeekStart); err != nil {
-			return
-		}
-
-		var n int
-		n, err = dbf.file.Write(buf.Bytes())
-		if err != nil {
-			return
-		}
-		err = dbf.file.Truncate(int64(n))
-	}
-
-	return
+	return tx.Commit()
 }
@@ -9,7 +9,6 @@ package userutil
 import (
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -60,6 +59,7 @@ type Group struct {
 	UserList []string
 
 	addSystemGroup bool
+	idAlloc        *IdAlloc
 }
 
 // NewGroup returns a new Group.
@@ -166,8 +166,14 @@ func (*Group) lookUp(line string, f field, value interface{}) interface{} {
 }
 
 // LookupGID looks up a group by group ID.
-func LookupGID(gid int) (*Group, error) {
-	entries, err := LookupInGroup(G_GID, gid, 1)
+func LookupGID(gid int) (*Group, error) { return defaultRoot.LookupGID(gid) }
+
+// LookupGID looks up a group by group ID in the root directory.
+func (r *Root) LookupGID(gid int) (*Group, error) {
+	if r.cache != nil {
+		return r.cache.LookupGID(gid)
+	}
+	entries, err := r.LookupInGroup(G_GID, gid, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -176,8 +182,14 @@ func LookupGID(gid int) (*Group, error) {
 }
 
 // LookupGroup looks up a group by name.
-func LookupGroup(name string) (*Group, error) {
-	entries, err := LookupInGroup(G_NAME, name, 1)
+func LookupGroup(name string) (*Group, error) { return defaultRoot.LookupGroup(name) }
+
+// LookupGroup looks up a group by name in the root directory.
+func (r *Root) LookupGroup(name string) (*Group, error) {
+	if r.cache != nil {
+		return r.cache.LookupGroup(name)
+	}
+	entries, err := r.LookupInGroup(G_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +204,12 @@ func LookupGroup(name string) (*Group, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInGroup(field groupField, value interface{}, n int) ([]*Group, error) {
-	iEntries, err := lookUp(&Group{}, field, value, n)
+	return defaultRoot.LookupInGroup(field, value, n)
+}
+
+// LookupInGroup looks up a group by the given values in the root directory.
+func (r *Root) LookupInGroup(field groupField, value interface{}, n int) ([]*Group, error) {
+	iEntries, err := lookUp(r, &Group{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -261,100 +278,100 @@ func GetgroupsName() []string {
 // AddGroup adds a group.
 // modGshadow indicates if the file gshadow has been also changed.
 func AddGroup(name string, members ...string) (gid int, err error) {
-	s, err := NewGShadow(name, members...)
-	if !errors.Is(err, ErrGshadow) {
-		if err = s.Add(nil); err != nil {
-			return
-		}
-	}
+	return defaultRoot.AddGroup(name, members...)
+}
 
-	return NewGroup(name, members...).Add()
+// AddGroup adds a group in the root directory.
+func (r *Root) AddGroup(name string, members ...string) (gid int, err error) {
+	return r.addGroup(NewGroup(name, members...))
 }
 
 // AddSystemGroup adds a system group.
 // modGshadow indicates if the file gshadow has been also changed.
 func AddSystemGroup(name string, members ...string) (gid int, err error) {
-	s, err := NewGShadow(name, members...)
+	return defaultRoot.AddSystemGroup(name, members...)
+}
+
+// AddSystemGroup adds a system group in the root directory.
+func (r *Root) AddSystemGroup(name string, members ...string) (gid int, err error) {
+	return r.addGroup(NewSystemGroup(name, members...))
+}
+
+// addGroup adds the group and its shadowed group into a same transaction.
+func (r *Root) addGroup(g *Group) (gid int, err error) {
+	action := "AddGroup"
+	if g.addSystemGroup {
+		action = "AddSystemGroup"
+	}
+	tx := r.beginAction(action)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	s, err := r.NewGShadow(g.Name, g.UserList...)
 	if !errors.Is(err, ErrGshadow) {
-		if err = s.Add(nil); err != nil {
-			return
+		if err = tx.AddGShadow(s, nil); err != nil {
+			return 0, err
 		}
 	}
-
-	return NewSystemGroup(name, members...).Add()
+	if gid, err = tx.AddGroup(g); err != nil {
+		return 0, err
+	}
+	return gid, tx.Commit()
 }
 
 // Add adds a new group.
 // Whether GID is < 0, it will choose the first id available in the range set
 // in the system configuration.
-func (g *Group) Add() (gid int, err error) {
-	loadConfig()
+func (g *Group) Add() (gid int, err error) { return g.AddAt(defaultRoot) }
 
-	group, err := LookupGroup(g.Name)
-	if err != nil {
-		if _, ok := err.(NoFoundError); !ok {
-			return 0, err
-		}
-	}
-	if group != nil {
-		return 0, ErrGroupExist
-	}
+// AddAt adds a new group in the root directory.
+// Whether GID is < 0, it will choose the first id available in the range set
+// in the configuration of the root directory.
+func (g *Group) AddAt(r *Root) (gid int, err error) {
+	tx := r.beginAction("Group.Add")
 
-	if g.Name == "" {
-		return 0, RequiredError("Name")
+	if gid, err = tx.AddGroup(g); err != nil {
+		tx.Rollback()
+		return 0, err
 	}
+	return gid, tx.Commit()
+}
 
-	var db *dbfile
-
-	if g.GID < 0 {
-		db, gid, err = nextGUID(g.addSystemGroup)
-		if err != nil {
-			return 0, err
-		}
-		defer func() {
-			if e := db.close(); e != nil && err == nil {
-				err = e
-			}
-		}()
+// DelGroup removes a group from the system.
+func DelGroup(name string) error { return defaultRoot.DelGroup(name) }
 
-		g.GID = gid
-	} else {
-		db, err = openDBFile(fileGroup, os.O_WRONLY|os.O_APPEND)
-		if err != nil {
-			return 0, err
-		}
-		defer func() {
-			if e := db.close(); e != nil && err == nil {
-				err = e
-			}
-		}()
+// DelGroup removes a group from the root directory.
+func (r *Root) DelGroup(name string) error {
+	tx := r.beginAction("DelGroup")
 
-		// Check if Id is unique.
-		_, err = LookupGID(g.GID)
-		if err == nil {
-			return 0, IdUsedError(g.GID)
-		} else if _, ok := err.(NoFoundError); !ok {
-			return 0, err
-		}
+	if err := tx.DelGroup(name); err != nil {
+		tx.Rollback()
+		return err
 	}
+	return tx.Commit()
+}
 
-	g.password = "x"
-
-	_, err = db.file.WriteString(g.String())
-	return
+// AddUsersToGroup adds the members to a group.
+func AddUsersToGroup(name string, members ...string) error {
+	return defaultRoot.AddUsersToGroup(name, members...)
 }
 
-// DelGroup removes a group from the system.
-func DelGroup(name string) (err error) {
-	err = del(name, &Group{})
-	if err == nil && useGshadow {
-		err = del(name, &GShadow{})
+// AddUsersToGroup adds the members to a group in the root directory.
+func (r *Root) AddUsersToGroup(name string, members ...string) error {
+	tx := r.beginAction("AddUsersToGroup")
+
+	if err := tx.AddUsersToGroup(name, members...); err != nil {
+		tx.Rollback()
+		return err
 	}
-	return
+	return tx.Commit()
 }
 
-// AddUsersToGroup adds the members to a group.
-func AddUsersToGroup(name string, members ...string) error {
+// AddUsersToGroup stages the adding of the members to a group.
+func (tx *Tx) AddUsersToGroup(name string, members ...string) error {
 	if len(members) == 0 {
 		return fmt.Errorf("no members to add")
 	}
@@ -365,27 +382,27 @@ func AddUsersToGroup(name string, members ...string) error {
 	}
 
 	// Group
-	gr, err := LookupGroup(name)
+	gr, err := tx.LookupGroup(name)
 	if err != nil {
 		return err
 	}
 	if err = _addMembers(&gr.UserList, members...); err != nil {
 		return err
 	}
-	if err = edit(name, gr); err != nil {
+	if err = tx.EditGroup(name, gr); err != nil {
 		return err
 	}
 
 	// Shadow group
-	if useGshadow {
-		sg, err := LookupGShadow(name)
+	if tx.r.hasGshadow() {
+		sg, err := tx.LookupGShadow(name)
 		if err != nil {
 			return err
 		}
 		if err = _addMembers(&sg.UserList, members...); err != nil {
 			return err
 		}
-		if err = edit(name, sg); err != nil {
+		if err = tx.EditGShadow(name, sg); err != nil {
 			return err
 		}
 	}
@@ -414,6 +431,23 @@ func _addMembers(userList *[]string, members ...string) error {
 
 // DelUsersInGroup removes the specific members from a group.
 func DelUsersInGroup(name string, members ...string) error {
+	return defaultRoot.DelUsersInGroup(name, members...)
+}
+
+// DelUsersInGroup removes the specific members from a group in the root
+// directory.
+func (r *Root) DelUsersInGroup(name string, members ...string) error {
+	tx := r.beginAction("DelUsersInGroup")
+
+	if err := tx.DelUsersInGroup(name, members...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DelUsersInGroup stages the removing of the specific members from a group.
+func (tx *Tx) DelUsersInGroup(name string, members ...string) error {
 	if len(members) == 0 {
 		return ErrNoMembers
 	}
@@ -424,27 +458,27 @@ func DelUsersInGroup(name string, members ...string) error {
 	}
 
 	// Group
-	gr, err := LookupGroup(name)
+	gr, err := tx.LookupGroup(name)
 	if err != nil {
 		return err
 	}
 	if err = _delMembers(&gr.UserList, members...); err != nil {
 		return err
 	}
-	if err = edit(name, gr); err != nil {
+	if err = tx.EditGroup(name, gr); err != nil {
 		return err
 	}
 
 	// Shadow group
-	if useGshadow {
-		sg, err := LookupGShadow(name)
+	if tx.r.hasGshadow() {
+		sg, err := tx.LookupGShadow(name)
 		if err != nil {
 			return err
 		}
 		if err = _delMembers(&sg.UserList, members...); err != nil {
 			return err
 		}
-		if err = edit(name, sg); err != nil {
+		if err = tx.EditGShadow(name, sg); err != nil {
 			return err
 		}
 	}
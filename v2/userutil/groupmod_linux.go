@@ -0,0 +1,207 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"fmt"
+)
+
+// ModifyGroup changes the given fields of a group, like "groupmod(8)".
+// The values are got from g, for the fields: G_NAME and G_GID.
+//
+// Whether the name is changed, the shadowed group is also updated. Whether the
+// GID is changed, the users whose primary group was the old GID are moved to
+// the new one.
+func ModifyGroup(name string, g *Group, fields groupField) error {
+	return defaultRoot.ModifyGroup(name, g, fields)
+}
+
+// ModifyGroup changes the given fields of a group in the root directory.
+func (r *Root) ModifyGroup(name string, g *Group, fields groupField) error {
+	tx := r.beginAction("ModifyGroup")
+
+	if _, err := tx.ModifyGroup(name, g, fields); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ModifyGroup stages the changes of the given fields of a group.
+// Returns the group before of be modified.
+func (tx *Tx) ModifyGroup(name string, g *Group, fields groupField) (old *Group, err error) {
+	tx.r.loadConfig()
+
+	if old, err = tx.LookupGroup(name); err != nil {
+		return nil, err
+	}
+	newGroup := *old
+
+	if fields&G_NAME != 0 && g.Name != name {
+		if g.Name == "" {
+			return nil, RequiredError("Name")
+		}
+		if err = tx.r.ValidateName(g.Name, old.GID < tx.r.config.login.GID_MIN); err != nil {
+			return nil, err
+		}
+		if _, err = tx.LookupGroup(g.Name); err == nil {
+			return nil, ErrGroupExist
+		} else if _, ok := err.(NoFoundError); !ok {
+			return nil, err
+		}
+		newGroup.Name = g.Name
+	}
+	if fields&G_GID != 0 && g.GID != old.GID {
+		if g.GID < 0 {
+			return nil, FieldError{G_GID.String(), g.GID}
+		}
+		entry, err := tx.lookUp(g, G_GID, g.GID)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			return nil, IdUsedError(g.GID)
+		}
+		newGroup.GID = g.GID
+	}
+
+	if err = tx.EditGroup(name, &newGroup); err != nil {
+		return nil, err
+	}
+
+	if newGroup.Name != name && tx.r.hasGshadow() {
+		gs, err := tx.LookupGShadow(name)
+		if err == nil {
+			gs.Name = newGroup.Name
+			if err = tx.EditGShadow(name, gs); err != nil {
+				return nil, err
+			}
+		} else if _, ok := err.(NoFoundError); !ok {
+			return nil, err
+		}
+	}
+
+	if newGroup.GID != old.GID {
+		f, err := tx.load(&User{})
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range f.lines {
+			u, err := parseUser(line)
+			if err != nil {
+				return nil, err
+			}
+			if u.GID == old.GID {
+				u.GID = newGroup.GID
+				if err = tx.EditUser(u.Name, u); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return old, nil
+}
+
+// == Administrators
+//
+
+// SetGroupAdmins sets the administrators of a group, like "gpasswd -A".
+// With no admins, the list is cleared.
+func SetGroupAdmins(name string, admins ...string) error {
+	return defaultRoot.SetGroupAdmins(name, admins...)
+}
+
+// SetGroupAdmins sets the administrators of a group in the root directory.
+func (r *Root) SetGroupAdmins(name string, admins ...string) error {
+	tx := r.beginAction("SetGroupAdmins")
+
+	if err := tx.SetGroupAdmins(name, admins...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetGroupAdmins stages the list of administrators of a group.
+func (tx *Tx) SetGroupAdmins(name string, admins ...string) error {
+	if err := tx.checkUsers(admins); err != nil {
+		return err
+	}
+
+	gs, err := tx.LookupGShadow(name)
+	if err != nil {
+		return err
+	}
+	gs.AdminList = admins
+	return tx.EditGShadow(name, gs)
+}
+
+// SetGroupMembers sets the members of a group, like "gpasswd -M".
+// With no members, the list is cleared.
+func SetGroupMembers(name string, members ...string) error {
+	return defaultRoot.SetGroupMembers(name, members...)
+}
+
+// SetGroupMembers sets the members of a group in the root directory.
+func (r *Root) SetGroupMembers(name string, members ...string) error {
+	tx := r.beginAction("SetGroupMembers")
+
+	if err := tx.SetGroupMembers(name, members...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetGroupMembers stages the list of members of a group, in both group and
+// gshadow files.
+func (tx *Tx) SetGroupMembers(name string, members ...string) error {
+	if err := tx.checkUsers(members); err != nil {
+		return err
+	}
+
+	gr, err := tx.LookupGroup(name)
+	if err != nil {
+		return err
+	}
+	gr.UserList = members
+	if err = tx.EditGroup(name, gr); err != nil {
+		return err
+	}
+
+	if tx.r.hasGshadow() {
+		gs, err := tx.LookupGShadow(name)
+		if err != nil {
+			return err
+		}
+		gs.UserList = members
+		if err = tx.EditGShadow(name, gs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUsers checks that the users exist, and that they are not repeated.
+func (tx *Tx) checkUsers(names []string) error {
+	seen := make(map[string]bool, len(names))
+
+	for i, v := range names {
+		if v == "" {
+			return EmptyMemberError(fmt.Sprintf("members[%d]", i))
+		}
+		if seen[v] {
+			return fmt.Errorf("user %q is repeated", v)
+		}
+		seen[v] = true
+
+		if _, err := tx.LookupUser(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
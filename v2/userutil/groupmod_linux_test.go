@@ -0,0 +1,87 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import "testing"
+
+func TestModifyGroup(t *testing.T) {
+	r := newTestRoot(t)
+
+	if _, err := r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddUser(USER2, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r.ModifyGroup("users", &Group{Name: "daemon"}, G_NAME)
+	if err != ErrGroupExist {
+		t.Errorf("expected to report ErrGroupExist, got %v", err)
+	}
+	err = r.ModifyGroup("users", &Group{GID: 1}, G_GID)
+	if _, ok := err.(IdUsedError); !ok {
+		t.Errorf("expected to report IdUsedError, got %v", err)
+	}
+	err = r.ModifyGroup("users", &Group{GID: -1}, G_GID)
+	if _, ok := err.(FieldError); !ok {
+		t.Errorf("expected to report FieldError, got %v", err)
+	}
+
+	if err = r.ModifyGroup("users", &Group{Name: "staff", GID: 500}, G_NAME|G_GID); err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.LookupGroup("staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GID != 500 {
+		t.Errorf("expected GID 500, got %d", g.GID)
+	}
+	if _, err = r.LookupGShadow("staff"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{USER, USER2} {
+		u, err := r.LookupUser(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.GID != 500 {
+			t.Errorf("%s: expected to change the primary GID, got %d", name, u.GID)
+		}
+	}
+
+	// Administrators and members
+	if err = r.SetGroupAdmins("staff", USER, "missing"); err == nil {
+		t.Error("expected to report that the user does not exist")
+	}
+	if err = r.SetGroupAdmins("staff", USER); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.SetGroupMembers("staff", USER, USER2); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := r.LookupGShadow("staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gs.AdminList) != 1 || gs.AdminList[0] != USER {
+		t.Errorf("unexpected admins: %v", gs.AdminList)
+	}
+	if len(gs.UserList) != 2 {
+		t.Errorf("unexpected members in gshadow: %v", gs.UserList)
+	}
+	if g, _ = r.LookupGroup("staff"); len(g.UserList) != 2 {
+		t.Errorf("unexpected members in group: %v", g.UserList)
+	}
+
+	if err = r.SetGroupAdmins("staff"); err != nil {
+		t.Fatal(err)
+	}
+	if gs, _ = r.LookupGShadow("staff"); !HasNoMembers(&Group{UserList: gs.AdminList}) {
+		t.Errorf("expected to clear the admins, got %v", gs.AdminList)
+	}
+}
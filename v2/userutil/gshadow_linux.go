@@ -8,7 +8,6 @@ package userutil
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 	"strings"
 )
@@ -84,7 +83,13 @@ type GShadow struct {
 
 // NewGShadow returns a new GShadow.
 func NewGShadow(username string, members ...string) (*GShadow, error) {
-	if !useGshadow {
+	return defaultRoot.NewGShadow(username, members...)
+}
+
+// NewGShadow returns a new GShadow whether the file gshadow is used in the root
+// directory.
+func (r *Root) NewGShadow(username string, members ...string) (*GShadow, error) {
+	if !r.hasGshadow() {
 		return nil, ErrGshadow
 	}
 
@@ -156,12 +161,18 @@ func (*GShadow) lookUp(line string, f field, value interface{}) interface{} {
 }
 
 // LookupGShadow looks up a shadowed group by name.
-func LookupGShadow(name string) (*GShadow, error) {
-	if !useGshadow {
+func LookupGShadow(name string) (*GShadow, error) { return defaultRoot.LookupGShadow(name) }
+
+// LookupGShadow looks up a shadowed group by name in the root directory.
+func (r *Root) LookupGShadow(name string) (*GShadow, error) {
+	if r.cache != nil {
+		return r.cache.LookupGShadow(name)
+	}
+	if !r.hasGshadow() {
 		return nil, ErrGshadow
 	}
 
-	entries, err := LookupInGShadow(GS_NAME, name, 1)
+	entries, err := r.LookupInGShadow(GS_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -176,12 +187,18 @@ func LookupGShadow(name string) (*GShadow, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error) {
-	if !useGshadow {
+	return defaultRoot.LookupInGShadow(field, value, n)
+}
+
+// LookupInGShadow looks up a shadowed group by the given values in the root
+// directory.
+func (r *Root) LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error) {
+	if !r.hasGshadow() {
 		return nil, ErrGshadow
 	}
-	checkRoot()
+	r.checkRoot()
 
-	iEntries, err := lookUp(&GShadow{}, field, value, n)
+	iEntries, err := lookUp(r, &GShadow{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -204,44 +221,16 @@ func LookupInGShadow(field gshadowField, value string, n int) ([]*GShadow, error
 // If the key is not nil, generates a hashed password.
 //
 // It is created a backup before of modify the original file.
-func (gs *GShadow) Add(key []byte) (err error) {
-	loadConfig()
-
-	gshadow, err := LookupGShadow(gs.Name)
-	if err != nil {
-		if _, ok := err.(NoFoundError); !ok {
-			return
-		}
-	}
-	if gshadow != nil {
-		return ErrGroupExist
-	}
-
-	if gs.Name == "" {
-		return RequiredError("Name")
-	}
-
-	// Backup
-	if err = backup(fileGShadow); err != nil {
-		return
-	}
+func (gs *GShadow) Add(key []byte) error { return gs.AddAt(defaultRoot, key) }
 
-	db, err := openDBFile(fileGShadow, os.O_WRONLY|os.O_APPEND)
-	if err != nil {
-		return
-	}
-	defer func() {
-		if e := db.close(); e != nil && err == nil {
-			err = e
-		}
-	}()
+// AddAt adds a new shadowed group in the root directory.
+// If the key is not nil, generates a hashed password.
+func (gs *GShadow) AddAt(r *Root, key []byte) error {
+	tx := r.beginAction("GShadow.Add")
 
-	if key != nil {
-		gs.password, _ = config.crypter.Generate(key, nil)
-	} else {
-		gs.password = "*" // Password disabled.
+	if err := tx.AddGShadow(gs, key); err != nil {
+		tx.Rollback()
+		return err
 	}
-
-	_, err = db.file.WriteString(gs.String())
-	return
+	return tx.Commit()
 }
@@ -0,0 +1,237 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// homeMode returns the permissions for a new home directory, got from HOME_MODE
+// or else from UMASK, in the configuration.
+func (r *Root) homeMode() (os.FileMode, error) {
+	r.loadConfig()
+
+	if mode := r.config.login.HOME_MODE; mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return 0, err
+		}
+		return os.FileMode(m) & os.ModePerm, nil
+	}
+
+	umask, err := strconv.ParseUint(r.config.login.UMASK, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.ModePerm &^ os.FileMode(umask), nil
+}
+
+// CreateHome creates the home directory of the given user, copying the files
+// of the skeleton directory skel, like "useradd -m".
+// If skel is empty, it is used the directory set in the system configuration
+// (SKEL), which could not exist.
+//
+// The files are owned by the user and her primary group, and the permissions
+// of the home directory are got from HOME_MODE or UMASK.
+func CreateHome(name, skel string) error { return defaultRoot.CreateHome(name, skel) }
+
+// CreateHome creates the home directory of the given user in the root directory.
+func (r *Root) CreateHome(name, skel string) error {
+	return r.createHome("CreateHome", name, skel)
+}
+
+// createHome creates the home directory of the given user, sending the event
+// of the action which created it.
+func (r *Root) createHome(action, name, skel string) error {
+	u, err := r.LookupUser(name)
+	if err != nil {
+		return err
+	}
+	if u.Dir == "" || u.Dir == "/" {
+		return HomeError(u.Dir)
+	}
+
+	mode, err := r.homeMode()
+	if err != nil {
+		return err
+	}
+	if skel == "" {
+		skel = r.config.useradd.SKEL
+	}
+	home := r.join(u.Dir)
+
+	if err = os.MkdirAll(filepath.Dir(home), 0755); err != nil {
+		return err
+	}
+
+	found, err := exist(r.join(skel))
+	if err != nil {
+		return err
+	}
+	if found {
+		if _, err = os.Lstat(home); err == nil {
+			return &os.PathError{Op: "mkdir", Path: home, Err: os.ErrExist}
+		}
+		if err = copyTree(r.join(skel), home, u.UID, u.GID); err != nil {
+			return err
+		}
+	} else {
+		if err = os.Mkdir(home, mode); err != nil {
+			return err
+		}
+		if err = os.Lchown(home, u.UID, u.GID); err != nil {
+			return err
+		}
+	}
+
+	// The permissions set at creating are filtered by the umask of the process.
+	if err = os.Chmod(home, mode); err != nil {
+		return err
+	}
+	r.emitEffect(action, AUDIT_CREATE_HOME, home, u.Name)
+	return nil
+}
+
+// SetCreateHome sets to create the home directory at adding the user, copying
+// the files of the skeleton directory skel; see CreateHome.
+func (u *User) SetCreateHome(skel string) {
+	u.createHome = true
+	u.skel = skel
+}
+
+// createHomeAdded creates the home directory of an user just added, whether it
+// has been set by SetCreateHome, or by CREATE_HOME for users that are not of
+// system. A directory which already exists is kept.
+func (r *Root) createHomeAdded(action string, u *User) error {
+	if !u.createHome &&
+		(u.addSystemUser || !strings.EqualFold(r.config.login.CREATE_HOME, "yes")) {
+		return nil
+	}
+	if found, err := exist(r.join(u.Dir)); err != nil || found {
+		return err
+	}
+	return r.createHome(action, u.Name, u.skel)
+}
+
+// DelOption represents the options to remove an user.
+type DelOption int
+
+const (
+	// Remove the home directory and the mail spool, like "userdel -r".
+	DEL_HOME DelOption = 1 << iota
+
+	// Refuse to remove an user with processes, scheduled jobs or files.
+	DEL_REFUSE
+
+	// Terminate the processes of the user.
+	DEL_KILL
+
+	// Reassign the files owned by the user to another owner.
+	DEL_CHOWN
+)
+
+// DelUserWith removes an user from the system, with the given options.
+func DelUserWith(name string, opt DelOption) error {
+	return defaultRoot.DelUserWith(name, opt)
+}
+
+// DelUserWith removes an user from the root directory, with the given options.
+//
+// With DEL_HOME, it refuses to remove the home directory whether it is shared
+// with another account, reporting SharedHomeError; or whether it is not owned
+// by the user or it is reached through a symbolic link, like "userdel(8)",
+// reporting HomeOwnerError.
+//
+// See DelUserSafe to handle the resources used by the user.
+func (r *Root) DelUserWith(name string, opt DelOption) error {
+	_, err := r.delUserSafe("DelUserWith", name, opt, nil)
+	return err
+}
+
+// checkSharedHome checks that the home directory of the user can be removed.
+func (tx *Tx) checkSharedHome(u *User) error {
+	if u.Dir == "" || u.Dir == "/" {
+		return HomeError(u.Dir)
+	}
+
+	f, err := tx.load(u)
+	if err != nil {
+		return err
+	}
+	for _, line := range f.lines {
+		other, err := parseUser(line)
+		if err != nil {
+			return err
+		}
+		if other.Name != u.Name && filepath.Clean(other.Dir) == filepath.Clean(u.Dir) {
+			return SharedHomeError{u.Dir, other.Name}
+		}
+	}
+	return nil
+}
+
+// checkHomeOwner checks that the home directory of the user is owned by her,
+// and that it is not reached through a symbolic link, so that a directory of
+// the system, like '/usr/sbin', is not removed. A home which does not exist is
+// not checked.
+func (r *Root) checkHomeOwner(u *User) error {
+	home := r.join(u.Dir)
+
+	info, err := os.Lstat(home)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != u.UID {
+		return HomeOwnerError{u.Dir, u.Name}
+	}
+
+	// The root directory could be reached through a link.
+	base, err := filepath.EvalSymlinks(r.Dir())
+	if err != nil {
+		return err
+	}
+	realHome, err := filepath.EvalSymlinks(home)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 || realHome != filepath.Join(base, u.Dir) {
+		return HomeOwnerError{u.Dir, u.Name}
+	}
+	return nil
+}
+
+// == Errors
+//
+
+// A HomeOwnerError reports a home directory which is not removed since it is
+// not owned by the user, or it is reached through a symbolic link.
+type HomeOwnerError struct {
+	Dir  string
+	User string
+}
+
+func (e HomeOwnerError) Error() string {
+	return "home directory " + e.Dir + " is not owned by user " + strconv.Quote(e.User) +
+		" or it is a symbolic link"
+}
+
+// A SharedHomeError reports a home directory used by another user.
+type SharedHomeError struct {
+	Dir  string
+	User string
+}
+
+func (e SharedHomeError) Error() string {
+	return "home directory " + e.Dir + " is shared with user " + strconv.Quote(e.User)
+}
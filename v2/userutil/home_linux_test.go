@@ -0,0 +1,165 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestHome(t *testing.T) {
+	r := newTestRoot(t)
+
+	skel := r.join("/etc/skel")
+	if err := os.MkdirAll(skel, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(skel, ".bashrc"), []byte("# bashrc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uid, err := r.AddUser(USER, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.CreateHome(USER, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.CreateHome(USER, ""); !os.IsExist(err) {
+		t.Errorf("expected to report that the home exists, got %v", err)
+	}
+
+	u, err := r.LookupUser(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := r.join(u.Dir)
+
+	info, err := os.Stat(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("expected to get mode 0755 from UMASK, got %o", info.Mode().Perm())
+	}
+	info, err = os.Stat(filepath.Join(home, ".bashrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); int(st.Uid) != uid || st.Gid != 100 {
+		t.Errorf("expected to be owned by %d:100, got %d:%d", uid, st.Uid, st.Gid)
+	}
+
+	// Shared home
+	if _, err = r.AddSystemUser(SYS_USER, u.Dir, 100); err != nil {
+		t.Fatal(err)
+	}
+	err = r.DelUserWith(USER, DEL_HOME)
+	if _, ok := err.(SharedHomeError); !ok {
+		t.Fatalf("expected to report SharedHomeError, got %v", err)
+	}
+	if _, err = r.LookupUser(USER); err != nil {
+		t.Fatal("expected to keep the user")
+	}
+	if err = r.DelUser(SYS_USER); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.DelUserWith(USER, DEL_HOME); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(home); !os.IsNotExist(err) {
+		t.Error("expected to remove the home directory")
+	}
+}
+
+func TestDelHomeOwner(t *testing.T) {
+	r := newTestRoot(t)
+
+	// Home of the system, owned by another user.
+	lib := r.join("/var/lib/foo")
+	if err := os.MkdirAll(lib, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddSystemUser(SYS_USER, "/var/lib/foo", 100); err != nil {
+		t.Fatal(err)
+	}
+	err := r.DelUserWith(SYS_USER, DEL_HOME)
+	if _, ok := err.(HomeOwnerError); !ok {
+		t.Fatalf("expected to report HomeOwnerError, got %v", err)
+	}
+	if _, err = os.Stat(lib); err != nil {
+		t.Fatal("expected to keep the directory")
+	}
+	if _, err = r.LookupUser(SYS_USER); err != nil {
+		t.Fatal("expected to keep the user")
+	}
+
+	// Home reached through a symbolic link.
+	uid, err := r.AddUser(USER, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := r.join("/srv/data")
+	if err = os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chown(data, uid, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(r.join("/home"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(data, r.join("/home/"+USER)); err != nil {
+		t.Fatal(err)
+	}
+	err = r.DelUserWith(USER, DEL_HOME)
+	if _, ok := err.(HomeOwnerError); !ok {
+		t.Fatalf("expected to report HomeOwnerError, got %v", err)
+	}
+	if _, err = os.Stat(data); err != nil {
+		t.Fatal("expected to keep the directory linked")
+	}
+}
+
+func TestAddUserCreateHome(t *testing.T) {
+	r := newTestRoot(t)
+
+	// Set for the user.
+	u := r.NewUser(USER, 100)
+	u.SetCreateHome("")
+	if _, err := u.AddAt(r); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(r.join(u.Dir)); err != nil || !info.IsDir() {
+		t.Fatalf("expected to create the home directory (%v)", err)
+	}
+
+	// Set by CREATE_HOME, only for users that are not of system.
+	if err := r.setConfValues(fileLogin, "\t", []string{"CREATE_HOME", "yes"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddUser(USER2, 100); err != nil {
+		t.Fatal(err)
+	}
+	u2, err := r.LookupUser(USER2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(r.join(u2.Dir)); err != nil {
+		t.Errorf("expected to create the home directory by CREATE_HOME (%v)", err)
+	}
+
+	if _, err = r.AddSystemUser(SYS_USER, "/var/lib/"+SYS_USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(r.join("/var/lib/" + SYS_USER)); !os.IsNotExist(err) {
+		t.Errorf("expected to not create the home directory of a system user (%v)", err)
+	}
+}
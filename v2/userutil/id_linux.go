@@ -7,74 +7,21 @@
 package userutil
 
 import (
-	"io"
-	"os"
+	"hash/fnv"
 	"sort"
 	"strconv"
 )
 
-// nextUID returns the next free user id to use, according to whether it is a
-// system's user.
-func nextUID(isSystem bool) (db *dbfile, uid int, err error) {
-	loadConfig()
-
-	db, err = openDBFile(fileUser, os.O_RDWR)
-	if err != nil {
-		return
-	}
-	defer func() {
-		if err != nil {
-			db.close()
-		}
-	}()
-
-	// Seek to file half size.
-
-	info, err := db.file.Stat()
-	if err != nil {
-		return db, 0, err
-	}
-	if _, err = db.file.Seek(info.Size()/2, io.SeekStart); err != nil {
-		return db, 0, err
-	}
-	// To starting to read from a new line
-	if _, _, err = db.rd.ReadLine(); err != nil {
-		return db, 0, err
-	}
-
-	var minId, maxId int
-	var listId []int
-
-	if isSystem {
-		minId, maxId = config.login.SYS_UID_MIN, config.login.SYS_UID_MAX
-	} else {
-		minId, maxId = config.login.UID_MIN, config.login.UID_MAX
-	}
-
-	for {
-		line, _, err := db.rd.ReadLine()
-		if err == io.EOF {
-			break
-		}
-
-		u, err := parseUser(string(line))
-		if err != nil {
-			return db, 0, err
-		}
-		if u.UID >= minId && u.UID <= maxId {
-			listId = append(listId, u.UID)
-		}
-	}
-	sort.Ints(listId)
-	//fmt.Println(listId)
-
+// freeId returns the first id unused since the lowest one into listId, which
+// has the sorted ids used into a range starting at minId.
+func freeId(listId []int, minId int) (id int) {
 	switch len(listId) {
 	case 0:
-		uid = minId
+		id = minId
 	case 1:
 		// Sum 1 to the last value
-		uid = listId[0]
-		uid++
+		id = listId[0]
+		id++
 	default:
 		// May have ids unused
 		nextId := listId[0]
@@ -82,162 +29,253 @@ func nextUID(isSystem bool) (db *dbfile, uid int, err error) {
 
 		for _, v := range listId {
 			if v != nextId {
-				uid = nextId
+				id = nextId
 				found = true
 				break
 			}
 			nextId++
 		}
 		if !found {
-			uid = listId[len(listId)-1]
-			uid++
+			id = listId[len(listId)-1]
+			id++
 		}
 	}
-
-	if uid == maxId {
-		return db, 0, &IdRangeError{maxId, isSystem, true}
-	}
 	return
 }
 
-// nextGUID returns the next free group id to use, according to whether it is a
-// system's group.
-func nextGUID(isSystem bool) (db *dbfile, gid int, err error) {
-	loadConfig()
+// == Strategies
+//
 
-	db, err = openDBFile(fileGroup, os.O_RDWR)
-	if err != nil {
-		return
-	}
-	defer func() {
-		if err != nil {
-			db.close()
-		}
-	}()
+// IdStrategy represents the way to choose a free id for an user or group.
+type IdStrategy int
 
-	// Seek to file half size.
+const (
+	// The first id unused since the lowest one used into the range. It is the
+	// default one.
+	ID_NEXT IdStrategy = iota
 
-	info, err := db.file.Stat()
-	if err != nil {
-		return db, 0, err
-	}
-	if _, err = db.file.Seek(info.Size()/2, io.SeekStart); err != nil {
-		return db, 0, err
-	}
-	// To starting to read from a new line
-	if _, _, err = db.rd.ReadLine(); err != nil {
-		return db, 0, err
-	}
+	// The lowest id unused into the range.
+	ID_LOWEST
 
-	var minId, maxId int
-	var listId []int
+	// The preferred id whether it is unused, even out of the range; else, it is
+	// used ID_NEXT.
+	ID_PREFERRED
 
-	if isSystem {
-		minId, maxId = config.login.SYS_GID_MIN, config.login.SYS_GID_MAX
-	} else {
-		minId, maxId = config.login.GID_MIN, config.login.GID_MAX
+	// An id got from a hash (FNV-1a) of the name into the range, so the same
+	// name gets the same id in every system which uses this strategy with the
+	// same range; the ids are not the ones chosen by "systemd-sysusers".
+	// Whether it is used, it is chosen the next one unused.
+	ID_HASH
+)
+
+func (s IdStrategy) String() string {
+	switch s {
+	case ID_LOWEST:
+		return "lowest"
+	case ID_PREFERRED:
+		return "preferred"
+	case ID_HASH:
+		return "hash"
 	}
+	return "next"
+}
 
-	for {
-		line, _, err := db.rd.ReadLine()
-		if err == io.EOF {
-			break
-		}
+// An IdAlloc represents how to choose the id of a new user or group.
+type IdAlloc struct {
+	Strategy  IdStrategy
+	Preferred int // Used with ID_PREFERRED.
+}
 
-		gr, err := parseGroup(string(line))
-		if err != nil {
-			return db, 0, err
+// SetIdAlloc sets how to choose the ids for the new users and groups in the root
+// directory, whether they do not set another one.
+//
+// The ids are chosen into a transaction, which keeps the databases locked, so
+// another allocator that uses the same locks can not choose the same id.
+func (r *Root) SetIdAlloc(a IdAlloc) { r.idAlloc = a }
+
+// SetIdAlloc sets how to choose the UID whether it is < 0 at adding the user.
+func (u *User) SetIdAlloc(a IdAlloc) { u.idAlloc = &a }
+
+// SetIdAlloc sets how to choose the GID whether it is < 0 at adding the group.
+func (g *Group) SetIdAlloc(a IdAlloc) { g.idAlloc = &a }
+
+// allocId returns a free id into the range [minId, maxId] according to the
+// strategy, or false whether the range is exhausted.
+// The map used has all ids used in the database.
+func allocId(used map[int]bool, minId, maxId int, name string, a IdAlloc) (int, bool) {
+	switch a.Strategy {
+	case ID_PREFERRED:
+		if a.Preferred >= 0 && !used[a.Preferred] {
+			return a.Preferred, true
 		}
-		if gr.GID >= minId && gr.GID <= maxId {
-			listId = append(listId, gr.GID)
+
+	case ID_LOWEST:
+		for id := minId; id <= maxId; id++ {
+			if !used[id] {
+				return id, true
+			}
 		}
-	}
-	sort.Ints(listId)
-	//fmt.Println(listId)
+		return 0, false
 
-	switch len(listId) {
-	case 0:
-		gid = minId
-	case 1:
-		// Sum 1 to the last value
-		gid = listId[0]
-		gid++
-	default:
-		// May have ids unused
-		nextId := listId[0]
-		found := false
+	case ID_HASH:
+		size := maxId - minId + 1
+		if size <= 0 {
+			return 0, false
+		}
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		start := int(h.Sum32() % uint32(size))
 
-		for _, v := range listId {
-			if v != nextId {
-				gid = nextId
-				found = true
-				break
+		for i := 0; i < size; i++ {
+			if id := minId + (start+i)%size; !used[id] {
+				return id, true
 			}
-			nextId++
 		}
-		if !found {
-			gid = listId[len(listId)-1]
-			gid++
+		return 0, false
+	}
+
+	listId := make([]int, 0, len(used))
+	for id := range used {
+		if id >= minId && id <= maxId {
+			listId = append(listId, id)
 		}
 	}
+	sort.Ints(listId)
 
-	if gid == maxId {
-		return db, 0, &IdRangeError{maxId, isSystem, false}
+	if id := freeId(listId, minId); id <= maxId {
+		return id, true
 	}
-	return
+	// It could be free some id before of the lowest one used.
+	return allocId(used, minId, maxId, name, IdAlloc{Strategy: ID_LOWEST})
 }
 
+// == Next
+//
+
 // NextSystemUID returns the next free system user id to use.
-func NextSystemUID() (int, error) {
-	db, uid, err := nextUID(true)
-	if err != nil {
-		return uid, err
-	}
+func NextSystemUID() (int, error) { return defaultRoot.NextSystemUID() }
 
-	err = db.close()
-	return uid, err
-}
+// NextSystemUID returns the next free system user id to use in the root directory.
+func (r *Root) NextSystemUID() (int, error) { return r.nextId(&User{}, true) }
 
 // NextSystemGID returns the next free system group id to use.
-func NextSystemGID() (int, error) {
-	db, gid, err := nextGUID(true)
-	if err != nil {
-		return gid, err
-	}
+func NextSystemGID() (int, error) { return defaultRoot.NextSystemGID() }
 
-	err = db.close()
-	return gid, err
-}
+// NextSystemGID returns the next free system group id to use in the root directory.
+func (r *Root) NextSystemGID() (int, error) { return r.nextId(&Group{}, true) }
 
 // NextUID returns the next free user id to use.
-func NextUID() (int, error) {
-	db, uid, err := nextUID(false)
-	if err != nil {
-		return uid, err
+func NextUID() (int, error) { return defaultRoot.NextUID() }
+
+// NextUID returns the next free user id to use in the root directory.
+func (r *Root) NextUID() (int, error) { return r.nextId(&User{}, false) }
+
+// NextGID returns the next free group id to use.
+func NextGID() (int, error) { return defaultRoot.NextGID() }
+
+// NextGID returns the next free group id to use in the root directory.
+func (r *Root) NextGID() (int, error) { return r.nextId(&Group{}, false) }
+
+// nextId returns the next free id using the strategy set in the root directory.
+// The id could be used by another process once it is returned; use a Tx to
+// keep it reserved.
+func (r *Root) nextId(_row row, isSystem bool) (int, error) {
+	r.loadConfig()
+	tx := r.Begin()
+	defer tx.Rollback()
+
+	return tx.nextId(_row, isSystem, "", r.idAlloc)
+}
+
+// NextUID returns the next free user id for the given name, seeing the changes
+// staged.
+func (tx *Tx) NextUID(name string, isSystem bool, a IdAlloc) (int, error) {
+	tx.r.loadConfig()
+	return tx.nextId(&User{}, isSystem, name, a)
+}
+
+// NextGID returns the next free group id for the given name, seeing the changes
+// staged.
+func (tx *Tx) NextGID(name string, isSystem bool, a IdAlloc) (int, error) {
+	tx.r.loadConfig()
+	return tx.nextId(&Group{}, isSystem, name, a)
+}
+
+// nextId returns the next free id to use for the row of an user or a group,
+// according to whether it is of system, and to the strategy.
+func (tx *Tx) nextId(_row row, isSystem bool, name string, a IdAlloc) (int, error) {
+	conf := tx.r.config.login
+
+	var minId, maxId int
+	_, isUser := _row.(*User)
+
+	switch {
+	case isUser && isSystem:
+		minId, maxId = conf.SYS_UID_MIN, conf.SYS_UID_MAX
+	case isUser:
+		minId, maxId = conf.UID_MIN, conf.UID_MAX
+	case isSystem:
+		minId, maxId = conf.SYS_GID_MIN, conf.SYS_GID_MAX
+	default:
+		minId, maxId = conf.GID_MIN, conf.GID_MAX
 	}
 
-	err = db.close()
-	return uid, err
+	id, err := tx.nextIdIn(_row, minId, maxId, name, a)
+	if e, ok := err.(*IdRangeError); ok {
+		e.IsSystem = isSystem
+	}
+	return id, err
 }
 
-// NextGID returns the next free group id to use.
-func NextGID() (int, error) {
-	db, gid, err := nextGUID(false)
+// nextIdIn returns the next free id into the range [minId, maxId] to use for
+// the row of an user or a group, according to the strategy.
+func (tx *Tx) nextIdIn(_row row, minId, maxId int, name string, a IdAlloc) (int, error) {
+	f, err := tx.load(_row)
 	if err != nil {
-		return gid, err
+		return 0, err
+	}
+	_, isUser := _row.(*User)
+
+	used := make(map[int]bool, len(f.lines))
+	for _, line := range f.lines {
+		if isUser {
+			u, err := parseUser(line)
+			if err != nil {
+				return 0, err
+			}
+			used[u.UID] = true
+		} else {
+			gr, err := parseGroup(line)
+			if err != nil {
+				return 0, err
+			}
+			used[gr.GID] = true
+		}
 	}
 
-	err = db.close()
-	return gid, err
+	id, ok := allocId(used, minId, maxId, name, a)
+	if !ok {
+		return 0, &IdRangeError{
+			FirstId:  minId,
+			LastId:   maxId,
+			IsUser:   isUser,
+			Strategy: a.Strategy,
+		}
+	}
+	return id, nil
 }
 
 // * * *
 
-// IdRangeError records an error during the search for a free id to use.
+// IdRangeError records an error during the search for a free id to use, when
+// all ids into the range are used.
 type IdRangeError struct {
+	FirstId  int
 	LastId   int
 	IsSystem bool
 	IsUser   bool
+
+	Strategy IdStrategy
 }
 
 func (e *IdRangeError) Error() string {
@@ -252,5 +290,6 @@ func (e *IdRangeError) Error() string {
 	}
 	str += strconv.Itoa(e.LastId)
 
-	return "reached maximum identifier in " + str
+	return "reached maximum identifier in " + str + " (range " + strconv.Itoa(e.FirstId) +
+		"-" + strconv.Itoa(e.LastId) + " exhausted, strategy " + e.Strategy.String() + ")"
 }
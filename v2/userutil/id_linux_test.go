@@ -46,3 +46,75 @@ func TestID(t *testing.T) {
 		fmt.Println("\tNext GID:", id)
 	}
 }
+
+func TestIdAlloc(t *testing.T) {
+	used := map[int]bool{0: true, 1000: true, 1001: true, 1005: true}
+
+	for _, v := range []struct {
+		alloc IdAlloc
+		want  int
+	}{
+		{IdAlloc{}, 1002},
+		{IdAlloc{Strategy: ID_LOWEST}, 1002},
+		{IdAlloc{Strategy: ID_PREFERRED, Preferred: 1500}, 1500},
+		{IdAlloc{Strategy: ID_PREFERRED, Preferred: 1005}, 1002},
+	} {
+		if id, ok := allocId(used, 1000, 1010, "foo", v.alloc); !ok || id != v.want {
+			t.Errorf("%s: expected id %d, got %d", v.alloc.Strategy, v.want, id)
+		}
+	}
+
+	// Gap before of the lowest id used.
+	used = map[int]bool{1005: true, 1006: true}
+	if id, _ := allocId(used, 1000, 1006, "", IdAlloc{}); id != 1000 {
+		t.Errorf("expected to use the gap at 1000, got %d", id)
+	}
+
+	// The hash is stable, and it probes the next id at colliding.
+	id, ok := allocId(nil, 100, 999, "systemd-network", IdAlloc{Strategy: ID_HASH})
+	if !ok {
+		t.Fatal("expected a free id")
+	}
+	id2, _ := allocId(map[int]bool{id: true}, 100, 999, "systemd-network", IdAlloc{Strategy: ID_HASH})
+	if id2 != id+1 && !(id == 999 && id2 == 100) {
+		t.Errorf("expected to probe the next id to %d, got %d", id, id2)
+	}
+
+	full := map[int]bool{10: true, 11: true}
+	for _, s := range []IdStrategy{ID_NEXT, ID_LOWEST, ID_HASH} {
+		if _, ok = allocId(full, 10, 11, "foo", IdAlloc{Strategy: s}); ok {
+			t.Errorf("%s: expected the range exhausted", s)
+		}
+	}
+}
+
+func TestIdAllocRoot(t *testing.T) {
+	r := newTestRoot(t)
+
+	u := NewSystemUser(SYS_USER, "/", 100)
+	u.SetIdAlloc(IdAlloc{Strategy: ID_HASH})
+	uid, err := u.AddAt(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same name gets the same id in another system.
+	r2 := newTestRoot(t)
+	u = NewSystemUser(SYS_USER, "/", 100)
+	u.SetIdAlloc(IdAlloc{Strategy: ID_HASH})
+	if uid2, err := u.AddAt(r2); err != nil || uid2 != uid {
+		t.Errorf("expected the same UID %d, got %d (%v)", uid, uid2, err)
+	}
+
+	r.SetIdAlloc(IdAlloc{Strategy: ID_PREFERRED, Preferred: 5000})
+	if uid, err = r.AddUser(USER, 100); err != nil || uid != 5000 {
+		t.Errorf("expected the preferred UID, got %d (%v)", uid, err)
+	}
+
+	r.config.login.UID_MIN, r.config.login.UID_MAX = 5000, 5000
+	r.SetIdAlloc(IdAlloc{Strategy: ID_LOWEST})
+	_, err = r.AddUser(USER2, 100)
+	if e, ok := err.(*IdRangeError); !ok || e.FirstId != 5000 || e.Strategy != ID_LOWEST {
+		t.Errorf("expected to report IdRangeError, got %v", err)
+	}
+}
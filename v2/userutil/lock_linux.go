@@ -0,0 +1,194 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The locking follows the protocol used by the shadow utilities (useradd,
+// passwd, vipw), so they respect the changes done by this package:
+//
+//   1. The file '/etc/.pwd.lock' is locked for writing through "fcntl(2)", like
+//      it is done by "lckpwdf(3)".
+//   2. Every database file to modify is locked by a hard link named
+//      '{file}.lock' to a file named '{file}.{pid}' which stores the process id.
+
+// filePwdLock is the file used to lock all the databases.
+var filePwdLock = "/etc/.pwd.lock"
+
+// LOCK_TIMEOUT is the time to wait for getting a lock, like in "lckpwdf(3)".
+var LOCK_TIMEOUT = 15 * time.Second
+
+// lockRetry is the time to wait before of trying to get again a lock.
+const lockRetry = 100 * time.Millisecond
+
+// locksProc serializes the locks got into the process, since the locks done by
+// "fcntl(2)" are owned by the process.
+var locksProc = struct {
+	sync.Mutex
+	m map[string]*procLock // key: file '.pwd.lock'
+}{m: make(map[string]*procLock)}
+
+// A procLock represents the lock of the databases into the process.
+type procLock struct {
+	sem chan struct{}
+}
+
+// A dbLock represents the locks held on the databases of a root directory.
+type dbLock struct {
+	proc  *procLock
+	pwd   *os.File
+	files []string // Files '{file}.lock' created.
+}
+
+// lockPwd locks all the databases in the root directory.
+// It reports LockTimeoutError whether the lock could not be got in LOCK_TIMEOUT.
+func (r *Root) lockPwd() (*dbLock, error) {
+	filename := r.join(filePwdLock)
+	deadline := time.Now().Add(LOCK_TIMEOUT)
+
+	locksProc.Lock()
+	proc, ok := locksProc.m[filename]
+	if !ok {
+		proc = &procLock{sem: make(chan struct{}, 1)}
+		locksProc.m[filename] = proc
+	}
+	locksProc.Unlock()
+
+	select {
+	case proc.sem <- struct{}{}:
+	case <-time.After(time.Until(deadline)):
+		return nil, LockTimeoutError(filename)
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		proc.release()
+		return nil, err
+	}
+
+	flock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0}
+	for {
+		err = syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &flock)
+		if err == nil {
+			return &dbLock{proc: proc, pwd: f}, nil
+		}
+		if (err != syscall.EAGAIN && err != syscall.EACCES) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(lockRetry)
+	}
+
+	f.Close()
+	proc.release()
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return nil, LockTimeoutError(filename)
+	}
+	return nil, err
+}
+
+// lockFile locks the named database file, creating the link '{file}.lock'.
+// A lock owned by a process which is not running is removed.
+func (l *dbLock) lockFile(filename string) error {
+	pid := os.Getpid()
+	fileLock := filename + ".lock"
+	filePid := filename + "." + strconv.Itoa(pid)
+
+	if err := os.WriteFile(filePid, []byte(strconv.Itoa(pid)), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(filePid)
+
+	deadline := time.Now().Add(LOCK_TIMEOUT)
+	for {
+		err := os.Link(filePid, fileLock)
+		if err == nil {
+			// Like the shadow utilities, the lock is only valid whether the link
+			// has not been removed by another process which saw a stale lock.
+			if n, err := linkCount(filePid); err != nil {
+				return err
+			} else if n == 2 {
+				l.files = append(l.files, fileLock)
+				return nil
+			}
+		} else if !os.IsExist(err) {
+			return err
+		} else if isStaleLock(fileLock) {
+			if err = os.Remove(fileLock); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return LockTimeoutError(fileLock)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// linkCount returns the number of hard links of the named file.
+func linkCount(name string) (uint64, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Sys().(*syscall.Stat_t).Nlink), nil
+}
+
+// isStaleLock reports whether the process which created the lock file is not
+// running.
+func isStaleLock(fileLock string) bool {
+	b, err := os.ReadFile(fileLock)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+
+	return errors.Is(syscall.Kill(pid, 0), syscall.ESRCH)
+}
+
+// unlock releases all the locks.
+func (l *dbLock) unlock() (err error) {
+	for _, f := range l.files {
+		if e := os.Remove(f); e != nil && err == nil {
+			err = e
+		}
+	}
+	l.files = nil
+
+	// Closing the file releases the lock done by "fcntl(2)".
+	if e := l.pwd.Close(); e != nil && err == nil {
+		err = e
+	}
+	l.proc.release()
+	return
+}
+
+// release releases the lock into the process.
+func (p *procLock) release() { <-p.sem }
+
+// == Errors
+//
+
+// A LockTimeoutError reports the file which could not be locked before of
+// LOCK_TIMEOUT.
+type LockTimeoutError string
+
+func (e LockTimeoutError) Error() string {
+	return "timeout at locking file: " + string(e)
+}
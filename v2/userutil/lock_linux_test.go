@@ -0,0 +1,65 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	r := newTestRoot(t)
+
+	timeout := LOCK_TIMEOUT
+	LOCK_TIMEOUT = 300 * time.Millisecond
+	defer func() { LOCK_TIMEOUT = timeout }()
+
+	// Locked by a transaction.
+	tx := r.Begin()
+	if _, err := tx.LookupUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(r.join(fileUser) + ".lock"); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error)
+	go func() { errc <- r.LockUser("root") }()
+	err := <-errc
+	if _, ok := err.(LockTimeoutError); !ok {
+		t.Fatalf("expected to report LockTimeoutError, got %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(r.join(fileUser) + ".lock"); !os.IsNotExist(err) {
+		t.Fatal("expected to remove the lock file")
+	}
+
+	// Locked by a running process.
+	fileLock := r.join(fileShadow) + ".lock"
+	if err = os.WriteFile(fileLock, []byte(strconv.Itoa(os.Getppid())), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = r.LockUser("root")
+	if _, ok := err.(LockTimeoutError); !ok {
+		t.Fatalf("expected to report LockTimeoutError, got %v", err)
+	}
+
+	// Lock stale.
+	if err = os.WriteFile(fileLock, []byte("999999999"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.LockUser("root"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(fileLock); !os.IsNotExist(err) {
+		t.Fatal("expected to remove the lock file")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NAME_MAX_LEN is the maximum length of the names of users and groups, like
+// "useradd(8)".
+const NAME_MAX_LEN = 32
+
+// ValidateName checks whether the name of an user or group is valid for the
+// system; isSystem indicates whether it is for a system account.
+// See Root.ValidateName.
+func ValidateName(name string, isSystem bool) error {
+	return defaultRoot.ValidateName(name, isSystem)
+}
+
+// ValidateName checks whether the name of an user or group is valid, according
+// to the configuration in the root directory.
+//
+// The name has to follow the rules of the POSIX portable names: the characters
+// are letters, digits, period, underscore and hyphen, and it does not start
+// with hyphen. Like in "useradd(8)", it can end with a dollar sign (used by
+// Samba for machine accounts), and it can not be fully numeric.
+//
+// Whether the file '/etc/adduser.conf' sets NAME_REGEX or NAME_REGEX_SYSTEM,
+// the name also has to match with it.
+func (r *Root) ValidateName(name string, isSystem bool) error {
+	r.loadConfig()
+
+	if err := checkPortableName(name); err != nil {
+		return err
+	}
+
+	re, err := r.config.nameRegex, r.config.nameRegexErr
+	if isSystem {
+		re, err = r.config.sysNameRegex, r.config.sysNameRegexErr
+	}
+	if err != nil {
+		return err
+	}
+	if re != nil && !re.MatchString(name) {
+		return NameError{name, "does not match with " + strconv.Quote(re.String())}
+	}
+	return nil
+}
+
+// compileNameRegex compiles the regular expression set in the key, if any.
+// It returns ConfigError whether it is not valid.
+func compileNameRegex(key, expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, &ConfigError{key, err.Error()}
+	}
+	return re, nil
+}
+
+// checkPortableName checks the built-in rules for the names.
+func checkPortableName(name string) error {
+	switch {
+	case name == "":
+		return NameError{name, "is empty"}
+	case len(name) > NAME_MAX_LEN:
+		return NameError{name, "is longer than " + strconv.Itoa(NAME_MAX_LEN) + " characters"}
+	case name[0] == '-':
+		return NameError{name, "starts with hyphen"}
+	case name == "." || name == "..":
+		return NameError{name, "is reserved"}
+	}
+
+	body := strings.TrimSuffix(name, "$")
+	if body == "" {
+		return NameError{name, "has not valid characters"}
+	}
+	isNumeric := true
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '.', c == '_', c == '-':
+			isNumeric = false
+		default:
+			return NameError{name, "has a character not valid: " + strconv.QuoteRune(rune(c))}
+		}
+	}
+	if isNumeric {
+		return NameError{name, "is fully numeric"}
+	}
+	return nil
+}
+
+// == Errors
+//
+
+// A NameError reports a name of user or group not valid.
+type NameError struct {
+	Name   string
+	Reason string
+}
+
+func (e NameError) Error() string {
+	return "invalid name " + strconv.Quote(e.Name) + ": " + e.Reason
+}
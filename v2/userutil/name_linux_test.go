@@ -0,0 +1,87 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	r := newTestRoot(t)
+
+	for _, name := range []string{"foo", "Foo.bar-1", "_sys", "host$", "a1"} {
+		if err := r.ValidateName(name, false); err != nil {
+			t.Errorf("%q: %s", name, err)
+		}
+	}
+	for _, name := range []string{
+		"", "-foo", "foo:bar", "foo\nbar", "foo bar", "..", "1000", "$",
+		"fóo", strings.Repeat("a", NAME_MAX_LEN+1),
+	} {
+		if _, ok := r.ValidateName(name, false).(NameError); !ok {
+			t.Errorf("%q: expected to report NameError", name)
+		}
+	}
+
+	passwd, err := os.ReadFile(r.join(fileUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.AddUser("foo:0:0", 100); err == nil {
+		t.Error("expected to fail at adding an user with a name not valid")
+	}
+	if _, err = r.AddGroup("-g"); err == nil {
+		t.Error("expected to fail at adding a group with a name not valid")
+	}
+	if b, _ := os.ReadFile(r.join(fileUser)); string(b) != string(passwd) {
+		t.Error("expected to keep the file of users")
+	}
+
+	// Rules of Debian
+	err = os.WriteFile(r.join(fileAdduser), []byte(`NAME_REGEX="^[a-z][-a-z0-9_]*\$?$"
+NAME_REGEX_SYSTEM="^[A-Za-z_][-A-Za-z0-9_]*\$?$"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRoot(r.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.ValidateName("Foo", false); err == nil {
+		t.Error("expected to not match NAME_REGEX")
+	}
+	if err = r.ValidateName("Foo", true); err != nil {
+		t.Errorf("expected to match NAME_REGEX_SYSTEM: %s", err)
+	}
+	if err = r.ValidateName("foo$", false); err != nil {
+		t.Error(err)
+	}
+	if err = r.ModifyUser("daemon", &User{Name: "Daemon"}, U_NAME, 0); err != nil {
+		t.Errorf("expected to rename a system user: %s", err)
+	}
+
+	// Regular expression not valid.
+	err = os.WriteFile(r.join(fileAdduser), []byte(`NAME_REGEX="^[a-z"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = NewRoot(r.Dir()); err != nil {
+		t.Fatal(err)
+	}
+	err = r.ValidateName("foo", false)
+	if e, ok := err.(*ConfigError); !ok || e.Key != "NAME_REGEX" {
+		t.Errorf("expected to report ConfigError for NAME_REGEX, got %v", err)
+	}
+	if err = r.ValidateName("foo", true); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"errors"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+)
+
+var ErrUnknownHash = errors.New("passwd is not hashed by a known crypt function")
+
+// A HashPolicy represents the crypt function and the minimum cost which the
+// hashed passwords have to use.
+type HashPolicy struct {
+	Crypt   crypt.Crypt
+	MinCost int // 0 whether the crypt function has a fixed cost.
+}
+
+// GetHashPolicy returns the policy used to hash the new passwords.
+func GetHashPolicy() *HashPolicy { return defaultRoot.GetHashPolicy() }
+
+// GetHashPolicy returns the policy used to hash the new passwords in the root
+// directory, got from the crypt function set and its keys in the configuration.
+// Without keys about the cost, the minimum is the default cost of the function.
+func (r *Root) GetHashPolicy() *HashPolicy {
+	r.loadConfig()
+	return &HashPolicy{
+		Crypt:   r.config.cryptFn,
+		MinCost: minCryptCost(r.config.cryptFn, &r.config.login),
+	}
+}
+
+// NeedsRehash reports whether the hashed key has to be hashed again to follow
+// the policy, because it uses another crypt function or a lower cost.
+// The values which are not hashes, like a locked or disabled passwd, never need
+// it since there is not a key to hash.
+func (p *HashPolicy) NeedsRehash(hashedKey string) bool {
+	if hashedKey == "" || hashedKey[0] == lockChar || hashedKey[0] == '*' {
+		return false
+	}
+
+	c, ok := crypt.FromHash(hashedKey)
+	if !ok || c != p.Crypt {
+		return true
+	}
+	if p.MinCost == 0 {
+		return false
+	}
+	cost, err := crypt.New(c).Cost(hashedKey)
+	return err != nil || cost < p.MinCost
+}
+
+// minCryptCost returns the minimum cost for the crypt function c, got from its
+// keys in the configuration.
+func minCryptCost(c crypt.Crypt, conf *confLogin) int {
+	getSalt, ok := cryptSalts[c]
+	if !ok {
+		return 0
+	}
+
+	var cost int
+	switch c {
+	case crypt.SHA256, crypt.SHA512:
+		cost = conf.SHA_CRYPT_MIN_ROUNDS
+		if cost == 0 {
+			cost = conf.SHA_CRYPT_MAX_ROUNDS
+		}
+	case crypt.BCRYPT:
+		cost = conf.BCRYPT_MIN_ROUNDS
+		if cost == 0 {
+			cost = conf.BCRYPT_MAX_ROUNDS
+		}
+	case crypt.YESCRYPT:
+		cost = conf.YESCRYPT_COST_FACTOR
+	}
+
+	// The crypt functions use the limits for values out of range.
+	salt := getSalt()
+	switch {
+	case cost == 0:
+		cost = salt.RoundsDefault
+	case cost < salt.RoundsMin:
+		cost = salt.RoundsMin
+	case cost > salt.RoundsMax:
+		cost = salt.RoundsMax
+	}
+	return cost
+}
+
+// == Rehashing
+//
+
+// VerifyAndRehash verifies the passwd of the given user, supplied in
+// clear-text. On success, it is hashed again whether it does not follow the
+// hash policy; the boolean reports whether it was done.
+func VerifyAndRehash(name string, key []byte) (rehashed bool, err error) {
+	return defaultRoot.VerifyAndRehash(name, key)
+}
+
+// VerifyAndRehash verifies the passwd of the given user, supplied in
+// clear-text, in the root directory. On success, it is hashed again whether it
+// does not follow the hash policy; the boolean reports whether it was done.
+//
+// The date of the last password change is not modified, since the passwd is
+// the same.
+//
+// The shadowed user is read, verified and replaced into a same transaction, so
+// the passwd can not be changed in the meantime.
+func (r *Root) VerifyAndRehash(name string, key []byte) (rehashed bool, err error) {
+	tx := r.beginAction("VerifyAndRehash")
+	defer func() {
+		if !rehashed {
+			tx.Rollback()
+		}
+	}()
+
+	shadow, err := tx.LookupShadow(name)
+	if err != nil {
+		return false, err
+	}
+	if err = verifyHash(shadow.password, key); err != nil {
+		return false, err
+	}
+	if !r.GetHashPolicy().NeedsRehash(shadow.password) {
+		return false, nil
+	}
+	if shadow.password, err = r.hashPasswd(key, 0); err != nil {
+		return false, err
+	}
+
+	if err = tx.EditShadow(name, shadow); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyHash compares the hashed key with the key, using the crypt function of
+// the hash.
+func verifyHash(hashedKey string, key []byte) error {
+	c, ok := crypt.FromHash(hashedKey)
+	if !ok {
+		return ErrUnknownHash
+	}
+	return crypt.New(c).Verify(hashedKey, key)
+}
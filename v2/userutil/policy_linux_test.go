@@ -0,0 +1,121 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p3ls/osutil/v2/userutil/crypt"
+)
+
+func TestNeedsRehash(t *testing.T) {
+	p := &HashPolicy{Crypt: crypt.SHA512, MinCost: 65536}
+
+	for hash, want := range map[string]bool{
+		"":                                     false,
+		"*":                                    false,
+		"!":                                    false,
+		"!$1$saltsalt$":                        false,
+		"abJnggxhB/yWI":                        true, // DES
+		"$1$saltstring$YtSqp2b3JMO2tn8rg5uHf1": true,
+		"$y$j9T$WZaPV7LSUEKMo34.$yTRNpH5.toq0WjriO0WJbuI0k.fBX5vowuHQ6QUmQS9":                                               true,
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1":              true,
+		"$6$rounds=65536$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1": false,
+		"$6$rounds=99999$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1": false,
+	} {
+		if got := p.NeedsRehash(hash); got != want {
+			t.Errorf("%q: expected %v, got %v", hash, want, got)
+		}
+	}
+
+	p = &HashPolicy{Crypt: crypt.MD5}
+	if p.NeedsRehash("$1$saltstring$YtSqp2b3JMO2tn8rg5uHf1") {
+		t.Error("expected to not need a rehash with the same crypt function")
+	}
+}
+
+func TestVerifyAndRehash(t *testing.T) {
+	r := newTestRoot(t)
+
+	r.SetCrypter(crypt.MD5)
+	if err := r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	old, err := r.LookupShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.SetLoginDefs(&LoginDefs{EncryptMethod: "sha512", ShaCryptMinRounds: 10000,
+		ShaCryptMaxRounds: 20000}, LD_ENCRYPT_METHOD|LD_SHA_CRYPT_ROUNDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := r.GetHashPolicy(); p.Crypt != crypt.SHA512 || p.MinCost != 10000 {
+		t.Fatalf("policy not expected: %+v", p)
+	}
+
+	if rehashed, err := r.VerifyAndRehash("daemon", []byte("wrong")); err != crypt.ErrKeyMismatch || rehashed {
+		t.Fatalf("expected ErrKeyMismatch, got %v", err)
+	}
+	if rehashed, err := r.VerifyAndRehash("daemon", []byte("secret")); err != nil || !rehashed {
+		t.Fatalf("expected to rehash the passwd (%v)", err)
+	}
+
+	s, err := r.LookupShadow("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s.password, "$6$rounds=") {
+		t.Fatalf("expected a SHA512 hash, got %s", s.password)
+	}
+	if s.changed != old.changed {
+		t.Errorf("expected to keep the date of the last change")
+	}
+
+	if rehashed, err := r.VerifyAndRehash("daemon", []byte("secret")); err != nil || rehashed {
+		t.Errorf("expected to not rehash again (%v)", err)
+	}
+	if _, err := r.VerifyAndRehash("nobody", []byte("")); err != ErrUnknownHash {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+
+	// The passwd changed by another transaction, while the rehashing waits for
+	// the lock, is not replaced.
+	r.SetCrypter(crypt.MD5)
+	if err = r.ChPasswd("daemon", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	tx := r.Begin()
+	if s, err = tx.LookupShadow("daemon"); err != nil {
+		t.Fatal(err)
+	}
+	if s.password, err = r.hashPasswd([]byte("other"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.EditShadow("daemon", s); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.VerifyAndRehash("daemon", []byte("secret"))
+		done <- err
+	}()
+	time.Sleep(2 * lockRetry)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != crypt.ErrKeyMismatch {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+	if s2, _ := r.LookupShadow("daemon"); s2.password != s.password {
+		t.Error("expected to keep the passwd changed")
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// rowIter streams the entries of a database file, or of the rows got from a
+// backend.
+type rowIter struct {
+	dbf       *dbfile
+	lines     []string
+	fromStore bool
+	parse     func(string) (interface{}, error)
+
+	entry interface{}
+	err   error
+}
+
+func (r *Root) newRowIter(_row row, parse func(string) (interface{}, error)) (*rowIter, error) {
+	if r.backend != nil {
+		lines, err := r.loadRows(_row)
+		if err != nil {
+			return nil, err
+		}
+		return &rowIter{lines: lines, fromStore: true, parse: parse}, nil
+	}
+
+	dbf, err := openDBFile(r.join(_row.filename()), os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return &rowIter{dbf: dbf, parse: parse}, nil
+}
+
+// next parses the next entry. It closes the file at the end or at failing.
+func (it *rowIter) next() bool {
+	if it.fromStore {
+		for len(it.lines) != 0 {
+			line := it.lines[0]
+			it.lines = it.lines[1:]
+			if line == "" {
+				continue
+			}
+
+			if it.entry, it.err = it.parse(line); it.err != nil {
+				it.close()
+				return false
+			}
+			return true
+		}
+		it.close()
+		return false
+	}
+	if it.dbf == nil {
+		return false
+	}
+
+	for {
+		line, err := it.dbf.rd.ReadString('\n')
+		if err != nil && err != io.EOF {
+			it.err = err
+			it.close()
+			return false
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if line != "" {
+			if it.entry, it.err = it.parse(line); it.err != nil {
+				it.close()
+				return false
+			}
+			return true
+		}
+		if err == io.EOF {
+			it.close()
+			return false
+		}
+	}
+}
+
+func (it *rowIter) close() error {
+	if it.fromStore {
+		it.lines = nil
+		it.entry = nil
+		return nil
+	}
+	if it.dbf == nil {
+		return nil
+	}
+	err := it.dbf.close()
+	it.dbf = nil
+	it.entry = nil
+	return err
+}
+
+// == Users
+//
+
+// An UserIter is an iterator over the users, like "bufio.Scanner":
+//
+//	it, err := AllUsers()
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		u := it.User()
+//		...
+//	}
+//	if err = it.Err(); err != nil {
+//	...
+type UserIter struct{ it *rowIter }
+
+// AllUsers returns an iterator over all users of the system.
+func AllUsers() (*UserIter, error) { return defaultRoot.AllUsers() }
+
+// AllUsers returns an iterator over all users in the root directory.
+func (r *Root) AllUsers() (*UserIter, error) {
+	it, err := r.newRowIter(&User{}, func(line string) (interface{}, error) {
+		return parseUser(line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &UserIter{it}, nil
+}
+
+// Next advances to the next user, which will be available through User.
+// It returns false when there are no more users or there is an error.
+func (i *UserIter) Next() bool { return i.it.next() }
+
+// User returns the current user.
+func (i *UserIter) User() *User { return i.it.entry.(*User) }
+
+// Err returns the first error found during the iteration.
+func (i *UserIter) Err() error { return i.it.err }
+
+// Close releases the file; it is only needed whether the iteration is not
+// finished.
+func (i *UserIter) Close() error { return i.it.close() }
+
+// FindUsers returns the users of the system that match.
+func FindUsers(match func(*User) bool) ([]*User, error) { return defaultRoot.FindUsers(match) }
+
+// FindUsers returns the users in the root directory that match.
+func (r *Root) FindUsers(match func(*User) bool) ([]*User, error) {
+	it, err := r.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var entries []*User
+	for it.Next() {
+		if u := it.User(); match(u) {
+			entries = append(entries, u)
+		}
+	}
+	return entries, it.Err()
+}
+
+// == Shadowed users
+//
+
+// A ShadowIter is an iterator over the shadowed users. See UserIter.
+type ShadowIter struct{ it *rowIter }
+
+// AllShadows returns an iterator over all shadowed users of the system.
+func AllShadows() (*ShadowIter, error) { return defaultRoot.AllShadows() }
+
+// AllShadows returns an iterator over all shadowed users in the root directory.
+func (r *Root) AllShadows() (*ShadowIter, error) {
+	r.checkRoot()
+
+	it, err := r.newRowIter(&Shadow{}, func(line string) (interface{}, error) {
+		return parseShadow(line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ShadowIter{it}, nil
+}
+
+// Next advances to the next shadowed user.
+func (i *ShadowIter) Next() bool { return i.it.next() }
+
+// Shadow returns the current shadowed user.
+func (i *ShadowIter) Shadow() *Shadow { return i.it.entry.(*Shadow) }
+
+// Err returns the first error found during the iteration.
+func (i *ShadowIter) Err() error { return i.it.err }
+
+// Close releases the file.
+func (i *ShadowIter) Close() error { return i.it.close() }
+
+// FindShadows returns the shadowed users of the system that match.
+func FindShadows(match func(*Shadow) bool) ([]*Shadow, error) {
+	return defaultRoot.FindShadows(match)
+}
+
+// FindShadows returns the shadowed users in the root directory that match.
+func (r *Root) FindShadows(match func(*Shadow) bool) ([]*Shadow, error) {
+	it, err := r.AllShadows()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var entries []*Shadow
+	for it.Next() {
+		if s := it.Shadow(); match(s) {
+			entries = append(entries, s)
+		}
+	}
+	return entries, it.Err()
+}
+
+// == Groups
+//
+
+// A GroupIter is an iterator over the groups. See UserIter.
+type GroupIter struct{ it *rowIter }
+
+// AllGroups returns an iterator over all groups of the system.
+func AllGroups() (*GroupIter, error) { return defaultRoot.AllGroups() }
+
+// AllGroups returns an iterator over all groups in the root directory.
+func (r *Root) AllGroups() (*GroupIter, error) {
+	it, err := r.newRowIter(&Group{}, func(line string) (interface{}, error) {
+		return parseGroup(line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GroupIter{it}, nil
+}
+
+// Next advances to the next group.
+func (i *GroupIter) Next() bool { return i.it.next() }
+
+// Group returns the current group.
+func (i *GroupIter) Group() *Group { return i.it.entry.(*Group) }
+
+// Err returns the first error found during the iteration.
+func (i *GroupIter) Err() error { return i.it.err }
+
+// Close releases the file.
+func (i *GroupIter) Close() error { return i.it.close() }
+
+// FindGroups returns the groups of the system that match.
+func FindGroups(match func(*Group) bool) ([]*Group, error) { return defaultRoot.FindGroups(match) }
+
+// FindGroups returns the groups in the root directory that match.
+func (r *Root) FindGroups(match func(*Group) bool) ([]*Group, error) {
+	it, err := r.AllGroups()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var entries []*Group
+	for it.Next() {
+		if g := it.Group(); match(g) {
+			entries = append(entries, g)
+		}
+	}
+	return entries, it.Err()
+}
+
+// == Shadowed groups
+//
+
+// A GShadowIter is an iterator over the shadowed groups. See UserIter.
+type GShadowIter struct{ it *rowIter }
+
+// AllGShadows returns an iterator over all shadowed groups of the system.
+func AllGShadows() (*GShadowIter, error) { return defaultRoot.AllGShadows() }
+
+// AllGShadows returns an iterator over all shadowed groups in the root directory.
+func (r *Root) AllGShadows() (*GShadowIter, error) {
+	if !r.hasGshadow() {
+		return nil, ErrGshadow
+	}
+	r.checkRoot()
+
+	it, err := r.newRowIter(&GShadow{}, func(line string) (interface{}, error) {
+		return parseGShadow(line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GShadowIter{it}, nil
+}
+
+// Next advances to the next shadowed group.
+func (i *GShadowIter) Next() bool { return i.it.next() }
+
+// GShadow returns the current shadowed group.
+func (i *GShadowIter) GShadow() *GShadow { return i.it.entry.(*GShadow) }
+
+// Err returns the first error found during the iteration.
+func (i *GShadowIter) Err() error { return i.it.err }
+
+// Close releases the file.
+func (i *GShadowIter) Close() error { return i.it.close() }
+
+// FindGShadows returns the shadowed groups of the system that match.
+func FindGShadows(match func(*GShadow) bool) ([]*GShadow, error) {
+	return defaultRoot.FindGShadows(match)
+}
+
+// FindGShadows returns the shadowed groups in the root directory that match.
+func (r *Root) FindGShadows(match func(*GShadow) bool) ([]*GShadow, error) {
+	it, err := r.AllGShadows()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var entries []*GShadow
+	for it.Next() {
+		if gs := it.GShadow(); match(gs) {
+			entries = append(entries, gs)
+		}
+	}
+	return entries, it.Err()
+}
+
+// == Predicates
+//
+
+const fileShells = "/etc/shells"
+
+// Shells returns the valid login shells of the system, from '/etc/shells'.
+func Shells() ([]string, error) { return defaultRoot.Shells() }
+
+// Shells returns the valid login shells in the root directory.
+func (r *Root) Shells() ([]string, error) {
+	f, err := os.Open(r.join(fileShells))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var shells []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		shells = append(shells, line)
+	}
+	return shells, scan.Err()
+}
+
+// IsHuman reports whether the user is not of system, according to the range of
+// ids set in the configuration of the root directory.
+func (r *Root) IsHuman(u *User) bool {
+	r.loadConfig()
+	return u.UID >= r.config.login.UID_MIN && u.UID <= r.config.login.UID_MAX
+}
+
+// HasShell returns a predicate which reports whether the shell of an user is
+// one of the given ones, i.e. got from Shells.
+func HasShell(shells ...string) func(*User) bool {
+	return func(u *User) bool {
+		for _, v := range shells {
+			if u.Shell == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HasNoMembers reports whether the group has not members.
+func HasNoMembers(g *Group) bool {
+	for _, v := range g.UserList {
+		if v != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	r := newTestRoot(t)
+
+	if err := os.WriteFile(r.join(fileShells), []byte("# comment\n/bin/sh\n/bin/bash\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddUsersToGroup("daemon", USER); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := r.AllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for it.Next() {
+		names = append(names, it.User().Name)
+	}
+	if err = it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 4 || names[0] != "root" || names[3] != USER {
+		t.Errorf("unexpected users: %v", names)
+	}
+
+	shells, err := r.Shells()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasShell := HasShell(shells...)
+	users, err := r.FindUsers(func(u *User) bool { return r.IsHuman(u) && hasShell(u) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != USER {
+		t.Errorf("expected to find the human user, got %v", users)
+	}
+
+	groups, err := r.FindGroups(HasNoMembers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 3 {
+		t.Errorf("expected 3 groups without members, got %d", len(groups))
+	}
+
+	shadows, err := r.FindShadows(func(s *Shadow) bool { return s.Name == USER })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shadows) != 1 {
+		t.Errorf("expected to find the shadowed user, got %d", len(shadows))
+	}
+	gshadows, err := r.FindGShadows(func(gs *GShadow) bool { return !HasNoMembers(&Group{UserList: gs.UserList}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gshadows) != 1 || gshadows[0].Name != "daemon" {
+		t.Errorf("expected to find the shadowed group with members, got %v", gshadows)
+	}
+
+	// Stop before of the end.
+	git, err := r.AllGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !git.Next() || git.Group().Name != "root" {
+		t.Error("expected the first group")
+	}
+	if err = git.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if git.Next() {
+		t.Error("expected to finish the iteration after of closing")
+	}
+}
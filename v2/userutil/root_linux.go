@@ -0,0 +1,108 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A Root represents the directory where are looked for the databases of users
+// and groups, and the configuration files used to add accounts, like it is
+// done by flag '--root' of "useradd(8)".
+//
+// It lets to handle the accounts of a system mounted in another directory, i.e.
+// an image of an operating system.
+type Root struct {
+	dir string
+
+	useGshadow bool
+	config     *configData
+	cache      *Cache
+	idAlloc    IdAlloc
+	backend    Backend // nil for the file backend
+	audit      AuditSink
+}
+
+// defaultRoot is the root used by the functions at package level, which handle
+// the databases of the running system.
+var defaultRoot = &Root{config: &config}
+
+// NewRoot returns a Root to handle the databases found under the directory dir.
+func NewRoot(dir string) (*Root, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, RootError(dir)
+	}
+
+	r := &Root{dir: dir, config: &configData{}}
+
+	if r.useGshadow, err = exist(r.join(fileGShadow)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Dir returns the root directory.
+func (r *Root) Dir() string {
+	if r.dir == "" {
+		return "/"
+	}
+	return r.dir
+}
+
+// join returns the path of the named file under the root directory.
+func (r *Root) join(name string) string {
+	if r.dir == "" {
+		return name
+	}
+	return filepath.Join(r.dir, name)
+}
+
+// hasGshadow reports whether the file gshadow is used in the root directory.
+func (r *Root) hasGshadow() bool {
+	if r.backend != nil {
+		return r.backend.GShadow() != nil
+	}
+	if r.dir == "" {
+		return useGshadow
+	}
+	return r.useGshadow
+}
+
+// checkRoot checks if the user is root, but only when the databases of the
+// running system are handled.
+func (r *Root) checkRoot() {
+	if r.dir == "" && r.backend == nil {
+		checkRoot()
+	}
+}
+
+// loadConfig loads the user configuration found in the root directory.
+func (r *Root) loadConfig() {
+	r.config.Do(func() {
+		if err := r.config.init(r, false); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// == Errors
+//
+
+// A RootError reports a root directory that could not be used.
+type RootError string
+
+func (e RootError) Error() string { return "root is not a directory: " + string(e) }
@@ -0,0 +1,150 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Files of the fixture tree used to test the handling of an alternate root.
+// The databases are placed into the paths used by the tests (see "a_linux_test.go").
+func rootFiles() map[string]string {
+	return map[string]string{
+		fileUser: `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+`,
+		fileGroup: `root:x:0:
+daemon:x:1:
+users:x:100:
+nogroup:x:65534:
+`,
+		fileShadow: `root:*:18000:0:99999:7:::
+daemon:*:18000:0:99999:7:::
+nobody:*:18000:0:99999:7:::
+`,
+		fileGShadow: `root:*::
+daemon:*::
+users:*::
+nogroup:*::
+`,
+		fileLogin: `PASS_MAX_DAYS	99999
+PASS_MIN_DAYS	0
+PASS_WARN_AGE	7
+UID_MIN			 1000
+UID_MAX			60000
+SYS_UID_MIN		  100
+SYS_UID_MAX		  999
+GID_MIN			 1000
+GID_MAX			60000
+SYS_GID_MIN		  100
+SYS_GID_MAX		  999
+ENCRYPT_METHOD SHA512
+`,
+		fileUseradd: `HOME=/home
+SHELL=/bin/sh
+`,
+	}
+}
+
+// newTestRoot creates a fixture tree into a temporary directory, and returns
+// its Root.
+func newTestRoot(t *testing.T) *Root {
+	dir := t.TempDir()
+
+	for name, data := range rootFiles() {
+		name = filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// newLibuserRoot returns a fixture tree like newTestRoot, based in Red Hat: with
+// the given content for '/etc/libuser.conf'.
+func newLibuserRoot(t *testing.T, conf string) *Root {
+	r := newTestRoot(t)
+
+	if err := os.WriteFile(r.join(fileLibuser), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRoot(t *testing.T) {
+	r := newTestRoot(t)
+
+	if _, err := NewRoot(r.join(fileUser)); err == nil {
+		t.Error("expected to report RootError")
+	}
+	if !r.hasGshadow() {
+		t.Error("expected to use the file gshadow")
+	}
+
+	if _, err := r.LookupUser("daemon"); err != nil {
+		t.Fatal(err)
+	}
+
+	gid, err := r.AddGroup(GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gid != 1000 {
+		t.Errorf("expected to get GID 1000, got %d", gid)
+	}
+
+	uid, err := r.AddUser(USER, gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != 1000 {
+		t.Errorf("expected to get UID 1000, got %d", uid)
+	}
+	if uid, err = r.NextUID(); err != nil {
+		t.Fatal(err)
+	} else if uid != 1001 {
+		t.Errorf("expected to get next UID 1001, got %d", uid)
+	}
+
+	if err = r.ChPasswd(USER, userKey1); err != nil {
+		t.Fatal(err)
+	}
+	s, err := r.LookupShadow(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.config.crypter.Verify(s.password, userKey1); err != nil {
+		t.Errorf("expected to get the same hashed password for %q", userKey1)
+	}
+
+	// The running system has not been modified.
+	if _, err = LookupUser(USER); err == nil {
+		t.Fatalf("user %q added out of the root directory", USER)
+	}
+
+	if err = r.DelUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.LookupShadow(USER); err == nil {
+		t.Error("expected to remove the shadowed user")
+	}
+	if err = r.DelGroup(GROUP); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,7 +8,6 @@ package userutil
 
 import (
 	"fmt"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -196,15 +195,19 @@ type Shadow struct {
 
 // NewShadow returns a structure Shadow with fields "Min", "Max" and "Warn"
 // got from the system configuration, and enabling the features of password aging.
-func NewShadow(username string) *Shadow {
-	loadConfig()
+func NewShadow(username string) *Shadow { return defaultRoot.NewShadow(username) }
+
+// NewShadow returns a structure Shadow with fields "Min", "Max" and "Warn"
+// got from the configuration in the root directory.
+func (r *Root) NewShadow(username string) *Shadow {
+	r.loadConfig()
 
 	return &Shadow{
 		Name:    username,
 		changed: _ENABLE_AGING,
-		Min:     config.login.PASS_MIN_DAYS,
-		Max:     config.login.PASS_MAX_DAYS,
-		Warn:    config.login.PASS_WARN_AGE,
+		Min:     r.config.login.PASS_MIN_DAYS,
+		Max:     r.config.login.PASS_MAX_DAYS,
+		Warn:    r.config.login.PASS_WARN_AGE,
 	}
 }
 
@@ -222,7 +225,14 @@ func (s *Shadow) DisableAging() { s.changed = _DISABLE_AGING }
 func (s *Shadow) EnableAging() { s.setChange() }
 
 // SetExpire sets the date of expiration of the account.
-func (s *Shadow) SetExpire(t *time.Time) { s.expire = secToDay(t.Unix()) }
+// If t is nil, the account will never expire.
+func (s *Shadow) SetExpire(t *time.Time) {
+	if t == nil {
+		s.expire = 0
+		return
+	}
+	s.expire = secToDay(t.Unix())
+}
 
 func (s *Shadow) filename() string { return fileShadow }
 
@@ -401,8 +411,14 @@ func (*Shadow) lookUp(line string, f field, value interface{}) interface{} {
 }
 
 // LookupShadow looks for the entry for the given user name.
-func LookupShadow(name string) (*Shadow, error) {
-	entries, err := LookupInShadow(S_NAME, name, 1)
+func LookupShadow(name string) (*Shadow, error) { return defaultRoot.LookupShadow(name) }
+
+// LookupShadow looks for the entry for the given user name in the root directory.
+func (r *Root) LookupShadow(name string) (*Shadow, error) {
+	if r.cache != nil {
+		return r.cache.LookupShadow(name)
+	}
+	entries, err := r.LookupInShadow(S_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -417,9 +433,15 @@ func LookupShadow(name string) (*Shadow, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, error) {
-	checkRoot()
+	return defaultRoot.LookupInShadow(field, value, n)
+}
+
+// LookupInShadow looks up a shadowed password by the given values in the root
+// directory.
+func (r *Root) LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, error) {
+	r.checkRoot()
 
-	iEntries, err := lookUp(&Shadow{}, field, value, n)
+	iEntries, err := lookUp(r, &Shadow{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -442,53 +464,16 @@ func LookupInShadow(field shadowField, value interface{}, n int) ([]*Shadow, err
 // If the key is not nil, generates a hashed password.
 //
 // It is created a backup before of modify the original file.
-func (s *Shadow) Add(key []byte) (err error) {
-	loadConfig()
-
-	shadow, err := LookupShadow(s.Name)
-	if err != nil {
-		if _, ok := err.(NoFoundError); !ok {
-			return
-		}
-	}
-	if shadow != nil {
-		return ErrUserExist
-	}
+func (s *Shadow) Add(key []byte) error { return s.AddAt(defaultRoot, key) }
 
-	if s.Name == "" {
-		return RequiredError("Name")
-	}
-	if s.Max == 0 {
-		return RequiredError("Max")
-	}
-	if s.Warn == 0 {
-		return RequiredError("Warn")
-	}
-
-	// Backup
-	if err = backup(fileShadow); err != nil {
-		return
-	}
-
-	db, err := openDBFile(fileShadow, os.O_WRONLY|os.O_APPEND)
-	if err != nil {
-		return
-	}
-	defer func() {
-		if e := db.close(); e != nil && err == nil {
-			err = e
-		}
-	}()
+// AddAt adds a new shadowed user in the root directory.
+// If the key is not nil, generates a hashed password.
+func (s *Shadow) AddAt(r *Root, key []byte) error {
+	tx := r.beginAction("Shadow.Add")
 
-	if key != nil {
-		s.password, _ = config.crypter.Generate(key, nil)
-		if s.changed == _ENABLE_AGING {
-			s.setChange()
-		}
-	} else {
-		s.password = "*" // Password disabled.
+	if err := tx.AddShadow(s, key); err != nil {
+		tx.Rollback()
+		return err
 	}
-
-	_, err = db.file.WriteString(s.String())
-	return
+	return tx.Commit()
 }
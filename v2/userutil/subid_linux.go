@@ -0,0 +1,384 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type subIDField int
+
+// Field names for subordinate ids database.
+const (
+	SUB_NAME subIDField = 1 << iota
+
+	SUB_ALL
+)
+
+func (f subIDField) String() string {
+	switch f {
+	case SUB_NAME:
+		return "Name"
+	}
+	return "ALL"
+}
+
+// A SubID represents a range of subordinate ids that an user is allowed to use
+// in an user namespace, as stored in '/etc/subuid' and '/etc/subgid'.
+type SubID struct {
+	// Login name or user ID.
+	Name string
+
+	// First id of the range.
+	Start int
+
+	// Number of ids into the range.
+	Count int
+
+	isGroup bool
+}
+
+func (s *SubID) filename() string {
+	if s.isGroup {
+		return fileSubGID
+	}
+	return fileSubUID
+}
+
+func (s *SubID) String() string {
+	return fmt.Sprintf("%s:%d:%d\n", s.Name, s.Start, s.Count)
+}
+
+// parseSubID parses the row of a subordinate ids range.
+func parseSubID(row string, isGroup bool) (*SubID, error) {
+	s := &SubID{isGroup: isGroup}
+	filename := s.filename()
+
+	fields := strings.Split(row, ":")
+	if len(fields) != 3 {
+		return nil, rowError{filename, row}
+	}
+
+	var err error
+	s.Name = fields[0]
+	if s.Start, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, atoiError{filename, row, "Start"}
+	}
+	if s.Count, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, atoiError{filename, row, "Count"}
+	}
+	return s, nil
+}
+
+// lookUp parses the subordinate ids line searching a value into the field.
+// Returns nil if it is not found, or whether the line is not valid.
+func (s *SubID) lookUp(line string, f field, value interface{}) interface{} {
+	_field := f.(subIDField)
+
+	entry, err := parseSubID(line, s.isGroup)
+	if err != nil {
+		return nil
+	}
+
+	if SUB_NAME&_field != 0 && entry.Name == value.(string) {
+		return entry
+	} else if SUB_ALL&_field != 0 {
+		return entry
+	}
+	return nil
+}
+
+// == Lookup
+//
+
+// LookupSubUIDs looks up the ranges of subordinate user ids for the given user.
+func LookupSubUIDs(name string) ([]*SubID, error) { return defaultRoot.LookupSubUIDs(name) }
+
+// LookupSubGIDs looks up the ranges of subordinate group ids for the given user.
+func LookupSubGIDs(name string) ([]*SubID, error) { return defaultRoot.LookupSubGIDs(name) }
+
+// LookupSubUIDs looks up the ranges of subordinate user ids for the given user
+// in the root directory.
+func (r *Root) LookupSubUIDs(name string) ([]*SubID, error) {
+	return r.lookupSubIDs(name, false)
+}
+
+// LookupSubGIDs looks up the ranges of subordinate group ids for the given user
+// in the root directory.
+func (r *Root) LookupSubGIDs(name string) ([]*SubID, error) {
+	return r.lookupSubIDs(name, true)
+}
+
+// lookupSubIDs returns the ranges owned by the user name, or by its UID whether
+// the user exists, like in Tx.DelSubIDs.
+func (r *Root) lookupSubIDs(name string, isGroup bool) ([]*SubID, error) {
+	uid := ""
+	if u, err := r.LookupUser(name); err == nil {
+		uid = strconv.Itoa(u.UID)
+	} else if _, ok := err.(NoFoundError); !ok {
+		return nil, err
+	}
+
+	s := &SubID{isGroup: isGroup}
+	lines, err := r.loadRows(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*SubID
+	for _, line := range lines {
+		entry, err := parseSubID(line, isGroup)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Name == name || (uid != "" && entry.Name == uid) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, NoFoundError{r.join(s.filename()), SUB_NAME.String(), name}
+	}
+	return entries, nil
+}
+
+// == Editing
+//
+
+// AllocSubUIDs allocates a new range of subordinate user ids for the given user,
+// according to SUB_UID_MIN, SUB_UID_MAX and SUB_UID_COUNT.
+func AllocSubUIDs(name string) (*SubID, error) { return defaultRoot.AllocSubUIDs(name) }
+
+// AllocSubGIDs allocates a new range of subordinate group ids for the given user,
+// according to SUB_GID_MIN, SUB_GID_MAX and SUB_GID_COUNT.
+func AllocSubGIDs(name string) (*SubID, error) { return defaultRoot.AllocSubGIDs(name) }
+
+// AllocSubUIDs allocates a new range of subordinate user ids for the given user
+// in the root directory.
+func (r *Root) AllocSubUIDs(name string) (*SubID, error) { return r.allocSubIDs(name, false) }
+
+// AllocSubGIDs allocates a new range of subordinate group ids for the given user
+// in the root directory.
+func (r *Root) AllocSubGIDs(name string) (*SubID, error) { return r.allocSubIDs(name, true) }
+
+func (r *Root) allocSubIDs(name string, isGroup bool) (s *SubID, err error) {
+	action := "AllocSubUIDs"
+	if isGroup {
+		action = "AllocSubGIDs"
+	}
+	tx := r.beginAction(action)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if s, err = tx.AllocSubIDs(name, isGroup); err != nil {
+		return nil, err
+	}
+	return s, tx.Commit()
+}
+
+// AllocSubIDs stages a new range of subordinate ids for the given user; they are
+// group ids whether isGroup is true.
+// The range is the first free one with the size and limits set in the
+// configuration.
+func (tx *Tx) AllocSubIDs(name string, isGroup bool) (*SubID, error) {
+	tx.r.loadConfig()
+	conf := tx.r.config.login
+
+	if name == "" {
+		return nil, RequiredError("Name")
+	}
+
+	s := &SubID{Name: name, isGroup: isGroup}
+	minId, maxId, count := conf.SUB_UID_MIN, conf.SUB_UID_MAX, conf.SUB_UID_COUNT
+	keyCount := "SUB_UID_COUNT"
+	if isGroup {
+		minId, maxId, count = conf.SUB_GID_MIN, conf.SUB_GID_MAX, conf.SUB_GID_COUNT
+		keyCount = "SUB_GID_COUNT"
+	}
+	if count <= 0 {
+		return nil, &ConfigError{keyCount, "is not greater than 0"}
+	}
+
+	f, err := tx.load(s)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make([]*SubID, 0, len(f.lines))
+	for _, line := range f.lines {
+		rng, err := parseSubID(line, isGroup)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	// First range free.
+	start := minId
+	for _, rng := range ranges {
+		if start+count <= rng.Start {
+			break
+		}
+		if end := rng.Start + rng.Count; end > start {
+			start = end
+		}
+	}
+	if start+count-1 > maxId {
+		return nil, &IdRangeError{FirstId: minId, LastId: maxId, IsUser: !isGroup}
+	}
+
+	s.Start, s.Count = start, count
+	return s, tx.add(s)
+}
+
+// DelSubIDs removes all ranges of subordinate user and group ids for the given
+// user.
+func DelSubIDs(name string) error { return defaultRoot.DelSubIDs(name) }
+
+// DelSubIDs removes all ranges of subordinate user and group ids for the given
+// user in the root directory.
+func (r *Root) DelSubIDs(name string) error {
+	tx := r.beginAction("DelSubIDs")
+
+	if err := tx.DelSubIDs(name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DelSubIDs stages the removing of all ranges of subordinate ids for the given
+// user, in the files that exist. They are only handled with the file backend.
+//
+// The ranges are found by the user name, and by its UID whether the user exists.
+func (tx *Tx) DelSubIDs(name string) error {
+	uid := -1
+	if u, err := tx.LookupUser(name); err == nil {
+		uid = u.UID
+	} else if _, ok := err.(NoFoundError); !ok {
+		return err
+	}
+	return tx.delSubIDs(name, uid)
+}
+
+// delSubIDs stages the removing of the ranges of subordinate ids whose owner is
+// the user name or the uid; it is not used whether it is < 0.
+func (tx *Tx) delSubIDs(name string, uid int) error {
+	if tx.r.backend != nil {
+		return nil
+	}
+	for _, isGroup := range []bool{false, true} {
+		s := &SubID{isGroup: isGroup}
+
+		found, err := exist(tx.r.join(s.filename()))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		f, err := tx.load(s)
+		if err != nil {
+			return err
+		}
+		for i := f.findSubID(name, uid); i != -1; i = f.findSubID(name, uid) {
+			f.lines = append(f.lines[:i], f.lines[i+1:]...)
+			f.changed = true
+		}
+	}
+	return nil
+}
+
+// renameSubIDs stages the change of the user name in the ranges of subordinate
+// ids owned by the old name. The ranges owned by the UID are kept.
+func (tx *Tx) renameSubIDs(oldName, newName string) error {
+	if tx.r.backend != nil {
+		return nil
+	}
+	for _, isGroup := range []bool{false, true} {
+		s := &SubID{isGroup: isGroup}
+
+		found, err := exist(tx.r.join(s.filename()))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		f, err := tx.load(s)
+		if err != nil {
+			return err
+		}
+		for i, line := range f.lines {
+			rng, err := parseSubID(line, isGroup)
+			if err != nil {
+				return err
+			}
+			if rng.Name == oldName {
+				rng.Name = newName
+				f.lines[i] = strings.TrimSuffix(rng.String(), "\n")
+				f.changed = true
+			}
+		}
+	}
+	return nil
+}
+
+// allocSubIDsAuto stages the ranges of subordinate ids for a new user, like
+// "useradd(8)": only whether the files exist, the count of ids to allocate is
+// not 0, and the user has not ranges.
+func (tx *Tx) allocSubIDsAuto(u *User) error {
+	if tx.r.backend != nil {
+		return nil
+	}
+	conf := tx.r.config.login
+
+	for _, isGroup := range []bool{false, true} {
+		if (!isGroup && conf.SUB_UID_COUNT <= 0) || (isGroup && conf.SUB_GID_COUNT <= 0) {
+			continue
+		}
+		s := &SubID{isGroup: isGroup}
+
+		found, err := exist(tx.r.join(s.filename()))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		f, err := tx.load(s)
+		if err != nil {
+			return err
+		}
+		if f.findSubID(u.Name, u.UID) != -1 {
+			continue
+		}
+		if _, err = tx.AllocSubIDs(u.Name, isGroup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSubID returns the index of the first range of subordinate ids owned by
+// the user name or by the uid, or -1 if it is not found. The uid is not used
+// whether it is < 0.
+func (f *txFile) findSubID(name string, uid int) int {
+	if i := f.find(name); i != -1 || uid < 0 {
+		return i
+	}
+	return f.find(strconv.Itoa(uid))
+}
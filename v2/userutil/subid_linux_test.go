@@ -0,0 +1,153 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSubIDs(t *testing.T) {
+	r := newTestRoot(t)
+
+	if err := os.WriteFile(r.join(fileSubUID), []byte("root:100000:65536\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(r.join(fileSubGID), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddSystemUser(SYS_USER, "/", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	uids, err := r.LookupSubUIDs(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 1 || uids[0].Start != 165536 || uids[0].Count != 65536 {
+		t.Errorf("unexpected range of subordinate uids: %v", uids)
+	}
+	gids, err := r.LookupSubGIDs(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gids) != 1 || gids[0].Start != 100000 {
+		t.Errorf("unexpected range of subordinate gids: %v", gids)
+	}
+	if _, err = r.LookupSubUIDs(SYS_USER); err == nil {
+		t.Error("expected no range for a system user")
+	}
+
+	s, err := r.AllocSubUIDs(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Start != 231072 {
+		t.Errorf("expected to allocate since 231072, got %d", s.Start)
+	}
+
+	r.config.login.SUB_UID_MAX = 300000
+	_, err = r.AllocSubUIDs(USER2)
+	if _, ok := err.(*IdRangeError); !ok {
+		t.Errorf("expected to report IdRangeError, got %v", err)
+	}
+
+	if err = r.DelUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.LookupSubUIDs(USER); err == nil {
+		t.Error("expected to remove the subordinate uids")
+	}
+	if _, err = r.LookupSubGIDs(USER); err == nil {
+		t.Error("expected to remove the subordinate gids")
+	}
+}
+
+func TestSubIDsAuto(t *testing.T) {
+	r := newTestRoot(t)
+
+	// Ranges owned by the UID.
+	if err := os.WriteFile(r.join(fileSubUID), []byte("2000:100000:65536\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(r.join(fileSubGID), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defs := r.GetLoginDefs()
+	defs.SubGIDCount = 0
+	err := r.SetLoginDefs(defs, LD_SUB_GID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := r.NewUser(USER, 100)
+	u.UID = 2000
+	if _, err = u.AddAt(r); err != nil {
+		t.Fatal(err)
+	}
+	// The range by UID is found, and no one is allocated.
+	uids, err := r.LookupSubUIDs(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 1 || uids[0].Name != "2000" {
+		t.Errorf("expected only the range by UID, got %v", uids)
+	}
+	if _, err = r.LookupSubGIDs(USER); err == nil {
+		t.Error("expected to not allocate gids with SUB_GID_COUNT 0")
+	}
+	if _, err = r.AllocSubGIDs(USER); err == nil {
+		t.Error("expected to report an error with SUB_GID_COUNT 0")
+	}
+
+	if err = r.DelUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(r.join(fileSubUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Errorf("expected to remove the range by UID, got %q", b)
+	}
+}
+
+func TestSubIDsRename(t *testing.T) {
+	r := newTestRoot(t)
+
+	if err := os.WriteFile(r.join(fileSubUID), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(r.join(fileSubGID), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddUser(USER, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.ModifyUser(USER, &User{Name: USER2}, U_NAME, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupSubUIDs(USER2); err != nil {
+		t.Errorf("expected to rename the subordinate uids: %s", err)
+	}
+	if _, err := r.LookupSubGIDs(USER2); err != nil {
+		t.Errorf("expected to rename the subordinate gids: %s", err)
+	}
+
+	// A malformed row is reported.
+	if err := os.WriteFile(r.join(fileSubUID), []byte(USER2+":100000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupSubUIDs(USER2); err == nil {
+		t.Error("expected to report the row not valid")
+	}
+}
@@ -0,0 +1,609 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Directories of the configuration of "systemd-sysusers", from the highest
+// precedence to the lowest one.
+var dirsSysusers = []string{
+	"/etc/sysusers.d", "/run/sysusers.d", "/usr/local/lib/sysusers.d", "/usr/lib/sysusers.d",
+}
+
+// Defaults for the users created by "systemd-sysusers".
+const (
+	sysusersHome  = "/"
+	sysusersShell = "/usr/sbin/nologin"
+)
+
+// A SysusersEntry represents a line of a file of "sysusers.d(5)".
+type SysusersEntry struct {
+	// Type of line: 'u' (user and group), 'g' (group), 'm' (add user to group),
+	// or 'r' (range of ids to allocate).
+	Type byte
+
+	Name  string
+	ID    string // Empty whether it is not set ("-").
+	Gecos string
+	Home  string
+	Shell string
+
+	File string
+	Line int
+}
+
+func (e *SysusersEntry) String() string {
+	return fmt.Sprintf("%s:%d: %c %s %s", e.File, e.Line, e.Type, e.Name, e.ID)
+}
+
+// A SysusersResult represents the result of applying an entry.
+type SysusersResult struct {
+	Entry *SysusersEntry
+
+	// Whether the user, group or membership has been created; else, it was
+	// already present.
+	Created bool
+}
+
+// ParseSysusers parses the configuration in the format of "sysusers.d(5)".
+// The file name is only used to report the errors.
+func ParseSysusers(rd io.Reader, filename string) ([]*SysusersEntry, error) {
+	var entries []*SysusersEntry
+	scan := bufio.NewScanner(rd)
+
+	for nLine := 1; scan.Scan(); nLine++ {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields, err := splitSysusersLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, nLine, err)
+		}
+		if len(fields) < 2 || len(fields) > 6 {
+			return nil, fmt.Errorf("%s:%d: wrong number of fields", filename, nLine)
+		}
+		for len(fields) < 6 {
+			fields = append(fields, "-")
+		}
+		for i := range fields {
+			if fields[i] == "-" {
+				fields[i] = ""
+			}
+		}
+
+		// Modifier "!" of type 'u', to lock the account, is the default in
+		// this implementation.
+		typ := strings.TrimSuffix(fields[0], "!")
+		if len(typ) != 1 || !strings.Contains("ugmr", typ) {
+			return nil, fmt.Errorf("%s:%d: unknown type %q", filename, nLine, fields[0])
+		}
+
+		e := &SysusersEntry{
+			Type:  typ[0],
+			Name:  fields[1],
+			ID:    fields[2],
+			Gecos: fields[3],
+			Home:  fields[4],
+			Shell: fields[5],
+			File:  filename,
+			Line:  nLine,
+		}
+
+		switch e.Type {
+		case 'r':
+			if _, _, err = parseSysusersRange(e.ID); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", filename, nLine, err)
+			}
+		case 'm':
+			if e.Name == "" || e.ID == "" {
+				return nil, fmt.Errorf("%s:%d: user and group are required", filename, nLine)
+			}
+		default:
+			if e.Name == "" {
+				return nil, fmt.Errorf("%s:%d: name is required", filename, nLine)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// splitSysusersLine splits a line by white spaces, handling the quoted fields.
+func splitSysusersLine(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	inField := false
+
+	for i := 0; i < len(line); i++ {
+		c := rune(line[i])
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && i+1 < len(line) {
+				i++
+				field.WriteByte(line[i])
+			} else {
+				field.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inField = c, true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("the quote is not closed")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// parseSysusersRange parses a range of ids "min-max".
+func parseSysusersRange(s string) (min, max int, err error) {
+	i := strings.IndexByte(s, '-')
+	if i == -1 {
+		return 0, 0, fmt.Errorf("range not valid: %q", s)
+	}
+	if min, err = strconv.Atoi(s[:i]); err != nil {
+		return 0, 0, fmt.Errorf("range not valid: %q", s)
+	}
+	if max, err = strconv.Atoi(s[i+1:]); err != nil || max < min {
+		return 0, 0, fmt.Errorf("range not valid: %q", s)
+	}
+	return min, max, nil
+}
+
+// SysusersFiles returns the configuration files of "sysusers.d(5)" into the root
+// directory, sorted by name.
+// A file in '/etc/sysusers.d' overrides the one with the same name in
+// '/run/sysusers.d', which overrides the one in '/usr/local/lib/sysusers.d',
+// which overrides the one in '/usr/lib/sysusers.d'. A file linked to
+// '/dev/null' masks the other ones.
+func (r *Root) SysusersFiles() ([]string, error) {
+	found := make(map[string]string)
+
+	for _, dir := range dirsSysusers {
+		names, err := filepath.Glob(filepath.Join(r.join(dir), "*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			base := filepath.Base(name)
+			if _, ok := found[base]; ok {
+				continue
+			}
+			if dst, err := os.Readlink(name); err == nil && dst == os.DevNull {
+				found[base] = ""
+				continue
+			}
+			found[base] = name
+		}
+	}
+
+	bases := make([]string, 0, len(found))
+	for base, name := range found {
+		if name != "" {
+			bases = append(bases, base)
+		}
+	}
+	sort.Strings(bases)
+
+	files := make([]string, len(bases))
+	for i, base := range bases {
+		files[i] = found[base]
+	}
+	return files, nil
+}
+
+// ApplySysusers creates the system users and groups set in the configuration
+// files of "sysusers.d(5)", like "systemd-sysusers(8)".
+// See Root.ApplySysusers.
+func ApplySysusers(files ...string) ([]*SysusersResult, error) {
+	return defaultRoot.ApplySysusers(files...)
+}
+
+// ApplySysusers creates the system users and groups set in the configuration
+// files of "sysusers.d(5)" into the root directory.
+// If no file is given, they are used the ones returned by SysusersFiles.
+//
+// The groups are created first, then the users, and then the memberships;
+// every one is added like AddSystemGroup, AddSystemUser and AddUsersToGroup.
+// The new users have the passwd locked ("!*"). It is returned the result of
+// every entry, but the ranges; on error, they are returned the results of the
+// entries applied before of it, which are kept.
+func (r *Root) ApplySysusers(files ...string) ([]*SysusersResult, error) {
+	var err error
+
+	if len(files) == 0 {
+		if files, err = r.SysusersFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []*SysusersEntry
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		e, err := ParseSysusers(f, name)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	return r.applySysusers(entries)
+}
+
+// ApplySysusersEntries creates the system users and groups from the entries.
+func (r *Root) ApplySysusersEntries(entries []*SysusersEntry) ([]*SysusersResult, error) {
+	return r.applySysusers(entries)
+}
+
+func (r *Root) applySysusers(entries []*SysusersEntry) ([]*SysusersResult, error) {
+	r.loadConfig()
+	s := &sysusers{r: r}
+
+	for _, e := range entries {
+		if e.Type == 'r' {
+			min, max, _ := parseSysusersRange(e.ID)
+			s.ranges = append(s.ranges, [2]int{min, max})
+		}
+	}
+	err := s.apply(entries)
+
+	// Order of entries.
+	index := make(map[*SysusersEntry]int, len(entries))
+	for i, e := range entries {
+		index[e] = i
+	}
+	sort.SliceStable(s.results, func(i, j int) bool {
+		return index[s.results[i].Entry] < index[s.results[j].Entry]
+	})
+	return s.results, err
+}
+
+// apply applies the entries by type, recording their results.
+func (s *sysusers) apply(entries []*SysusersEntry) error {
+	for _, typ := range []byte{'g', 'u', 'm'} {
+		for _, e := range entries {
+			if e.Type != typ && !(typ == 'g' && e.Type == 'u') {
+				continue
+			}
+			var created bool
+			var err error
+
+			switch {
+			case typ == 'g' && e.Type == 'g':
+				created, err = s.addGroup(e.Name, e.ID)
+			case typ == 'g': // Group of an user.
+				_, err = s.addUserGroup(e)
+			case typ == 'u':
+				created, err = s.addUser(e)
+			case typ == 'm':
+				created, err = s.addMember(e)
+			}
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", e.File, e.Line, err)
+			}
+			if typ == e.Type {
+				s.results = append(s.results, &SysusersResult{e, created})
+			}
+		}
+	}
+	return nil
+}
+
+// sysusers keeps the state to apply the entries of "sysusers.d(5)".
+type sysusers struct {
+	r       *Root
+	ranges  [][2]int // Ranges of ids to allocate.
+	results []*SysusersResult
+
+	userGroups map[string]bool // Groups created for users.
+}
+
+// exist reports whether the user or group exists.
+func (s *sysusers) exist(_row row, name string) (bool, error) {
+	var err error
+	if _, ok := _row.(*Group); ok {
+		_, err = s.r.LookupGroup(name)
+	} else {
+		_, err = s.r.LookupUser(name)
+	}
+	return noFound(err)
+}
+
+// isFree reports whether the id is not used by another user or group.
+func (s *sysusers) isFree(_row row, id int) (bool, error) {
+	var err error
+	if _, ok := _row.(*Group); ok {
+		_, err = s.r.LookupGID(id)
+	} else {
+		_, err = s.r.LookupUID(id)
+	}
+	found, err := noFound(err)
+	return !found, err
+}
+
+// noFound turns the error of a lookup into whether the entry was found.
+func noFound(err error) (found bool, _ error) {
+	if err == nil {
+		return true, nil
+	}
+	if _, ok := err.(NoFoundError); ok {
+		return false, nil
+	}
+	return false, err
+}
+
+// nextId returns a free id into the ranges, or -1 to use the system range.
+func (s *sysusers) nextId(_row row, name string) (int, error) {
+	if len(s.ranges) == 0 {
+		return -1, nil
+	}
+
+	used := make(map[int]bool)
+	var err error
+	if _, ok := _row.(*Group); ok {
+		_, err = s.r.FindGroups(func(g *Group) bool {
+			used[g.GID] = true
+			return false
+		})
+	} else {
+		_, err = s.r.FindUsers(func(u *User) bool {
+			used[u.UID] = true
+			return false
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rng := range s.ranges {
+		if id, ok := allocId(used, rng[0], rng[1], name, IdAlloc{Strategy: ID_LOWEST}); ok {
+			return id, nil
+		}
+	}
+	return -1, nil
+}
+
+// parseID returns the id set in the field, which is a number or the path of a
+// file whose owner is used. Returns -1 whether it is not set.
+func (s *sysusers) parseID(field string, isGroup bool) (int, error) {
+	if field == "" {
+		return -1, nil
+	}
+	if field[0] != '/' {
+		return strconv.Atoi(field)
+	}
+
+	info, err := os.Stat(s.r.join(field))
+	if err != nil {
+		return 0, err
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if isGroup {
+		return int(st.Gid), nil
+	}
+	return int(st.Uid), nil
+}
+
+// addGroup adds a system group, whether it does not exist.
+func (s *sysusers) addGroup(name, field string) (created bool, err error) {
+	if found, err := s.exist(&Group{}, name); err != nil || found {
+		return false, err
+	}
+
+	gid, err := s.parseID(field, true)
+	if err != nil {
+		return false, err
+	}
+	return true, s.createGroup(name, gid)
+}
+
+// createGroup creates a system group, using the given gid whether it is free.
+func (s *sysusers) createGroup(name string, gid int) error {
+	g := NewSystemGroup(name)
+
+	if gid >= 0 {
+		free, err := s.isFree(g, gid)
+		if err != nil {
+			return err
+		}
+		if free {
+			g.GID = gid
+		}
+	}
+	if g.GID < 0 {
+		id, err := s.nextId(g, name)
+		if err != nil {
+			return err
+		}
+		g.GID = id
+	}
+
+	_, err := s.r.addGroup(g)
+	return err
+}
+
+// userIDs returns the user id and the group set in the field of an user,
+// "uid", "uid:gid" or "uid:group".
+func (s *sysusers) userIDs(field string) (uid int, group string, err error) {
+	if i := strings.IndexByte(field, ':'); i != -1 {
+		field, group = field[:i], field[i+1:]
+		if field == "-" {
+			field = ""
+		}
+	}
+	if uid, err = s.parseID(field, false); err != nil {
+		return 0, "", err
+	}
+	return uid, group, nil
+}
+
+// addUserGroup adds the group with the same name than the user, whether there
+// is not another group set in the field of ids, and the user does not exist.
+func (s *sysusers) addUserGroup(e *SysusersEntry) (created bool, err error) {
+	uid, group, err := s.userIDs(e.ID)
+	if err != nil || group != "" {
+		return false, err
+	}
+	if found, err := s.exist(&User{}, e.Name); err != nil || found {
+		return false, err
+	}
+	if found, err := s.exist(&Group{}, e.Name); err != nil || found {
+		return false, err
+	}
+
+	// The group id is the same than the user id, whether it is possible.
+	if err = s.createGroup(e.Name, uid); err != nil {
+		return false, err
+	}
+	if s.userGroups == nil {
+		s.userGroups = make(map[string]bool)
+	}
+	s.userGroups[e.Name] = true
+	return true, nil
+}
+
+// addUser adds a system user, whether it does not exist.
+func (s *sysusers) addUser(e *SysusersEntry) (created bool, err error) {
+	if found, err := s.exist(&User{}, e.Name); err != nil || found {
+		return false, err
+	}
+
+	uid, group, err := s.userIDs(e.ID)
+	if err != nil {
+		return false, err
+	}
+	if group == "" {
+		group = e.Name
+	}
+
+	// The numeric group has to exist, like the named one.
+	var gr *Group
+	if gid, err := strconv.Atoi(group); err == nil {
+		if gr, err = s.r.LookupGID(gid); err != nil {
+			return false, err
+		}
+	} else if gr, err = s.r.LookupGroup(group); err != nil {
+		return false, err
+	}
+
+	home, shell := e.Home, e.Shell
+	if home == "" {
+		home = sysusersHome
+	}
+	if shell == "" {
+		shell = sysusersShell
+		if uid == 0 {
+			shell = "/bin/sh"
+		}
+	}
+	u := NewSystemUser(e.Name, home, gr.GID)
+	u.Gecos, u.Shell = e.Gecos, shell
+
+	// The user id is the same than the group id, whether the group was created
+	// for the user and there is not another id set.
+	if uid < 0 && s.userGroups[e.Name] {
+		uid = gr.GID
+	}
+	if uid >= 0 {
+		free, err := s.isFree(u, uid)
+		if err != nil {
+			return false, err
+		}
+		if free {
+			u.UID = uid
+		}
+	}
+	if u.UID < 0 {
+		if u.UID, err = s.nextId(u, e.Name); err != nil {
+			return false, err
+		}
+	}
+
+	tx := s.r.beginAction("AddSystemUser")
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Like "systemd-sysusers", the passwd is locked and disabled, into the same
+	// transaction.
+	sh := s.r.NewShadow(u.Name)
+	if err = tx.AddShadow(sh, nil); err != nil {
+		return false, err
+	}
+	sh.password = string(lockChar) + sh.password
+	if err = tx.EditShadow(u.Name, sh); err != nil {
+		return false, err
+	}
+	if _, err = tx.AddUser(u); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// addMember adds the user to the group, creating both whether they do not exist.
+func (s *sysusers) addMember(e *SysusersEntry) (created bool, err error) {
+	userEntry := &SysusersEntry{Type: 'u', Name: e.Name}
+	if _, err = s.addUserGroup(userEntry); err != nil {
+		return false, err
+	}
+	if _, err = s.addUser(userEntry); err != nil {
+		return false, err
+	}
+	if _, err = s.addGroup(e.ID, ""); err != nil {
+		return false, err
+	}
+
+	g, err := s.r.LookupGroup(e.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range g.UserList {
+		if v == e.Name {
+			return false, nil
+		}
+	}
+	return true, s.r.AddUsersToGroup(e.ID, e.Name)
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSysusers(t *testing.T) {
+	conf := `# Comment
+u     httpd  404              "HTTP User"  /var/www
+u!    locked -                -            -  /bin/sh
+g     input  -
+m     httpd  input
+r     -      500-550
+`
+	entries, err := ParseSysusers(strings.NewReader(conf), "test.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+
+	e := entries[0]
+	if e.Type != 'u' || e.Name != "httpd" || e.ID != "404" || e.Gecos != "HTTP User" ||
+		e.Home != "/var/www" || e.Shell != "" || e.Line != 2 {
+		t.Errorf("entry not expected: %+v", e)
+	}
+	if e = entries[1]; e.Type != 'u' || e.ID != "" || e.Shell != "/bin/sh" {
+		t.Errorf("entry not expected: %+v", e)
+	}
+	if e = entries[3]; e.Type != 'm' || e.Name != "httpd" || e.ID != "input" {
+		t.Errorf("entry not expected: %+v", e)
+	}
+
+	for _, line := range []string{
+		"x foo",
+		"u",
+		`u foo "bar`,
+		"m foo",
+		"r - 600-500",
+	} {
+		if _, err = ParseSysusers(strings.NewReader(line), "bad.conf"); err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+}
+
+func TestApplySysusers(t *testing.T) {
+	r := newTestRoot(t)
+
+	writeConf := func(dir, name, data string) {
+		dir = r.join(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConf("/usr/lib/sysusers.d", "a.conf", "u ignored -\n")
+	writeConf("/usr/lib/sysusers.d", "b.conf", "u masked -\n")
+	writeConf("/usr/lib/sysusers.d", "c.conf", `u     httpd  404   "HTTP User"  /var/www
+u     daemon -
+g     input  -
+m     httpd  input
+m     daemon input
+r     -      500-550
+`)
+	writeConf("/usr/local/lib/sysusers.d", "a.conf", "u ignored -\n")
+	writeConf("/usr/local/lib/sysusers.d", "d.conf", "g local -\n")
+	writeConf("/etc/sysusers.d", "a.conf", "g override 450\n")
+	if err := os.MkdirAll(r.join("/run/sysusers.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.DevNull, r.join("/run/sysusers.d/b.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := r.SysusersFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0] != r.join("/etc/sysusers.d/a.conf") ||
+		files[1] != r.join("/usr/lib/sysusers.d/c.conf") ||
+		files[2] != r.join("/usr/local/lib/sysusers.d/d.conf") {
+		t.Fatalf("files not expected: %v", files)
+	}
+
+	results, err := r.ApplySysusers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := make(map[string]bool)
+	for _, res := range results {
+		created[string(res.Entry.Type)+" "+res.Entry.Name+" "+res.Entry.ID] = res.Created
+	}
+	for k, v := range map[string]bool{
+		"g override 450": true,
+		"g local ":       true,
+		"u httpd 404":    true,
+		"u daemon ":      false,
+		"g input ":       true,
+		"m httpd input":  true,
+		"m daemon input": true,
+	} {
+		if got, ok := created[k]; !ok || got != v {
+			t.Errorf("%q: expected created %v, got %v (found %v)", k, v, got, ok)
+		}
+	}
+
+	u, err := r.LookupUser("httpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.UID != 404 || u.GID != 404 || u.Gecos != "HTTP User" || u.Dir != "/var/www" ||
+		u.Shell != sysusersShell {
+		t.Errorf("user not expected: %+v", u)
+	}
+	if s, err := r.LookupShadow("httpd"); err != nil {
+		t.Error(err)
+	} else if s.password != "!*" {
+		t.Errorf("expected the passwd locked, got %q", s.password)
+	}
+	if g, err := r.LookupGroup("httpd"); err != nil || g.GID != 404 {
+		t.Errorf("expected group with GID 404, got %v (%v)", g, err)
+	}
+
+	g, err := r.LookupGroup("input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.GID < 500 || g.GID > 550 {
+		t.Errorf("expected GID into the range 500-550, got %d", g.GID)
+	}
+	if strings.Join(g.UserList, ",") != "httpd,daemon" {
+		t.Errorf("members not expected: %v", g.UserList)
+	}
+	if _, err = r.LookupGShadow("input"); err != nil {
+		t.Error(err)
+	}
+	if _, err = r.LookupUser("masked"); err == nil {
+		t.Error("expected to mask the file")
+	}
+
+	// Second run: nothing is created.
+	if results, err = r.ApplySysusers(); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Created {
+			t.Errorf("%s: expected to exist", res.Entry)
+		}
+	}
+}
+
+func TestApplySysusersGID(t *testing.T) {
+	r := newTestRoot(t)
+
+	// The group set by id has to exist.
+	_, err := r.ApplySysusersEntries([]*SysusersEntry{
+		{Type: 'u', Name: "httpd", ID: "404:4040", File: "test.conf", Line: 1},
+	})
+	var e NoFoundError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected to report NoFoundError, got %v", err)
+	}
+	if _, err = r.LookupUser("httpd"); err == nil {
+		t.Error("expected to not add the user")
+	}
+
+	if _, err = r.ApplySysusersEntries([]*SysusersEntry{
+		{Type: 'g', Name: "www", ID: "4040", File: "test.conf", Line: 1},
+		{Type: 'u', Name: "httpd", ID: "404:4040", File: "test.conf", Line: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := r.LookupUser("httpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.UID != 404 || u.GID != 4040 {
+		t.Errorf("expected ids 404:4040, got %d:%d", u.UID, u.GID)
+	}
+}
@@ -0,0 +1,672 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// A Tx represents a transaction on the databases of users and groups.
+//
+// The changes are staged in memory, and they are not written until the call
+// to Commit, which replaces all files modified or none of them.
+//
+// The databases are locked since the first file is read until the transaction
+// is finished, so there is to call to Commit or Rollback.
+type Tx struct {
+	r     *Root
+	files map[string]*txFile // key: path of the file
+	lock  *dbLock
+	done  bool
+
+	action  string     // Function which started the transaction, for the audit.
+	changes []txChange // Rows staged by add and _edit, to could undo them.
+
+	unlockStore func() error // Lock got from a backend.
+}
+
+// A txFile represents the content of a database file into a transaction.
+type txFile struct {
+	name    string
+	row     row
+	orig    []byte   // Content before of the transaction.
+	lines   []string // Rows without the new line character.
+	changed bool
+
+	tmp   string // Temporary file used at committing.
+	store Store  // Store of a backend, instead of the file.
+}
+
+// Begin starts a transaction on the databases of the running system.
+func Begin() *Tx { return defaultRoot.Begin() }
+
+// Begin starts a transaction on the databases in the root directory.
+//
+// The transactions must not be nested: while a transaction holds the lock, the
+// methods of Root which write, or another transaction, called from the same
+// goroutine wait for it, until they report LockTimeoutError.
+func (r *Root) Begin() *Tx {
+	return &Tx{r: r, files: make(map[string]*txFile, 4)}
+}
+
+// beginAction starts a transaction for the named function, which is set in the
+// events of the audit.
+func (r *Root) beginAction(action string) *Tx {
+	tx := r.Begin()
+	tx.action = action
+	return tx
+}
+
+// load returns the content of the database file for the row, reading it at
+// the first time that it is used.
+func (tx *Tx) load(_row row) (*txFile, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if _, ok := _row.(*GShadow); ok && !tx.r.hasGshadow() {
+		return nil, ErrGshadow
+	}
+
+	filename := tx.r.join(_row.filename())
+	if f, ok := tx.files[filename]; ok {
+		return f, nil
+	}
+	if tx.r.backend != nil {
+		return tx.loadStore(_row, filename)
+	}
+
+	var err error
+	if tx.lock == nil {
+		if tx.lock, err = tx.r.lockPwd(); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.lock.lockFile(filename); err != nil {
+		return nil, err
+	}
+
+	dbf, err := openDBFile(filename, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer dbf.close()
+
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, dbf.rd); err != nil {
+		return nil, err
+	}
+
+	f := &txFile{name: filename, row: _row, orig: buf.Bytes(), lines: splitLines(buf.Bytes())}
+
+	tx.files[filename] = f
+	return f, nil
+}
+
+// loadStore is like load, but getting the rows from the store of the backend.
+func (tx *Tx) loadStore(_row row, filename string) (*txFile, error) {
+	s := storeOf(tx.r.backend, _row)
+	if s == nil {
+		return nil, ErrNoStore
+	}
+
+	if tx.unlockStore == nil {
+		unlock, err := tx.r.backend.Lock()
+		if err != nil {
+			return nil, err
+		}
+		tx.unlockStore = unlock
+	}
+
+	lines, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	f := &txFile{name: filename, row: _row, orig: joinLines(lines), lines: lines, store: s}
+
+	tx.files[filename] = f
+	return f, nil
+}
+
+// find returns the index of the row for the given user/group name, or -1 if it
+// is not found.
+func (f *txFile) find(name string) int {
+	prefix := name + ":"
+
+	for i, line := range f.lines {
+		if strings.HasPrefix(line, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookUp looks for the first row staged whose field matches with the value.
+// Returns nil if it is not found.
+func (tx *Tx) lookUp(_row row, _field field, value interface{}) (interface{}, error) {
+	f, err := tx.load(_row)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range f.lines {
+		if entry := _row.lookUp(line, _field, value); entry != nil {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// get is like lookUp, but it reports NoFoundError whether the row is not found.
+func (tx *Tx) get(_row row, _field field, value interface{}) (interface{}, error) {
+	entry, err := tx.lookUp(_row, _field, value)
+	if err == nil && entry == nil {
+		err = NoFoundError{tx.r.join(_row.filename()), _field.String(), value}
+	}
+	return entry, err
+}
+
+// LookupUser looks up an user by name, seeing the changes staged.
+func (tx *Tx) LookupUser(name string) (*User, error) {
+	entry, err := tx.get(&User{}, U_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*User), nil
+}
+
+// LookupShadow looks up a shadowed user by name, seeing the changes staged.
+func (tx *Tx) LookupShadow(name string) (*Shadow, error) {
+	entry, err := tx.get(&Shadow{}, S_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*Shadow), nil
+}
+
+// LookupGroup looks up a group by name, seeing the changes staged.
+func (tx *Tx) LookupGroup(name string) (*Group, error) {
+	entry, err := tx.get(&Group{}, G_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*Group), nil
+}
+
+// LookupGShadow looks up a shadowed group by name, seeing the changes staged.
+func (tx *Tx) LookupGShadow(name string) (*GShadow, error) {
+	entry, err := tx.get(&GShadow{}, GS_NAME, name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.(*GShadow), nil
+}
+
+// add appends a new row.
+func (tx *Tx) add(_row row) error {
+	f, err := tx.load(_row)
+	if err != nil {
+		return err
+	}
+
+	tx.changes = append(tx.changes, txChange{f: f, i: len(f.lines), changed: f.changed})
+	f.lines = append(f.lines, strings.TrimSuffix(_row.String(), "\n"))
+	f.changed = true
+	return nil
+}
+
+func (tx *Tx) edit(name string, _row row) error { return tx._edit(name, _row, false) }
+
+func (tx *Tx) del(name string, _row row) error { return tx._edit(name, _row, true) }
+
+// _edit replaces the row for the given user/group name.
+// If remove is true, it removes the row.
+func (tx *Tx) _edit(name string, _row row, remove bool) error {
+	f, err := tx.load(_row)
+	if err != nil {
+		return err
+	}
+
+	i := f.find(name)
+	if i == -1 {
+		return nil
+	}
+
+	tx.changes = append(tx.changes, txChange{f, i, f.lines[i], remove, f.changed})
+	if remove {
+		f.lines = append(f.lines[:i], f.lines[i+1:]...)
+	} else {
+		f.lines[i] = strings.TrimSuffix(_row.String(), "\n")
+	}
+	f.changed = true
+	return nil
+}
+
+// == Staging
+//
+
+// AddUser stages a new user.
+// Whether UID is < 0, it will choose the first id available in the range set
+// in the configuration.
+//
+// For users that are not of system, it is also allocated a range of subordinate
+// user and group ids, like "useradd(8)": whether their files exist, and
+// SUB_UID_COUNT and SUB_GID_COUNT are not 0 in the configuration.
+func (tx *Tx) AddUser(u *User) (uid int, err error) {
+	r := tx.r
+	r.loadConfig()
+
+	f, err := tx.load(u)
+	if err != nil {
+		return 0, err
+	}
+	if f.find(u.Name) != -1 {
+		return 0, ErrUserExist
+	}
+
+	if u.Name == "" {
+		return 0, RequiredError("Name")
+	}
+	if err = r.ValidateName(u.Name, u.addSystemUser); err != nil {
+		return 0, err
+	}
+	if u.Dir == "" {
+		return 0, RequiredError("Dir")
+	}
+	if u.Dir == r.config.useradd.HOME {
+		return 0, HomeError(r.config.useradd.HOME)
+	}
+	if u.Shell == "" {
+		return 0, RequiredError("Shell")
+	}
+	if err = u.checkFields(U_GID | U_GECOS | U_DIR | U_SHELL); err != nil {
+		return 0, err
+	}
+
+	if u.UID < 0 {
+		a := r.idAlloc
+		if u.idAlloc != nil {
+			a = *u.idAlloc
+		}
+		if u.UID, err = tx.nextId(u, u.addSystemUser, u.Name, a); err != nil {
+			return 0, err
+		}
+	} else {
+		// Check if Id is unique.
+		entry, err := tx.lookUp(u, U_UID, u.UID)
+		if err != nil {
+			return 0, err
+		}
+		if entry != nil {
+			return 0, IdUsedError(u.UID)
+		}
+	}
+
+	u.password = "x"
+
+	if err = tx.add(u); err != nil {
+		return 0, err
+	}
+	if !u.addSystemUser {
+		if err = tx.allocSubIDsAuto(u); err != nil {
+			return 0, err
+		}
+	}
+	return u.UID, nil
+}
+
+// AddShadow stages a new shadowed user.
+// If the key is not nil, generates a hashed password.
+func (tx *Tx) AddShadow(s *Shadow, key []byte) error {
+	r := tx.r
+	r.loadConfig()
+
+	f, err := tx.load(s)
+	if err != nil {
+		return err
+	}
+	if f.find(s.Name) != -1 {
+		return ErrUserExist
+	}
+
+	if s.Name == "" {
+		return RequiredError("Name")
+	}
+	if s.Max == 0 {
+		return RequiredError("Max")
+	}
+	if s.Warn == 0 {
+		return RequiredError("Warn")
+	}
+
+	if key != nil {
+		if s.password, err = r.hashPasswd(key, 0); err != nil {
+			return err
+		}
+		if s.changed == _ENABLE_AGING {
+			s.setChange()
+		}
+	} else {
+		s.password = "*" // Password disabled.
+	}
+
+	return tx.add(s)
+}
+
+// AddGroup stages a new group.
+// Whether GID is < 0, it will choose the first id available in the range set
+// in the configuration.
+func (tx *Tx) AddGroup(g *Group) (gid int, err error) {
+	tx.r.loadConfig()
+
+	f, err := tx.load(g)
+	if err != nil {
+		return 0, err
+	}
+	if f.find(g.Name) != -1 {
+		return 0, ErrGroupExist
+	}
+
+	if g.Name == "" {
+		return 0, RequiredError("Name")
+	}
+	if err = tx.r.ValidateName(g.Name, g.addSystemGroup); err != nil {
+		return 0, err
+	}
+
+	if g.GID < 0 {
+		a := tx.r.idAlloc
+		if g.idAlloc != nil {
+			a = *g.idAlloc
+		}
+		if g.GID, err = tx.nextId(g, g.addSystemGroup, g.Name, a); err != nil {
+			return 0, err
+		}
+	} else {
+		// Check if Id is unique.
+		entry, err := tx.lookUp(g, G_GID, g.GID)
+		if err != nil {
+			return 0, err
+		}
+		if entry != nil {
+			return 0, IdUsedError(g.GID)
+		}
+	}
+
+	g.password = "x"
+
+	return g.GID, tx.add(g)
+}
+
+// AddGShadow stages a new shadowed group.
+// If the key is not nil, generates a hashed password.
+func (tx *Tx) AddGShadow(gs *GShadow, key []byte) error {
+	r := tx.r
+	r.loadConfig()
+
+	f, err := tx.load(gs)
+	if err != nil {
+		return err
+	}
+	if f.find(gs.Name) != -1 {
+		return ErrGroupExist
+	}
+
+	if gs.Name == "" {
+		return RequiredError("Name")
+	}
+
+	if key != nil {
+		if gs.password, err = r.hashPasswd(key, 0); err != nil {
+			return err
+		}
+	} else {
+		gs.password = "*" // Password disabled.
+	}
+
+	return tx.add(gs)
+}
+
+// EditUser stages the replacement of the user with the given name.
+func (tx *Tx) EditUser(name string, u *User) error { return tx.edit(name, u) }
+
+// EditShadow stages the replacement of the shadowed user with the given name.
+func (tx *Tx) EditShadow(name string, s *Shadow) error { return tx.edit(name, s) }
+
+// EditGroup stages the replacement of the group with the given name.
+func (tx *Tx) EditGroup(name string, g *Group) error { return tx.edit(name, g) }
+
+// EditGShadow stages the replacement of the shadowed group with the given name.
+func (tx *Tx) EditGShadow(name string, gs *GShadow) error { return tx.edit(name, gs) }
+
+// DelUser stages the removing of an user from both user and shadow files, and
+// her ranges of subordinate ids.
+func (tx *Tx) DelUser(name string) error {
+	uid := -1
+	if u, err := tx.LookupUser(name); err == nil {
+		uid = u.UID
+	} else if _, ok := err.(NoFoundError); !ok {
+		return err
+	}
+
+	if err := tx.del(name, &User{}); err != nil {
+		return err
+	}
+	if err := tx.del(name, &Shadow{}); err != nil {
+		return err
+	}
+	return tx.delSubIDs(name, uid)
+}
+
+// DelGroup stages the removing of a group from both group and gshadow files.
+func (tx *Tx) DelGroup(name string) error {
+	if err := tx.del(name, &Group{}); err != nil {
+		return err
+	}
+	if tx.r.hasGshadow() {
+		return tx.del(name, &GShadow{})
+	}
+	return nil
+}
+
+// == Commit
+//
+
+// Rollback discards the changes staged, and releases the locks.
+func (tx *Tx) Rollback() error {
+	tx.done = true
+	tx.files = nil
+
+	return tx.unlock()
+}
+
+// unlock releases the locks on the databases.
+func (tx *Tx) unlock() error {
+	if tx.unlockStore != nil {
+		err := tx.unlockStore()
+		tx.unlockStore = nil
+		return err
+	}
+	if tx.lock == nil {
+		return nil
+	}
+	err := tx.lock.unlock()
+	tx.lock = nil
+	return err
+}
+
+// Commit writes the changes staged.
+//
+// Every file modified is backed-up and written to a temporary file which is
+// synced to disk, and then they are renamed to the original names. If any step
+// fails, the files already replaced are restored to their original content.
+// At the end, the locks are released, and the changes are sent to the audit
+// sink, if any.
+func (tx *Tx) Commit() (err error) {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	var events []*AuditEvent
+	defer func() {
+		if err == nil && len(events) != 0 {
+			tx.r.emitAudit(events)
+		}
+	}()
+	defer func() {
+		if e := tx.unlock(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	files := make([]*txFile, 0, len(tx.files))
+	for _, f := range tx.files {
+		if f.changed {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	if tx.r.audit != nil {
+		events = auditEvents(files)
+		for _, ev := range events {
+			ev.Action = tx.action
+		}
+	}
+
+	if tx.r.backend != nil {
+		return saveStores(files)
+	}
+
+	defer func() {
+		for _, f := range files {
+			if f.tmp != "" {
+				os.Remove(f.tmp)
+			}
+		}
+	}()
+
+	for _, f := range files {
+		if err = backup(f.name); err != nil {
+			return err
+		}
+
+		if f.tmp, err = writeTemp(f.name, joinLines(f.lines)); err != nil {
+			return err
+		}
+	}
+
+	for i, f := range files {
+		if err = os.Rename(f.tmp, f.name); err != nil {
+			if e := restore(files[:i]); e != nil {
+				return fmt.Errorf("%w; could not restore files: %s", err, e)
+			}
+			return err
+		}
+		f.tmp = ""
+	}
+
+	for _, f := range files {
+		if err = syncDir(filepath.Dir(f.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveStores saves the rows of the files into the stores of the backend. If any
+// one fails, the stores already saved are restored to their original rows.
+func saveStores(files []*txFile) error {
+	for i, f := range files {
+		if err := f.store.Save(f.lines); err != nil {
+			for _, f := range files[:i] {
+				if e := f.store.Save(splitLines(f.orig)); e != nil {
+					return fmt.Errorf("%w; could not restore stores: %s", err, e)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// restore writes the original content of the files.
+func restore(files []*txFile) (err error) {
+	for _, f := range files {
+		if f.tmp, err = writeTemp(f.name, f.orig); err != nil {
+			return err
+		}
+		if err = os.Rename(f.tmp, f.name); err != nil {
+			return err
+		}
+		f.tmp = ""
+	}
+	return nil
+}
+
+// writeTemp writes b to a temporary file created in the directory of the named
+// file, with its same permissions and owner. Returns the temporary file name.
+func writeTemp(filename string, b []byte) (tmpFile string, err error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = f.Chmod(info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err = f.Chown(int(st.Uid), int(st.Gid)); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err = f.Write(b); err != nil {
+		return "", err
+	}
+	if err = f.Sync(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// syncDir commits to disk the entries of the named directory.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// == Errors
+//
+
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
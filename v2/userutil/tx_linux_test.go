@@ -0,0 +1,117 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	r := newTestRoot(t)
+
+	tx := r.Begin()
+	gid, err := tx.AddGroup(NewGroup(GROUP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, _ := r.NewGShadow(GROUP)
+	if err = tx.AddGShadow(gs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.AddShadow(r.NewShadow(USER), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.AddUser(r.NewUser(USER, gid)); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.AddUsersToGroup(GROUP, USER); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is written until the commit.
+	if _, err = r.LookupUser(USER); err == nil {
+		t.Fatal("user written before of commit")
+	}
+	if _, err = tx.LookupUser(USER); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != ErrTxDone {
+		t.Error("expected to report ErrTxDone")
+	}
+
+	if _, err = r.LookupShadow(USER); err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.LookupGroup(GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.UserList) != 1 || g.UserList[0] != USER {
+		t.Errorf("expected to get the member %q, got %v", USER, g.UserList)
+	}
+
+	// Rollback
+	tx = r.Begin()
+	if err = tx.DelUser(USER); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	if _, err = r.LookupUser(USER); err != nil {
+		t.Fatal("expected to keep the user after of rollback")
+	}
+}
+
+func TestTxRestore(t *testing.T) {
+	r := newTestRoot(t)
+
+	doBackup := DO_BACKUP
+	DO_BACKUP = false
+	defer func() { DO_BACKUP = doBackup }()
+
+	fGroup := r.join(fileGroup)
+	orig, err := os.ReadFile(fGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := r.Begin()
+	if _, err = tx.AddGroup(NewGroup(GROUP)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.AddUser(r.NewUser(USER, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file of users can not be replaced; the group one is renamed before.
+	fUser := r.join(fileUser)
+	if err = os.Remove(fUser); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(fUser, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tx.Commit(); err == nil {
+		t.Fatal("expected to fail at committing")
+	}
+
+	got, err := os.ReadFile(fGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, orig) {
+		t.Errorf("expected to restore the file %q\n%s", fGroup, got)
+	}
+}
@@ -96,17 +96,25 @@ type User struct {
 	Shell string
 
 	addSystemUser bool
+	idAlloc       *IdAlloc
+
+	createHome bool
+	skel       string
 }
 
 // NewUser returns a new User with both fields "Dir" and "Shell" got from
 // the system configuration.
-func NewUser(name string, gid int) *User {
-	loadConfig()
+func NewUser(name string, gid int) *User { return defaultRoot.NewUser(name, gid) }
+
+// NewUser returns a new User with both fields "Dir" and "Shell" got from
+// the configuration in the root directory.
+func (r *Root) NewUser(name string, gid int) *User {
+	r.loadConfig()
 
 	return &User{
 		Name:  name,
-		Dir:   path.Join(config.useradd.HOME, name),
-		Shell: config.useradd.SHELL,
+		Dir:   path.Join(r.config.useradd.HOME, name),
+		Shell: r.config.useradd.SHELL,
 		UID:   -1,
 		GID:   gid,
 	}
@@ -223,8 +231,14 @@ func (*User) lookUp(line string, f field, value interface{}) interface{} {
 }
 
 // LookupUID looks up an user by user ID.
-func LookupUID(uid int) (*User, error) {
-	entries, err := LookupInUser(U_UID, uid, 1)
+func LookupUID(uid int) (*User, error) { return defaultRoot.LookupUID(uid) }
+
+// LookupUID looks up an user by user ID in the root directory.
+func (r *Root) LookupUID(uid int) (*User, error) {
+	if r.cache != nil {
+		return r.cache.LookupUID(uid)
+	}
+	entries, err := r.LookupInUser(U_UID, uid, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -233,8 +247,14 @@ func LookupUID(uid int) (*User, error) {
 }
 
 // LookupUser looks up an user by name.
-func LookupUser(name string) (*User, error) {
-	entries, err := LookupInUser(U_NAME, name, 1)
+func LookupUser(name string) (*User, error) { return defaultRoot.LookupUser(name) }
+
+// LookupUser looks up an user by name in the root directory.
+func (r *Root) LookupUser(name string) (*User, error) {
+	if r.cache != nil {
+		return r.cache.LookupUser(name)
+	}
+	entries, err := r.LookupInUser(U_NAME, name, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -249,7 +269,12 @@ func LookupUser(name string) (*User, error) {
 //   n == 0: the result is nil (zero fields)
 //   n < 0: all fields
 func LookupInUser(field userField, value interface{}, n int) ([]*User, error) {
-	iEntries, err := lookUp(&User{}, field, value, n)
+	return defaultRoot.LookupInUser(field, value, n)
+}
+
+// LookupInUser looks up an user by the given values in the root directory.
+func (r *Root) LookupInUser(field userField, value interface{}, n int) ([]*User, error) {
+	iEntries, err := lookUp(r, &User{}, field, value, n)
 	if err != nil {
 		return nil, err
 	}
@@ -294,101 +319,128 @@ func GetUsernameFromEnv() string {
 //
 
 // AddUser adds an user to both user and shadow files.
+//
+// The home directory is created, after of adding the user, whether it is set
+// CREATE_HOME in the configuration; see CreateHome.
 func AddUser(name string, gid int) (uid int, err error) {
-	s := NewShadow(name)
-	if err = s.Add(nil); err != nil {
-		return
-	}
+	return defaultRoot.AddUser(name, gid)
+}
 
-	return NewUser(name, gid).Add()
+// AddUser adds an user to both user and shadow files in the root directory.
+func (r *Root) AddUser(name string, gid int) (uid int, err error) {
+	return r.addUser(r.NewUser(name, gid))
 }
 
 // AddSystemUser adds a system user to both user and shadow files.
 func AddSystemUser(name, homeDir string, gid int) (uid int, err error) {
-	s := NewShadow(name)
-	if err = s.Add(nil); err != nil {
-		return
+	return defaultRoot.AddSystemUser(name, homeDir, gid)
+}
+
+// AddSystemUser adds a system user to both user and shadow files in the root
+// directory.
+func (r *Root) AddSystemUser(name, homeDir string, gid int) (uid int, err error) {
+	return r.addUser(NewSystemUser(name, homeDir, gid))
+}
+
+// addUser adds the user and its shadowed user into a same transaction.
+func (r *Root) addUser(u *User) (uid int, err error) {
+	action := "AddUser"
+	if u.addSystemUser {
+		action = "AddSystemUser"
 	}
+	tx := r.beginAction(action)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 
-	return NewSystemUser(name, homeDir, gid).Add()
+	if err = tx.AddShadow(r.NewShadow(u.Name), nil); err != nil {
+		return 0, err
+	}
+	if uid, err = tx.AddUser(u); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return uid, r.createHomeAdded(action, u)
 }
 
 // Add adds a new user.
 // Whether UID is < 0, it will choose the first id available in the range set
 // in the system configuration.
-func (u *User) Add() (uid int, err error) {
-	loadConfig()
+//
+// The home directory is created, after of adding the user, whether it is set
+// by SetCreateHome, or by CREATE_HOME in the configuration for users that are
+// not of system.
+func (u *User) Add() (uid int, err error) { return u.AddAt(defaultRoot) }
 
-	user, err := LookupUser(u.Name)
-	if err != nil {
-		if _, ok := err.(NoFoundError); !ok {
-			return
-		}
+// AddAt adds a new user in the root directory.
+// Whether UID is < 0, it will choose the first id available in the range set
+// in the configuration of the root directory.
+func (u *User) AddAt(r *Root) (uid int, err error) {
+	tx := r.beginAction("User.Add")
+
+	if uid, err = tx.AddUser(u); err != nil {
+		tx.Rollback()
+		return 0, err
 	}
-	if user != nil {
-		return 0, ErrUserExist
+	if err = tx.Commit(); err != nil {
+		return 0, err
 	}
+	return uid, r.createHomeAdded("User.Add", u)
+}
 
-	if u.Name == "" {
-		return 0, RequiredError("Name")
-	}
-	if u.Dir == "" {
-		return 0, RequiredError("Dir")
+// DelUser removes an user from the system.
+func DelUser(name string) error { return defaultRoot.DelUser(name) }
+
+// DelUser removes an user from the root directory.
+func (r *Root) DelUser(name string) error {
+	tx := r.beginAction("DelUser")
+
+	if err := tx.DelUser(name); err != nil {
+		tx.Rollback()
+		return err
 	}
-	if u.Dir == config.useradd.HOME {
-		return 0, HomeError(config.useradd.HOME)
+	return tx.Commit()
+}
+
+// checkFields reports FieldError whether some of the given fields has a value
+// which can not be stored: an id < 0, or a text with a separator of rows or
+// fields.
+func (u *User) checkFields(fields userField) error {
+	if fields&U_UID != 0 && u.UID < 0 {
+		return FieldError{U_UID.String(), u.UID}
 	}
-	if u.Shell == "" {
-		return 0, RequiredError("Shell")
+	if fields&U_GID != 0 && u.GID < 0 {
+		return FieldError{U_GID.String(), u.GID}
 	}
 
-	var db *dbfile
-
-	if u.UID < 0 {
-		db, uid, err = nextUID(u.addSystemUser)
-		if err != nil {
-			return 0, err
-		}
-		defer func() {
-			if e := db.close(); e != nil && err == nil {
-				err = e
-			}
-		}()
-
-		u.UID = uid
-	} else {
-		db, err = openDBFile(fileUser, os.O_WRONLY|os.O_APPEND)
-		if err != nil {
-			return 0, err
-		}
-		defer func() {
-			if e := db.close(); e != nil && err == nil {
-				err = e
+	for _, f := range []struct {
+		field userField
+		value string
+	}{
+		{U_GECOS, u.Gecos},
+		{U_DIR, u.Dir},
+		{U_SHELL, u.Shell},
+	} {
+		if fields&f.field != 0 {
+			if err := checkText(f.field.String(), f.value); err != nil {
+				return err
 			}
-		}()
-
-		// Check if Id is unique.
-		_, err = LookupUID(u.UID)
-		if err == nil {
-			return 0, IdUsedError(u.UID)
-		} else if _, ok := err.(NoFoundError); !ok {
-			return 0, err
 		}
 	}
-
-	u.password = "x"
-
-	_, err = db.file.WriteString(u.String())
-	return
+	return nil
 }
 
-// DelUser removes an user from the system.
-func DelUser(name string) (err error) {
-	err = del(name, &User{})
-	if err == nil {
-		err = del(name, &Shadow{})
+// checkText reports FieldError whether the value of the named field has a
+// separator of rows or fields of the databases.
+func checkText(field, value string) error {
+	if strings.ContainsAny(value, ":\n") {
+		return FieldError{field, value}
 	}
-	return
+	return nil
 }
 
 // == Errors
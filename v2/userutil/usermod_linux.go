@@ -0,0 +1,400 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// dirMail is the directory of the mail spools.
+const dirMail = "/var/mail"
+
+// ModOption represents the options to modify an user, for the changes that are
+// done out of the databases.
+type ModOption int
+
+const (
+	// Move the content of the home directory to the new one.
+	MOD_MOVE_HOME ModOption = 1 << iota
+
+	// Change the owner of the files, under the home directory and the mail spool,
+	// from the old UID and GID to the new ones.
+	MOD_CHOWN
+)
+
+// ModifyUser changes the given fields of an user, like "usermod(8)".
+// The values are got from u, for the fields: U_NAME, U_UID, U_GID, U_GECOS,
+// U_DIR and U_SHELL.
+//
+// Whether the name is changed, the shadowed user, the lists of members in
+// groups and the ranges of subordinate ids are also updated.
+func ModifyUser(name string, u *User, fields userField, opt ModOption) error {
+	return defaultRoot.ModifyUser(name, u, fields, opt)
+}
+
+// ModifyUser changes the given fields of an user in the root directory.
+//
+// The mail spool is renamed, and the files changed of owner, after of committing
+// the databases; so whether it is reported ModifyFilesError,
+// the user has been modified but not all of its files.
+func (r *Root) ModifyUser(name string, u *User, fields userField, opt ModOption) (err error) {
+	tx := r.beginAction("ModifyUser")
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	old, err := tx.ModifyUser(name, u, fields)
+	if err != nil {
+		return err
+	}
+	newName := old.Name
+	if fields&U_NAME != 0 {
+		newName = u.Name
+	}
+	newUID, newGID, newDir := old.UID, old.GID, old.Dir
+	if fields&U_UID != 0 {
+		newUID = u.UID
+	}
+	if fields&U_GID != 0 {
+		newGID = u.GID
+	}
+	if fields&U_DIR != 0 {
+		newDir = u.Dir
+	}
+
+	homeMoved := false
+	if opt&MOD_MOVE_HOME != 0 && newDir != old.Dir {
+		if _, err = os.Lstat(r.join(newDir)); err == nil {
+			return HomeError(newDir)
+		}
+		if err = moveDir(r.join(old.Dir), r.join(newDir)); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+		} else {
+			homeMoved = true
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		if homeMoved {
+			moveDir(r.join(newDir), r.join(old.Dir))
+		}
+		return err
+	}
+
+	// The mail spool follows the name.
+	var errs []error
+	spool := r.join(filepath.Join(dirMail, newName))
+	if newName != old.Name {
+		oldSpool := r.join(filepath.Join(dirMail, old.Name))
+		if e := os.Rename(oldSpool, spool); e != nil && !os.IsNotExist(e) {
+			errs = append(errs, e)
+			spool = oldSpool
+		}
+	}
+
+	if opt&MOD_CHOWN != 0 && (newUID != old.UID || newGID != old.GID) {
+		for _, p := range []string{r.join(newDir), spool} {
+			if e := chownTree(p, old.UID, old.GID, newUID, newGID); e != nil {
+				errs = append(errs, e)
+			}
+		}
+	}
+
+	if errs != nil {
+		return ModifyFilesError{newName, errs}
+	}
+	return nil
+}
+
+// ModifyUser stages the changes of the given fields of an user.
+// Returns the user before of be modified.
+func (tx *Tx) ModifyUser(name string, u *User, fields userField) (old *User, err error) {
+	tx.r.loadConfig()
+
+	if old, err = tx.LookupUser(name); err != nil {
+		return nil, err
+	}
+	newUser := *old
+
+	if fields&U_NAME != 0 && u.Name != name {
+		if u.Name == "" {
+			return nil, RequiredError("Name")
+		}
+		if err = tx.r.ValidateName(u.Name, old.UID < tx.r.config.login.UID_MIN); err != nil {
+			return nil, err
+		}
+		if _, err = tx.LookupUser(u.Name); err == nil {
+			return nil, ErrUserExist
+		} else if _, ok := err.(NoFoundError); !ok {
+			return nil, err
+		}
+		newUser.Name = u.Name
+	}
+	if err = u.checkFields(fields); err != nil {
+		return nil, err
+	}
+
+	if fields&U_UID != 0 && u.UID != old.UID {
+		entry, err := tx.lookUp(u, U_UID, u.UID)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			return nil, IdUsedError(u.UID)
+		}
+		newUser.UID = u.UID
+	}
+	if fields&U_GID != 0 && u.GID != old.GID {
+		if _, err = tx.get(&Group{}, G_GID, u.GID); err != nil {
+			return nil, err
+		}
+		newUser.GID = u.GID
+	}
+	if fields&U_GECOS != 0 {
+		newUser.Gecos = u.Gecos
+	}
+	if fields&U_DIR != 0 {
+		if u.Dir == "" {
+			return nil, RequiredError("Dir")
+		}
+		newUser.Dir = u.Dir
+	}
+	if fields&U_SHELL != 0 {
+		if u.Shell == "" {
+			return nil, RequiredError("Shell")
+		}
+		newUser.Shell = u.Shell
+	}
+
+	if err = tx.EditUser(name, &newUser); err != nil {
+		return nil, err
+	}
+
+	if newUser.Name != name {
+		if err = tx.renameUser(name, newUser.Name); err != nil {
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// renameUser stages the change of the user name in the shadowed user, in the
+// lists of members of groups, and in the ranges of subordinate ids.
+func (tx *Tx) renameUser(oldName, newName string) error {
+	s, err := tx.LookupShadow(oldName)
+	if err == nil {
+		s.Name = newName
+		if err = tx.EditShadow(oldName, s); err != nil {
+			return err
+		}
+	} else if _, ok := err.(NoFoundError); !ok {
+		return err
+	}
+
+	f, err := tx.load(&Group{})
+	if err != nil {
+		return err
+	}
+	for _, line := range f.lines {
+		gr, err := parseGroup(line)
+		if err != nil {
+			return err
+		}
+		if replaceMember(gr.UserList, oldName, newName) {
+			if err = tx.EditGroup(gr.Name, gr); err != nil {
+				return err
+			}
+		}
+	}
+
+	if tx.r.hasGshadow() {
+		if f, err = tx.load(&GShadow{}); err != nil {
+			return err
+		}
+		for _, line := range f.lines {
+			gs, err := parseGShadow(line)
+			if err != nil {
+				return err
+			}
+			isAdmin := replaceMember(gs.AdminList, oldName, newName)
+			if replaceMember(gs.UserList, oldName, newName) || isAdmin {
+				if err = tx.EditGShadow(gs.Name, gs); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tx.renameSubIDs(oldName, newName)
+}
+
+// replaceMember replaces the old name by the new one into the list.
+// Reports whether it has been replaced.
+func replaceMember(list []string, oldName, newName string) bool {
+	for i, v := range list {
+		if v == oldName {
+			list[i] = newName
+			return true
+		}
+	}
+	return false
+}
+
+// == Files
+//
+
+// moveDir moves a directory. Whether it is moved to another file system, it is
+// copied and then removed.
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		return err
+	}
+
+	if err = copyTree(src, dst, -1, -1); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies the directory src to dst, keeping the permissions and the
+// symbolic links. The files are owned by uid and gid, or by the same owner of
+// the source whether they are < 0.
+func copyTree(src, dst string, uid, gid int) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		fileUID, fileGID := uid, gid
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if fileUID < 0 {
+				fileUID = int(st.Uid)
+			}
+			if fileGID < 0 {
+				fileGID = int(st.Gid)
+			}
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err = os.Mkdir(target, mode.Perm()); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err = os.Symlink(link, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err = copyFile(path, target, mode.Perm()); err != nil {
+				return err
+			}
+		default: // Skip devices, pipes and sockets.
+			return nil
+		}
+
+		if err = os.Lchown(target, fileUID, fileGID); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			// The permissions set at creating are filtered by the umask.
+			return os.Chmod(target, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file src to dst.
+func copyFile(src, dst string, perm os.FileMode) (err error) {
+	fsrc, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fsrc.Close()
+
+	fdst, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := fdst.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	_, err = io.Copy(fdst, fsrc)
+	return
+}
+
+// chownTree changes the owner of the files under the named path, from oldUID
+// to newUID and from oldGID to newGID. The symbolic links are not followed.
+// It does nothing whether the path does not exist.
+func chownTree(name string, oldUID, oldGID, newUID, newGID int) error {
+	err := filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		uid, gid := -1, -1
+		if int(st.Uid) == oldUID {
+			uid = newUID
+		}
+		if int(st.Gid) == oldGID {
+			gid = newGID
+		}
+		if uid == -1 && gid == -1 {
+			return nil
+		}
+		return os.Lchown(path, uid, gid)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// == Errors
+//
+
+// A ModifyFilesError records the errors at changing the files of an user, after
+// of modifying it in the databases.
+type ModifyFilesError struct {
+	Name string
+	Errs []error
+}
+
+func (e ModifyFilesError) Error() string {
+	str := "user " + strconv.Quote(e.Name) + " modified, but not its files"
+	for _, err := range e.Errs {
+		str += "; " + err.Error()
+	}
+	return str
+}
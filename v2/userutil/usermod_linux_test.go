@@ -0,0 +1,116 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestModifyUser(t *testing.T) {
+	r := newTestRoot(t)
+
+	uid, err := r.AddUser(USER, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.AddGroup(GROUP, "daemon", USER); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := r.LookupUser(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := r.join(u.Dir)
+	if err = os.MkdirAll(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fileHome := filepath.Join(home, ".profile")
+	if err = os.WriteFile(fileHome, []byte("umask 022\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Lchown(fileHome, uid, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors
+	err = r.ModifyUser(USER, &User{Name: "daemon"}, U_NAME, 0)
+	if err != ErrUserExist {
+		t.Errorf("expected to report ErrUserExist, got %v", err)
+	}
+	err = r.ModifyUser(USER, &User{UID: 1}, U_UID, 0)
+	if _, ok := err.(IdUsedError); !ok {
+		t.Errorf("expected to report IdUsedError, got %v", err)
+	}
+	err = r.ModifyUser(USER, &User{GID: 4242}, U_GID, 0)
+	if _, ok := err.(NoFoundError); !ok {
+		t.Errorf("expected to report NoFoundError, got %v", err)
+	}
+	for _, d := range []struct {
+		u      *User
+		fields userField
+	}{
+		{&User{UID: -2}, U_UID},
+		{&User{Gecos: "foo:bar"}, U_GECOS},
+		{&User{Dir: "/home/foo\nroot::0:0::/:/bin/sh"}, U_DIR},
+		{&User{Shell: "/bin/sh:"}, U_SHELL},
+	} {
+		err = r.ModifyUser(USER, d.u, d.fields, 0)
+		if e, ok := err.(FieldError); !ok || e.Field != d.fields.String() {
+			t.Errorf("%s: expected to report FieldError, got %v", d.fields, err)
+		}
+	}
+
+	mod := &User{Name: USER2, UID: 2000, Dir: "/home/" + USER2, Shell: "/bin/bash"}
+	err = r.ModifyUser(USER, mod, U_NAME|U_UID|U_DIR|U_SHELL, MOD_MOVE_HOME|MOD_CHOWN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = r.LookupUser(USER); err == nil {
+		t.Errorf("expected to rename the user %q", USER)
+	}
+	u, err = r.LookupUser(USER2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.UID != 2000 || u.GID != 100 || u.Dir != mod.Dir || u.Shell != mod.Shell {
+		t.Errorf("user not modified: %s", u)
+	}
+	if _, err = r.LookupShadow(USER2); err != nil {
+		t.Error(err)
+	}
+
+	g, err := r.LookupGroup(GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkGroup(g.UserList, USER2) || checkGroup(g.UserList, USER) {
+		t.Errorf("expected to rename the member of group: %v", g.UserList)
+	}
+	gs, err := r.LookupGShadow(GROUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkGroup(gs.UserList, USER2) {
+		t.Errorf("expected to rename the member of shadowed group: %v", gs.UserList)
+	}
+
+	info, err := os.Stat(filepath.Join(r.join(mod.Dir), ".profile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); st.Uid != 2000 {
+		t.Errorf("expected to change the owner to UID 2000, got %d", st.Uid)
+	}
+	if _, err = os.Stat(home); !os.IsNotExist(err) {
+		t.Error("expected to move the home directory")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"time"
 )
 
 var isRoot bool
@@ -57,3 +58,6 @@ const _SEC_PER_DAY = 24 * 60 * 60
 
 // secToDay converts from secons to days.
 func secToDay(sec int64) int { return int(sec / _SEC_PER_DAY) }
+
+// dayToTime converts from days since Jan 1, 1970 to time, in UTC.
+func dayToTime(day int) time.Time { return time.Unix(int64(day)*_SEC_PER_DAY, 0).UTC() }
@@ -0,0 +1,407 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+// Files of the login records.
+const (
+	fileUtmp    = "/var/run/utmp" // Current sessions.
+	fileWtmp    = "/var/log/wtmp" // History of logins and logouts.
+	fileBtmp    = "/var/log/btmp" // Failed logins.
+	fileLastlog = "/var/log/lastlog"
+)
+
+// Sizes of the records, like in glibc for 64-bit and 32-bit systems.
+const (
+	utmpSize    = 384
+	lastlogSize = 292
+)
+
+// nativeEndian is the byte order used in the files of records.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// An UtmpType represents the kind of record, like in "utmp(5)".
+type UtmpType int16
+
+const (
+	UT_EMPTY         UtmpType = iota // Record not valid.
+	UT_RUN_LVL                       // Change in the run-level.
+	UT_BOOT_TIME                     // Time of system boot.
+	UT_NEW_TIME                      // Time after of changing the system clock.
+	UT_OLD_TIME                      // Time before of changing the system clock.
+	UT_INIT_PROCESS                  // Process spawned by "init(8)".
+	UT_LOGIN_PROCESS                 // Session leader process for user login.
+	UT_USER_PROCESS                  // Normal process.
+	UT_DEAD_PROCESS                  // Terminated process.
+	UT_ACCOUNTING
+)
+
+func (t UtmpType) String() string {
+	switch t {
+	case UT_EMPTY:
+		return "EMPTY"
+	case UT_RUN_LVL:
+		return "RUN_LVL"
+	case UT_BOOT_TIME:
+		return "BOOT_TIME"
+	case UT_NEW_TIME:
+		return "NEW_TIME"
+	case UT_OLD_TIME:
+		return "OLD_TIME"
+	case UT_INIT_PROCESS:
+		return "INIT_PROCESS"
+	case UT_LOGIN_PROCESS:
+		return "LOGIN_PROCESS"
+	case UT_USER_PROCESS:
+		return "USER_PROCESS"
+	case UT_DEAD_PROCESS:
+		return "DEAD_PROCESS"
+	case UT_ACCOUNTING:
+		return "ACCOUNTING"
+	}
+	return "UNKNOWN"
+}
+
+// An Utmp represents a record of the files utmp, wtmp and btmp.
+type Utmp struct {
+	Type UtmpType
+	PID  int
+
+	Line string // Device name of the tty, without '/dev/'.
+	ID   string // Terminal name suffix, or "inittab(5)" id.
+	User string
+	Host string // Remote host name, or kernel version for run-level records.
+
+	ExitTermination int // Status of a process marked as UT_DEAD_PROCESS.
+	ExitStatus      int
+
+	Session int
+	Time    time.Time
+	Addr    net.IP // Remote address, if any.
+}
+
+// parseUtmp parses a record in binary format.
+func parseUtmp(b []byte) *Utmp {
+	u := &Utmp{
+		Type: UtmpType(int16(nativeEndian.Uint16(b[0:]))),
+		PID:  int(int32(nativeEndian.Uint32(b[4:]))),
+		Line: cString(b[8:40]),
+		ID:   cString(b[40:44]),
+		User: cString(b[44:76]),
+		Host: cString(b[76:332]),
+
+		ExitTermination: int(int16(nativeEndian.Uint16(b[332:]))),
+		ExitStatus:      int(int16(nativeEndian.Uint16(b[334:]))),
+		Session:         int(int32(nativeEndian.Uint32(b[336:]))),
+	}
+
+	sec := int64(int32(nativeEndian.Uint32(b[340:])))
+	usec := int64(int32(nativeEndian.Uint32(b[344:])))
+	u.Time = time.Unix(sec, usec*1000)
+
+	addr := b[348:364]
+	switch {
+	case bytes.Count(addr, []byte{0}) == len(addr):
+	case bytes.Count(addr[4:], []byte{0}) == len(addr[4:]): // IPv4
+		u.Addr = net.IPv4(addr[0], addr[1], addr[2], addr[3])
+	default:
+		u.Addr = append(net.IP(nil), addr...)
+	}
+	return u
+}
+
+// cString returns the string of a field ended with null character.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// == Reader
+//
+
+// An UtmpIter is an iterator over the records of a file in format "utmp(5)",
+// like "bufio.Scanner".
+type UtmpIter struct {
+	rd    *bufio.Reader
+	c     io.Closer
+	buf   [utmpSize]byte
+	entry *Utmp
+	err   error
+}
+
+// NewUtmpIter returns an iterator over the records read from rd.
+func NewUtmpIter(rd io.Reader) *UtmpIter {
+	return &UtmpIter{rd: bufio.NewReader(rd)}
+}
+
+// Next advances to the next record. It returns false at the end, or at failing.
+func (it *UtmpIter) Next() bool {
+	if it.rd == nil {
+		return false
+	}
+
+	if _, err := io.ReadFull(it.rd, it.buf[:]); err != nil {
+		if err != io.EOF {
+			it.err = err
+		}
+		it.Close()
+		return false
+	}
+	it.entry = parseUtmp(it.buf[:])
+	return true
+}
+
+// Utmp returns the current record.
+func (it *UtmpIter) Utmp() *Utmp { return it.entry }
+
+// Err returns the first error found, if any.
+func (it *UtmpIter) Err() error { return it.err }
+
+// Close releases the file, whether the iterator was got from a file.
+func (it *UtmpIter) Close() error {
+	it.rd = nil
+	it.entry = nil
+
+	if it.c == nil {
+		return nil
+	}
+	err := it.c.Close()
+	it.c = nil
+	return err
+}
+
+// openUtmp returns an iterator over the records of the named file in the root
+// directory.
+func (r *Root) openUtmp(name string) (*UtmpIter, error) {
+	f, err := os.Open(r.join(name))
+	if err != nil {
+		return nil, err
+	}
+	it := NewUtmpIter(f)
+	it.c = f
+	return it, nil
+}
+
+// readUtmp returns the records of the named file which match with the filter.
+func (r *Root) readUtmp(name string, filter func(*Utmp) bool) ([]*Utmp, error) {
+	it, err := r.openUtmp(name)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var entries []*Utmp
+	for it.Next() {
+		if filter(it.Utmp()) {
+			entries = append(entries, it.Utmp())
+		}
+	}
+	return entries, it.Err()
+}
+
+// == Sessions
+//
+
+// Sessions returns the users logged in the running system.
+// See Root.Sessions.
+func Sessions() ([]*Utmp, error) { return defaultRoot.Sessions() }
+
+// Sessions returns the users logged in, got from the file '/var/run/utmp' in the
+// root directory.
+func (r *Root) Sessions() ([]*Utmp, error) {
+	return r.readUtmp(fileUtmp, func(u *Utmp) bool {
+		return u.Type == UT_USER_PROCESS && u.User != ""
+	})
+}
+
+// A Login represents a session of an user, got from the history of logins.
+type Login struct {
+	User string
+	Line string
+	Host string
+	Addr net.IP
+
+	Login  time.Time
+	Logout time.Time // Zero whether the session is still open or it is unknown.
+}
+
+// LoginHistory returns the sessions of the user in the running system.
+// See Root.LoginHistory.
+func LoginHistory(name string) ([]*Login, error) { return defaultRoot.LoginHistory(name) }
+
+// LoginHistory returns the sessions of the user, or of all users whether name is
+// empty, got from the file '/var/log/wtmp' in the root directory, like "last(1)".
+// The sessions are ordered by time of login.
+//
+// The logout of a session is the next record in the same line, which is either
+// an UT_DEAD_PROCESS, another login, or a boot of the system.
+func (r *Root) LoginHistory(name string) ([]*Login, error) {
+	it, err := r.openUtmp(fileWtmp)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var logins []*Login
+	open := make(map[string]*Login) // key: line
+
+	for it.Next() {
+		u := it.Utmp()
+
+		switch u.Type {
+		case UT_USER_PROCESS:
+			if l, ok := open[u.Line]; ok {
+				l.Logout = u.Time
+			}
+			l := &Login{User: u.User, Line: u.Line, Host: u.Host, Addr: u.Addr, Login: u.Time}
+			open[u.Line] = l
+
+			if name == "" || u.User == name {
+				logins = append(logins, l)
+			}
+		case UT_DEAD_PROCESS:
+			if l, ok := open[u.Line]; ok {
+				l.Logout = u.Time
+				delete(open, u.Line)
+			}
+		case UT_BOOT_TIME:
+			for line, l := range open {
+				l.Logout = u.Time
+				delete(open, line)
+			}
+		}
+	}
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(logins, func(i, j int) bool { return logins[i].Login.Before(logins[j].Login) })
+	return logins, nil
+}
+
+// FailedLogins returns the failed logins of the user in the running system.
+// See Root.FailedLogins.
+func FailedLogins(name string) ([]*Utmp, error) { return defaultRoot.FailedLogins(name) }
+
+// FailedLogins returns the failed logins of the user, or of all users whether
+// name is empty, got from the file '/var/log/btmp' in the root directory, like
+// "lastb(1)".
+func (r *Root) FailedLogins(name string) ([]*Utmp, error) {
+	return r.readUtmp(fileBtmp, func(u *Utmp) bool {
+		return u.Type != UT_EMPTY && (name == "" || u.User == name)
+	})
+}
+
+// == Lastlog
+//
+
+// A Lastlog represents the last login of an user.
+type Lastlog struct {
+	Name string // Only set by LastLogins.
+	UID  int
+	Time time.Time // Zero whether the user has never logged in.
+	Line string
+	Host string
+}
+
+// parseLastlog parses a record in binary format.
+func parseLastlog(uid int, b []byte) *Lastlog {
+	l := &Lastlog{UID: uid}
+
+	if sec := int64(int32(nativeEndian.Uint32(b[0:]))); sec != 0 {
+		l.Time = time.Unix(sec, 0)
+		l.Line = cString(b[4:36])
+		l.Host = cString(b[36:292])
+	}
+	return l
+}
+
+// readLastlog reads the record of the user id from the file of last logins.
+func readLastlog(f *os.File, uid int) (*Lastlog, error) {
+	var b [lastlogSize]byte
+
+	if _, err := f.ReadAt(b[:], int64(uid)*lastlogSize); err != nil {
+		if err == io.EOF {
+			return &Lastlog{UID: uid}, nil
+		}
+		return nil, err
+	}
+	return parseLastlog(uid, b[:]), nil
+}
+
+// LastLogin returns the last login of the user id in the running system.
+// See Root.LastLogin.
+func LastLogin(uid int) (*Lastlog, error) { return defaultRoot.LastLogin(uid) }
+
+// LastLogin returns the last login of the user id, got from the file
+// '/var/log/lastlog' in the root directory.
+func (r *Root) LastLogin(uid int) (*Lastlog, error) {
+	f, err := os.Open(r.join(fileLastlog))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLastlog(f, uid)
+}
+
+// LastLogins returns the last login of every user in the running system.
+// See Root.LastLogins.
+func LastLogins() ([]*Lastlog, error) { return defaultRoot.LastLogins() }
+
+// LastLogins returns the last login of every user in the root directory, got
+// from the file '/var/log/lastlog', like "lastlog(8)". It is useful to find the
+// inactive accounts.
+//
+// The file is sparse, indexed by user id, so it is only read the record of
+// every user found in the database.
+func (r *Root) LastLogins() ([]*Lastlog, error) {
+	f, err := os.Open(r.join(fileLastlog))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	it, err := r.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var logs []*Lastlog
+	for it.Next() {
+		u := it.User()
+
+		l, err := readLastlog(f, u.UID)
+		if err != nil {
+			return nil, err
+		}
+		l.Name = u.Name
+		logs = append(logs, l)
+	}
+	return logs, it.Err()
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 Jonas mg
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package userutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// utmpRecord returns a record in binary format.
+func utmpRecord(typ UtmpType, pid int, line, user, host string, sec int64, addr []byte) []byte {
+	b := make([]byte, utmpSize)
+
+	nativeEndian.PutUint16(b[0:], uint16(typ))
+	nativeEndian.PutUint32(b[4:], uint32(pid))
+	copy(b[8:40], line)
+	copy(b[44:76], user)
+	copy(b[76:332], host)
+	nativeEndian.PutUint32(b[340:], uint32(sec))
+	copy(b[348:364], addr)
+	return b
+}
+
+// writeRecords writes the records into the named file of the root directory.
+func writeRecords(t *testing.T, r *Root, name string, records ...[]byte) {
+	t.Helper()
+	name = r.join(name)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, bytes.Join(records, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	r := newTestRoot(t)
+
+	writeRecords(t, r, fileUtmp,
+		utmpRecord(UT_BOOT_TIME, 0, "~", "reboot", "5.10.0", 1000, nil),
+		utmpRecord(UT_LOGIN_PROCESS, 10, "tty1", "LOGIN", "", 1001, nil),
+		utmpRecord(UT_USER_PROCESS, 20, "pts/0", USER, "example.com", 1002, []byte{10, 0, 0, 1}),
+		utmpRecord(UT_DEAD_PROCESS, 30, "pts/1", "", "", 1003, nil),
+	)
+
+	sessions, err := r.Sessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(sessions))
+	}
+	s := sessions[0]
+	if s.User != USER || s.Line != "pts/0" || s.Host != "example.com" || s.PID != 20 ||
+		s.Time.Unix() != 1002 || s.Addr.String() != "10.0.0.1" {
+		t.Errorf("session not expected: %+v", s)
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	r := newTestRoot(t)
+
+	writeRecords(t, r, fileWtmp,
+		utmpRecord(UT_BOOT_TIME, 0, "~", "reboot", "", 1000, nil),
+		utmpRecord(UT_USER_PROCESS, 20, "pts/0", USER, "", 1010, nil),
+		utmpRecord(UT_USER_PROCESS, 21, "pts/1", "root", "", 1020, nil),
+		utmpRecord(UT_DEAD_PROCESS, 20, "pts/0", "", "", 1030, nil),
+		utmpRecord(UT_USER_PROCESS, 22, "tty1", USER, "", 1040, nil),
+		utmpRecord(UT_BOOT_TIME, 0, "~", "reboot", "", 1050, nil),
+		utmpRecord(UT_USER_PROCESS, 23, "pts/0", USER, "", 1060, nil),
+	)
+
+	logins, err := r.LoginHistory(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		line          string
+		login, logout int64
+	}{
+		{"pts/0", 1010, 1030},
+		{"tty1", 1040, 1050},
+		{"pts/0", 1060, 0},
+	}
+	if len(logins) != len(want) {
+		t.Fatalf("expected %d logins, got %d", len(want), len(logins))
+	}
+	for i, l := range logins {
+		w := want[i]
+
+		if l.User != USER || l.Line != w.line || l.Login.Unix() != w.login {
+			t.Errorf("login not expected: %+v", l)
+		}
+		if (w.logout == 0 && !l.Logout.IsZero()) || (w.logout != 0 && l.Logout.Unix() != w.logout) {
+			t.Errorf("logout not expected: %+v", l)
+		}
+	}
+
+	if logins, err = r.LoginHistory(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 4 || logins[1].User != "root" || logins[1].Logout.Unix() != 1050 {
+		t.Errorf("logins not expected: %v", logins)
+	}
+}
+
+func TestFailedLogins(t *testing.T) {
+	r := newTestRoot(t)
+
+	writeRecords(t, r, fileBtmp,
+		utmpRecord(UT_LOGIN_PROCESS, 20, "ssh:notty", "admin", "192.0.2.1", 1000, nil),
+		utmpRecord(UT_LOGIN_PROCESS, 21, "ssh:notty", USER, "192.0.2.2", 1010, nil),
+		utmpRecord(UT_LOGIN_PROCESS, 22, "ssh:notty", USER, "192.0.2.3", 1020, nil),
+	)
+
+	failed, err := r.FailedLogins(USER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 || failed[1].Host != "192.0.2.3" {
+		t.Errorf("failed logins not expected: %v", failed)
+	}
+
+	// Truncated record.
+	writeRecords(t, r, fileBtmp, utmpRecord(UT_LOGIN_PROCESS, 20, "", USER, "", 1000, nil)[:100])
+	if _, err = r.FailedLogins(USER); err == nil {
+		t.Error("expected to report an error with a truncated record")
+	}
+}
+
+func TestLastLogin(t *testing.T) {
+	r := newTestRoot(t)
+
+	// Records for UIDs 0 (never logged in) and 1.
+	b := make([]byte, 2*lastlogSize)
+	rec := b[lastlogSize:]
+	nativeEndian.PutUint32(rec[0:], 1000)
+	copy(rec[4:36], "pts/0")
+	copy(rec[36:], "example.com")
+	writeRecords(t, r, fileLastlog, b)
+
+	l, err := r.LastLogin(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Time.Equal(time.Unix(1000, 0)) || l.Line != "pts/0" || l.Host != "example.com" {
+		t.Errorf("last login not expected: %+v", l)
+	}
+	for _, uid := range []int{0, 65534} {
+		if l, err = r.LastLogin(uid); err != nil {
+			t.Fatal(err)
+		}
+		if !l.Time.IsZero() {
+			t.Errorf("uid %d: expected to have never logged in: %+v", uid, l)
+		}
+	}
+
+	logins, err := r.LastLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 3 {
+		t.Fatalf("expected 3 users, got %d", len(logins))
+	}
+	for _, l := range logins {
+		if (l.Name == "daemon") == l.Time.IsZero() {
+			t.Errorf("last login not expected: %+v", l)
+		}
+	}
+}